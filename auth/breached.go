@@ -0,0 +1,146 @@
+// auth/breached.go
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachedPasswords reports whether a password is known to be compromised
+type BreachedPasswords interface {
+	Contains(password string) (bool, error)
+}
+
+// LoadBreachedPasswords opens a local breached password source. A directory
+// is read as k-anonymity range files (see NewBreachedPasswordRanges); a file
+// is loaded with LoadBreachedPasswordFile.
+func LoadBreachedPasswords(path string) (BreachedPasswords, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return NewBreachedPasswordRanges(path), nil
+	}
+	return LoadBreachedPasswordFile(path)
+}
+
+// breachedPasswordSet holds the SHA-1 hashes of breached passwords in memory
+type breachedPasswordSet map[string]struct{}
+
+// LoadBreachedPasswordFile reads a newline-delimited file into memory. Lines
+// holding a SHA-1 hex digest, optionally followed by ":count" as in the Pwned
+// Passwords downloads, are taken as hashes; any other line is a plain text
+// password. Empty lines are skipped.
+func LoadBreachedPasswordFile(path string) (BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	set := breachedPasswordSet{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		if hash, ok := parseSHA1Line(line); ok {
+			set[hash] = struct{}{}
+		} else {
+			set[sha1Hex(line)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	return set, nil
+}
+
+func (s breachedPasswordSet) Contains(password string) (bool, error) {
+	_, ok := s[sha1Hex(password)]
+	return ok, nil
+}
+
+// breachedPasswordRanges looks passwords up in k-anonymity range files
+type breachedPasswordRanges struct {
+	dir string
+}
+
+// NewBreachedPasswordRanges reads a directory of range files as served by the
+// Pwned Passwords range API: the file named after the first five hex digits of
+// a SHA-1 hash (with or without a .txt extension) lists the remaining 35
+// digits of every breached hash with that prefix, one "SUFFIX:count" per line.
+// Only the file for the password's prefix is read on each lookup.
+func NewBreachedPasswordRanges(dir string) BreachedPasswords {
+	return &breachedPasswordRanges{dir: dir}
+}
+
+func (r *breachedPasswordRanges) Contains(password string) (bool, error) {
+	hash := sha1Hex(password)
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := r.open(prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func (r *breachedPasswordRanges) open(prefix string) (*os.File, error) {
+	for _, name := range []string{prefix + ".txt", prefix, strings.ToLower(prefix) + ".txt", strings.ToLower(prefix)} {
+		f, err := os.Open(filepath.Join(r.dir, name))
+		if !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+// sha1Hex returns the upper case hex SHA-1 digest used by Pwned Passwords
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// parseSHA1Line recognises "HASH" and "HASH:count" lines
+func parseSHA1Line(line string) (string, bool) {
+	hash := line
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		hash = line[:i]
+	}
+
+	if len(hash) != 2*sha1.Size {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	return strings.ToUpper(hash), true
+}
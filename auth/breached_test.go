@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBreachedPasswordFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	content := "password\r\n\n" +
+		// SHA-1 of "letmein", as in the Pwned Passwords downloads
+		"B7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3:1234\n" +
+		// SHA-1 of "qwerty", lower case and without a count
+		"b1b3773a05c0ed0176787a4f1574ff0075f7521e\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	breached, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatalf("LoadBreachedPasswords() error = %v", err)
+	}
+
+	for password, want := range map[string]bool{
+		"password":          true,
+		"letmein":           true,
+		"qwerty":            true,
+		"correct horse":     false,
+		"":                  false,
+		"password\r":        false,
+		"B7A875FC1EA228B90": false,
+	} {
+		got, err := breached.Contains(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Contains(%q) = %v, want %v", password, got, want)
+		}
+	}
+}
+
+func TestBreachedPasswordRanges(t *testing.T) {
+	dir := t.TempDir()
+	// SHA-1 of "letmein" is B7A87 5FC1EA228B9061041B7CEC4BD3C52AB3CE3
+	content := "0018A45C4D1DEF81644B54AB7F969B88D65:3\n5FC1EA228B9061041B7CEC4BD3C52AB3CE3:1234\n"
+	if err := os.WriteFile(filepath.Join(dir, "B7A87.txt"), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	breached, err := LoadBreachedPasswords(dir)
+	if err != nil {
+		t.Fatalf("LoadBreachedPasswords() error = %v", err)
+	}
+
+	if got, err := breached.Contains("letmein"); err != nil || !got {
+		t.Errorf("Contains(letmein) = %v, %v, want true", got, err)
+	}
+	// No range file for this prefix
+	if got, err := breached.Contains("correct horse battery staple"); err != nil || got {
+		t.Errorf("Contains() = %v, %v, want false", got, err)
+	}
+}
+
+func TestLoadBreachedPasswords_Missing(t *testing.T) {
+	if _, err := LoadBreachedPasswords(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Errorf("LoadBreachedPasswords() error = nil, want error")
+	}
+}
@@ -0,0 +1,61 @@
+// auth/claims.go
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Claims are the claims carried by every access token issued by this service.
+// The subject is the user ID.
+type Claims struct {
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	TokenVersion uint   `json:"ver"`
+	jwt.RegisteredClaims
+}
+
+// The tokens this service signs for itself share the keys of access tokens.
+// Each kind is issued for its own audience, which access tokens never accept.
+const (
+	// PurposeVerifyEmail is the audience of tokens that confirm ownership of
+	// an email address
+	PurposeVerifyEmail = "verify_email"
+	// PurposeMFA is the audience of tokens that prove the password step of a
+	// login succeeded and can be exchanged, together with a second factor,
+	// for access tokens
+	PurposeMFA = "mfa"
+	// PurposeOIDCState is the audience of tokens that carry the state of a
+	// sign in at an external OpenID Connect provider between the redirect and
+	// the callback
+	PurposeOIDCState = "oidc_state"
+)
+
+// internalAudiences are rejected by ClaimsConfig.Validate
+var internalAudiences = []string{PurposeVerifyEmail, PurposeMFA, PurposeOIDCState}
+
+// EmailVerificationClaims are carried by email verification tokens. The
+// subject is the user ID; the address is included so that a token stops
+// working once the user changes their email.
+type EmailVerificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// MFAClaims are carried by "mfa pending" tokens. The subject is the user ID;
+// the token version ties the token to the user's current sessions.
+type MFAClaims struct {
+	TokenVersion uint `json:"ver"`
+	jwt.RegisteredClaims
+}
+
+// OIDCStateClaims are kept in a cookie while the user signs in at a provider.
+// The state, nonce and PKCE verifier are checked when the provider redirects
+// back. LinkUserID is set when a signed in user links a new identity.
+type OIDCStateClaims struct {
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	LinkUserID   uint   `json:"link_user_id,omitempty"`
+	jwt.RegisteredClaims
+}
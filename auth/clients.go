@@ -0,0 +1,54 @@
+// auth/clients.go
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Clients holds the credentials of trusted services, such as gateways calling
+// the token introspection endpoint. Only SHA-256 hashes of the secrets are
+// kept.
+type Clients map[string][sha256.Size]byte
+
+// NewClients builds a client list from client IDs and secrets
+func NewClients(secrets map[string]string) Clients {
+	clients := make(Clients, len(secrets))
+	for id, secret := range secrets {
+		clients[id] = sha256.Sum256([]byte(secret))
+	}
+	return clients
+}
+
+// LoadIntrospectionClients reads INTROSPECTION_CLIENTS, a comma separated list
+// of "client_id:client_secret" pairs. Introspection is closed to everyone when
+// it is empty.
+func LoadIntrospectionClients() (Clients, error) {
+	secrets := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("INTROSPECTION_CLIENTS"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		id, secret, found := strings.Cut(pair, ":")
+		if !found || id == "" || secret == "" {
+			return nil, fmt.Errorf("invalid INTROSPECTION_CLIENTS entry %q, want client_id:client_secret", pair)
+		}
+		if _, ok := secrets[id]; ok {
+			return nil, fmt.Errorf("introspection client %s is configured twice", id)
+		}
+		secrets[id] = secret
+	}
+
+	return NewClients(secrets), nil
+}
+
+// Authenticate reports whether secret belongs to the client
+func (c Clients) Authenticate(id, secret string) bool {
+	want, ok := c[id]
+	got := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(want[:], got[:]) == 1 && ok
+}
@@ -0,0 +1,35 @@
+package auth
+
+import "testing"
+
+func TestLoadIntrospectionClients(t *testing.T) {
+	t.Setenv("INTROSPECTION_CLIENTS", "gateway:s3cret, reports:other")
+
+	clients, err := LoadIntrospectionClients()
+	if err != nil {
+		t.Fatalf("LoadIntrospectionClients() error = %v", err)
+	}
+
+	tests := []struct {
+		id, secret string
+		want       bool
+	}{
+		{id: "gateway", secret: "s3cret", want: true},
+		{id: "reports", secret: "other", want: true},
+		{id: "gateway", secret: "other", want: false},
+		{id: "unknown", secret: "s3cret", want: false},
+		{id: "", secret: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := clients.Authenticate(tt.id, tt.secret); got != tt.want {
+			t.Errorf("Clients.Authenticate(%q, %q) = %v, want %v", tt.id, tt.secret, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"gateway", "gateway:", "a:1,a:2"} {
+		t.Setenv("INTROSPECTION_CLIENTS", invalid)
+		if _, err := LoadIntrospectionClients(); err == nil {
+			t.Errorf("LoadIntrospectionClients(%q) error = nil", invalid)
+		}
+	}
+}
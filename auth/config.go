@@ -0,0 +1,142 @@
+// auth/config.go
+package auth
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// defaultKeyID is used for the single HMAC key configured through JWT_SECRET
+const defaultKeyID = "default"
+
+// keySetConfig is the format of the file referenced by JWT_KEYS_FILE
+type keySetConfig struct {
+	ActiveKeyID string      `json:"active_kid"`
+	Keys        []keyConfig `json:"keys"`
+}
+
+type keyConfig struct {
+	ID             string `json:"kid"`
+	Algorithm      string `json:"alg"`
+	Secret         string `json:"secret"`
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKeyFile  string `json:"public_key_file"`
+}
+
+// LoadKeySet builds the key set from the environment. JWT_KEYS_FILE points to
+// a JSON key set; otherwise JWT_SECRET is used as a single HS256 key.
+func LoadKeySet() (*KeySet, error) {
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		return LoadKeySetFile(path)
+	}
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		return NewKeySet(defaultKeyID, NewHMACKey(defaultKeyID, []byte(secret)))
+	}
+
+	return nil, errors.New("no JWT keys configured: set JWT_KEYS_FILE or JWT_SECRET")
+}
+
+// LoadKeySetFile reads a JSON key set such as
+//
+//	{
+//	  "active_kid": "2024-06",
+//	  "keys": [
+//	    {"kid": "2024-06", "alg": "EdDSA", "private_key_file": "keys/ed25519.pem"},
+//	    {"kid": "2024-01", "alg": "RS256", "public_key_file": "keys/rsa.pub.pem"},
+//	    {"kid": "legacy", "alg": "HS256", "secret": "..."}
+//	  ]
+//	}
+//
+// Keys given only as a public key are accepted for verification but never
+// used for signing.
+func LoadKeySetFile(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg keySetConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	keys := make([]*Key, 0, len(cfg.Keys))
+	for _, kc := range cfg.Keys {
+		key, err := kc.load()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", kc.ID, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(cfg.ActiveKeyID, keys...)
+}
+
+func (kc keyConfig) load() (*Key, error) {
+	switch kc.Algorithm {
+	case AlgHS256:
+		if kc.Secret == "" {
+			return nil, errors.New("HS256 keys need a secret")
+		}
+		return NewHMACKey(kc.ID, []byte(kc.Secret)), nil
+
+	case AlgRS256:
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			return NewRSAKey(kc.ID, private), nil
+		}
+		pem, err := readPublicKeyFile(kc)
+		if err != nil {
+			return nil, err
+		}
+		public, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		return NewRSAPublicKey(kc.ID, public), nil
+
+	case AlgEdDSA:
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			return NewEdDSAKey(kc.ID, private.(ed25519.PrivateKey)), nil
+		}
+		pem, err := readPublicKeyFile(kc)
+		if err != nil {
+			return nil, err
+		}
+		public, err := jwt.ParseEdPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		return NewEdDSAPublicKey(kc.ID, public.(ed25519.PublicKey)), nil
+	}
+
+	return nil, fmt.Errorf("unsupported algorithm %q", kc.Algorithm)
+}
+
+func readPublicKeyFile(kc keyConfig) ([]byte, error) {
+	if kc.PublicKeyFile == "" {
+		return nil, errors.New("either private_key_file or public_key_file is required")
+	}
+	return os.ReadFile(kc.PublicKeyFile)
+}
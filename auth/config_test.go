@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadKeySetFile(t *testing.T) {
+	dir := t.TempDir()
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	edPath := writePEM(t, dir, "ed25519.pem", "PRIVATE KEY", edDER)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	rsaPath := writePEM(t, dir, "rsa.pub.pem", "PUBLIC KEY", rsaDER)
+
+	config := `{
+		"active_kid": "ed",
+		"keys": [
+			{"kid": "ed", "alg": "EdDSA", "private_key_file": "` + edPath + `"},
+			{"kid": "rs", "alg": "RS256", "public_key_file": "` + rsaPath + `"},
+			{"kid": "hs", "alg": "HS256", "secret": "secret_key"}
+		]
+	}`
+	configPath := filepath.Join(dir, "keys.json")
+	if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := LoadKeySetFile(configPath)
+	if err != nil {
+		t.Fatalf("LoadKeySetFile() error = %v", err)
+	}
+	if ks.Active().ID != "ed" || ks.Active().Algorithm != AlgEdDSA {
+		t.Errorf("LoadKeySetFile() active = %s/%s, want ed/EdDSA", ks.Active().ID, ks.Active().Algorithm)
+	}
+	if got := len(ks.Keys()); got != 3 {
+		t.Errorf("LoadKeySetFile() loaded %d keys, want 3", got)
+	}
+	for _, k := range ks.Keys() {
+		if k.ID == "rs" && k.CanSign() {
+			t.Errorf("public-only key %q can sign", k.ID)
+		}
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	t.Run("JWT_SECRET", func(t *testing.T) {
+		t.Setenv("JWT_KEYS_FILE", "")
+		t.Setenv("JWT_SECRET", "secret_key")
+
+		ks, err := LoadKeySet()
+		if err != nil {
+			t.Fatalf("LoadKeySet() error = %v", err)
+		}
+		if ks.Active().Algorithm != AlgHS256 {
+			t.Errorf("LoadKeySet() algorithm = %s, want HS256", ks.Active().Algorithm)
+		}
+	})
+
+	t.Run("nothing configured", func(t *testing.T) {
+		t.Setenv("JWT_KEYS_FILE", "")
+		t.Setenv("JWT_SECRET", "")
+
+		if _, err := LoadKeySet(); err == nil {
+			t.Errorf("LoadKeySet() error = nil, want error")
+		}
+	})
+}
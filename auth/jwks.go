@@ -0,0 +1,68 @@
+// auth/jwks.go
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+)
+
+// JWK is the public part of a signing key as described in RFC 7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the public keys of every asymmetric key in the set. HMAC
+// keys are shared secrets and are never included.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range ks.Keys() {
+		switch public := k.PublicKey().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				Use:       "sig",
+				KeyID:     k.ID,
+				Algorithm: k.Algorithm,
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				Use:       "sig",
+				KeyID:     k.ID,
+				Algorithm: k.Algorithm,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return set
+}
+
+// SigningAlgorithms lists the distinct algorithms of the published keys
+func (ks *KeySet) SigningAlgorithms() []string {
+	algs := []string{}
+	seen := map[string]bool{}
+	for _, k := range ks.JWKS().Keys {
+		if !seen[k.Algorithm] {
+			seen[k.Algorithm] = true
+			algs = append(algs, k.Algorithm)
+		}
+	}
+	return algs
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestKeySet_JWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	ks, _ := NewKeySet("ed",
+		NewEdDSAKey("ed", edKey),
+		NewRSAPublicKey("rs", &rsaKey.PublicKey),
+		NewHMACKey("hs", []byte("secret_key")),
+	)
+
+	jwks := ks.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("KeySet.JWKS() returned %d keys, want 2", len(jwks.Keys))
+	}
+
+	ed := jwks.Keys[0]
+	if ed.KeyID != "ed" || ed.KeyType != "OKP" || ed.Curve != "Ed25519" || ed.Algorithm != AlgEdDSA {
+		t.Errorf("KeySet.JWKS() ed25519 key = %+v", ed)
+	}
+	if x, _ := base64.RawURLEncoding.DecodeString(ed.X); !reflect.DeepEqual(ed25519.PublicKey(x), edPublic) {
+		t.Errorf("KeySet.JWKS() ed25519 x does not match public key")
+	}
+
+	rs := jwks.Keys[1]
+	if rs.KeyID != "rs" || rs.KeyType != "RSA" || rs.Algorithm != AlgRS256 {
+		t.Errorf("KeySet.JWKS() rsa key = %+v", rs)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(rs.N)
+	e, _ := base64.RawURLEncoding.DecodeString(rs.E)
+	if new(big.Int).SetBytes(n).Cmp(rsaKey.N) != 0 || int(new(big.Int).SetBytes(e).Int64()) != rsaKey.E {
+		t.Errorf("KeySet.JWKS() rsa modulus or exponent does not match public key")
+	}
+
+	if got := ks.SigningAlgorithms(); !reflect.DeepEqual(got, []string{AlgEdDSA, AlgRS256}) {
+		t.Errorf("KeySet.SigningAlgorithms() = %v", got)
+	}
+}
@@ -0,0 +1,158 @@
+// auth/keyset.go
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrUnknownKey        = errors.New("unknown signing key")
+	ErrAlgorithmMismatch = errors.New("token algorithm does not match key")
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key is a single JWT key identified by its kid. Keys without a private part
+// can only be used to verify tokens.
+type Key struct {
+	ID         string
+	Algorithm  string
+	signingKey interface{}
+	verifyKey  interface{}
+}
+
+// NewHMACKey returns an HS256 key that can both sign and verify
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Algorithm: AlgHS256, signingKey: secret, verifyKey: secret}
+}
+
+// NewRSAKey returns an RS256 signing key
+func NewRSAKey(id string, private *rsa.PrivateKey) *Key {
+	return &Key{ID: id, Algorithm: AlgRS256, signingKey: private, verifyKey: &private.PublicKey}
+}
+
+// NewRSAPublicKey returns an RS256 key that can only verify
+func NewRSAPublicKey(id string, public *rsa.PublicKey) *Key {
+	return &Key{ID: id, Algorithm: AlgRS256, verifyKey: public}
+}
+
+// NewEdDSAKey returns an Ed25519 signing key
+func NewEdDSAKey(id string, private ed25519.PrivateKey) *Key {
+	return &Key{ID: id, Algorithm: AlgEdDSA, signingKey: private, verifyKey: private.Public()}
+}
+
+// NewEdDSAPublicKey returns an Ed25519 key that can only verify
+func NewEdDSAPublicKey(id string, public ed25519.PublicKey) *Key {
+	return &Key{ID: id, Algorithm: AlgEdDSA, verifyKey: public}
+}
+
+// CanSign reports whether the key holds private material
+func (k *Key) CanSign() bool {
+	return k.signingKey != nil
+}
+
+// PublicKey returns the public half of an asymmetric key, or nil for HMAC keys
+func (k *Key) PublicKey() crypto.PublicKey {
+	if k.Algorithm == AlgHS256 {
+		return nil
+	}
+	return k.verifyKey
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// KeySet holds the key used to sign new tokens and every key that is still
+// accepted when verifying. Keeping retired keys in the set lets tokens signed
+// before a rotation stay valid until they expire.
+type KeySet struct {
+	active *Key
+	keys   map[string]*Key
+	order  []string
+}
+
+// NewKeySet builds a key set that signs with the key identified by activeID
+func NewKeySet(activeID string, keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*Key, len(keys))}
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, errors.New("key id must not be empty")
+		}
+		if k.method() == nil {
+			return nil, fmt.Errorf("key %q: unsupported algorithm %q", k.ID, k.Algorithm)
+		}
+		if _, ok := ks.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		ks.keys[k.ID] = k
+		ks.order = append(ks.order, k.ID)
+	}
+
+	active, ok := ks.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q is not in the key set", activeID)
+	}
+	if !active.CanSign() {
+		return nil, fmt.Errorf("active key %q has no private key", activeID)
+	}
+	ks.active = active
+
+	return ks, nil
+}
+
+// Active returns the key used to sign new tokens
+func (ks *KeySet) Active() *Key {
+	return ks.active
+}
+
+// Keys returns every key in the verification set, in configuration order
+func (ks *KeySet) Keys() []*Key {
+	keys := make([]*Key, 0, len(ks.order))
+	for _, id := range ks.order {
+		keys = append(keys, ks.keys[id])
+	}
+	return keys
+}
+
+// Sign signs the claims with the active key and sets the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method(), claims)
+	token.Header["kid"] = ks.active.ID
+	return token.SignedString(ks.active.signingKey)
+}
+
+// Parse verifies a token against the key named by its kid header and decodes
+// it into claims. The token's alg must match the algorithm of that key.
+func (ks *KeySet) Parse(tokenStr string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenStr, claims, ks.keyFunc)
+}
+
+// ParseSignature verifies the signature of a token like Parse does, but leaves
+// the time based claims to the caller, who can allow for clock skew
+func (ks *KeySet) ParseSignature(tokenStr string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.NewParser(jwt.WithoutClaimsValidation()).ParseWithClaims(tokenStr, claims, ks.keyFunc)
+}
+
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, ErrAlgorithmMismatch
+	}
+	return key.verifyKey, nil
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func testClaims() *Claims {
+	return &Claims{
+		Username: "testuser",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func TestKeySet_SignAndParse(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name string
+		key  *Key
+	}{
+		{name: "HS256", key: NewHMACKey("hs", []byte("secret_key"))},
+		{name: "RS256", key: NewRSAKey("rs", rsaKey)},
+		{name: "EdDSA", key: NewEdDSAKey("ed", edKey)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, err := NewKeySet(tt.key.ID, tt.key)
+			if err != nil {
+				t.Fatalf("NewKeySet() error = %v", err)
+			}
+
+			tokenStr, err := ks.Sign(testClaims())
+			if err != nil {
+				t.Fatalf("KeySet.Sign() error = %v", err)
+			}
+
+			claims := &Claims{}
+			token, err := ks.Parse(tokenStr, claims)
+			if err != nil || !token.Valid {
+				t.Fatalf("KeySet.Parse() error = %v", err)
+			}
+			if token.Header["kid"] != tt.key.ID || token.Header["alg"] != tt.name {
+				t.Errorf("KeySet.Parse() header = %v, want kid %s alg %s", token.Header, tt.key.ID, tt.name)
+			}
+			if claims.Username != "testuser" {
+				t.Errorf("KeySet.Parse() username = %s, want testuser", claims.Username)
+			}
+		})
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	before, _ := NewKeySet("old", NewRSAKey("old", rsaKey))
+	oldToken, _ := before.Sign(testClaims())
+
+	after, err := NewKeySet("new", NewEdDSAKey("new", edKey), NewRSAPublicKey("old", &rsaKey.PublicKey))
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+
+	if _, err := after.Parse(oldToken, &Claims{}); err != nil {
+		t.Errorf("token signed with retired key rejected: %v", err)
+	}
+
+	newToken, _ := after.Sign(testClaims())
+	if _, err := before.Parse(newToken, &Claims{}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("KeySet.Parse() error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestKeySet_ParseRejects(t *testing.T) {
+	secret := []byte("secret_key")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ks, _ := NewKeySet("hs", NewHMACKey("hs", secret), NewRSAPublicKey("rs", &rsaKey.PublicKey))
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, testClaims())
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		tokenStr, _ := token.SignedString(key)
+		return tokenStr
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "missing kid", token: sign(jwt.SigningMethodHS256, "", secret), wantErr: ErrUnknownKey},
+		{name: "unknown kid", token: sign(jwt.SigningMethodHS256, "other", secret), wantErr: ErrUnknownKey},
+		{name: "algorithm does not match key", token: sign(jwt.SigningMethodHS512, "hs", secret), wantErr: ErrAlgorithmMismatch},
+		{name: "HMAC token signed with a public key id", token: sign(jwt.SigningMethodHS256, "rs", secret), wantErr: ErrAlgorithmMismatch},
+		{name: "wrong secret", token: sign(jwt.SigningMethodHS256, "hs", []byte("wrong")), wantErr: jwt.ErrSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ks.Parse(tt.token, &Claims{}); !errors.Is(err, tt.wantErr) {
+				t.Errorf("KeySet.Parse() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewKeySet_Invalid(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name   string
+		active string
+		keys   []*Key
+	}{
+		{name: "active key missing", active: "missing", keys: []*Key{NewHMACKey("hs", []byte("secret"))}},
+		{name: "active key is verify only", active: "rs", keys: []*Key{NewRSAPublicKey("rs", &rsaKey.PublicKey)}},
+		{name: "duplicate kid", active: "hs", keys: []*Key{NewHMACKey("hs", []byte("a")), NewHMACKey("hs", []byte("b"))}},
+		{name: "unsupported algorithm", active: "x", keys: []*Key{{ID: "x", Algorithm: "none"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeySet(tt.active, tt.keys...); err == nil {
+				t.Errorf("NewKeySet() error = nil, want error")
+			}
+		})
+	}
+}
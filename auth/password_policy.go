@@ -0,0 +1,180 @@
+// auth/password_policy.go
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BcryptMaxBytes is the length after which bcrypt silently ignores the rest
+// of a password
+const BcryptMaxBytes = 72
+
+// Codes of the rules a password can violate
+const (
+	ViolationTooShort         = "too_short"
+	ViolationTooLong          = "too_long"
+	ViolationMissingUppercase = "missing_uppercase"
+	ViolationMissingLowercase = "missing_lowercase"
+	ViolationMissingDigit     = "missing_digit"
+	ViolationMissingSymbol    = "missing_symbol"
+	ViolationContainsUsername = "contains_username"
+	ViolationBreached         = "breached"
+)
+
+// PasswordPolicy describes which passwords are accepted
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters
+	MinLength int
+	// MaxBytes is the maximum length in bytes, or 0 for no limit. See
+	// ForBcrypt.
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// DisallowUsername rejects passwords that contain the username
+	DisallowUsername bool
+	// Breached rejects known breached passwords when set
+	Breached BreachedPasswords
+}
+
+// DefaultPasswordPolicy follows current guidance: a minimum length and a
+// breached password list rather than composition rules
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:        8,
+	DisallowUsername: true,
+}
+
+// ForBcrypt returns the policy with MaxBytes lowered to BcryptMaxBytes, so
+// that passwords bcrypt would cut short are rejected instead
+func (p PasswordPolicy) ForBcrypt() PasswordPolicy {
+	if p.MaxBytes <= 0 || p.MaxBytes > BcryptMaxBytes {
+		p.MaxBytes = BcryptMaxBytes
+	}
+	return p
+}
+
+// PasswordViolation is a single rule a password breaks
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a password breaks
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "password does not meet the policy: " + strings.Join(messages, "; ")
+}
+
+// Validate checks password for the given username. Rule violations are
+// returned as a *PasswordPolicyError; any other error means the breached
+// password list could not be read.
+func (p PasswordPolicy) Validate(password, username string) error {
+	var violations []PasswordViolation
+	add := func(code, format string, args ...interface{}) {
+		violations = append(violations, PasswordViolation{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		add(ViolationTooShort, "must be at least %d characters long", p.MinLength)
+	}
+
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		add(ViolationTooLong, "must be at most %d bytes long", p.MaxBytes)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		add(ViolationMissingUppercase, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		add(ViolationMissingLowercase, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		add(ViolationMissingDigit, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		add(ViolationMissingSymbol, "must contain a symbol")
+	}
+
+	// Very short usernames would rule out too many passwords
+	if p.DisallowUsername && utf8.RuneCountInString(username) >= 3 &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		add(ViolationContainsUsername, "must not contain the username")
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			add(ViolationBreached, "appears in a list of breached passwords")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// LoadPasswordPolicy builds the password policy from the environment,
+// starting from DefaultPasswordPolicy:
+//
+//	PASSWORD_MIN_LENGTH          minimum number of characters
+//	PASSWORD_REQUIRE_UPPER       "true" to require an uppercase letter
+//	PASSWORD_REQUIRE_LOWER       "true" to require a lowercase letter
+//	PASSWORD_REQUIRE_DIGIT       "true" to require a digit
+//	PASSWORD_REQUIRE_SYMBOL      "true" to require a symbol
+//	BREACHED_PASSWORDS_FILE      breached password file or range directory
+func LoadPasswordPolicy() (PasswordPolicy, error) {
+	policy := DefaultPasswordPolicy
+
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid PASSWORD_MIN_LENGTH: %w", err)
+		}
+		policy.MinLength = n
+	}
+
+	policy.RequireUpper = os.Getenv("PASSWORD_REQUIRE_UPPER") == "true"
+	policy.RequireLower = os.Getenv("PASSWORD_REQUIRE_LOWER") == "true"
+	policy.RequireDigit = os.Getenv("PASSWORD_REQUIRE_DIGIT") == "true"
+	policy.RequireSymbol = os.Getenv("PASSWORD_REQUIRE_SYMBOL") == "true"
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := LoadBreachedPasswords(path)
+		if err != nil {
+			return policy, err
+		}
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeBreached map[string]bool
+
+func (f fakeBreached) Contains(password string) (bool, error) {
+	return f[password], nil
+}
+
+func violationCodes(err error) []string {
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return nil
+	}
+	codes := make([]string, len(policyErr.Violations))
+	for i, v := range policyErr.Violations {
+		codes[i] = v.Code
+	}
+	return codes
+}
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	strict := PasswordPolicy{
+		MinLength:        10,
+		MaxBytes:         72,
+		RequireUpper:     true,
+		RequireLower:     true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		DisallowUsername: true,
+		Breached:         fakeBreached{"Password123!": true},
+	}
+
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		username string
+		want     []string
+	}{
+		{name: "valid", policy: strict, password: "Correct-Horse-9", username: "rrm"},
+		{name: "too short", policy: strict, password: "Ab1!", username: "rrm", want: []string{ViolationTooShort}},
+		{name: "too long", policy: strict, password: "Aa1!" + strings.Repeat("x", 69), username: "rrm", want: []string{ViolationTooLong}},
+		{
+			name:     "missing classes",
+			policy:   strict,
+			password: "alllowercaseletters",
+			username: "rrm",
+			want:     []string{ViolationMissingUppercase, ViolationMissingDigit, ViolationMissingSymbol},
+		},
+		{name: "contains username", policy: strict, password: "Hello-RRM-2024", username: "rrm", want: []string{ViolationContainsUsername}},
+		{name: "short username ignored", policy: strict, password: "Hello-ab-20245", username: "ab"},
+		{name: "breached", policy: strict, password: "Password123!", username: "rrm", want: []string{ViolationBreached}},
+		{name: "multibyte length counts characters", policy: PasswordPolicy{MinLength: 4}, password: "日本語", want: []string{ViolationTooShort}},
+		{name: "no limit without MaxBytes", policy: PasswordPolicy{}, password: strings.Repeat("x", 200)},
+		{name: "bcrypt limit", policy: PasswordPolicy{}.ForBcrypt(), password: strings.Repeat("x", 73), want: []string{ViolationTooLong}},
+		{name: "bcrypt keeps a lower limit", policy: PasswordPolicy{MaxBytes: 16}.ForBcrypt(), password: strings.Repeat("x", 17), want: []string{ViolationTooLong}},
+		{name: "default policy", policy: DefaultPasswordPolicy, password: "longenough", username: "rrm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate(tt.password, tt.username)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+
+			if got := violationCodes(err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() violations = %v, want %v (error %v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadPasswordPolicy(t *testing.T) {
+	t.Setenv("PASSWORD_MIN_LENGTH", "12")
+	t.Setenv("PASSWORD_REQUIRE_DIGIT", "true")
+
+	policy, err := LoadPasswordPolicy()
+	if err != nil {
+		t.Fatalf("LoadPasswordPolicy() error = %v", err)
+	}
+	if policy.MinLength != 12 || !policy.RequireDigit || policy.RequireUpper || !policy.DisallowUsername {
+		t.Errorf("LoadPasswordPolicy() = %+v", policy)
+	}
+
+	t.Setenv("PASSWORD_MIN_LENGTH", "twelve")
+	if _, err := LoadPasswordPolicy(); err == nil {
+		t.Errorf("LoadPasswordPolicy() with invalid length error = nil")
+	}
+}
@@ -0,0 +1,56 @@
+// auth/principal.go
+package auth
+
+import "context"
+
+// Principal identifies the authenticated caller of a request
+type Principal struct {
+	Username string
+	Role     string
+	// APIKeyID is set when the caller authenticated with an API key. Such
+	// callers only get the permissions listed in Scopes.
+	APIKeyID uint
+	Scopes   []string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the caller
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the caller stored by WithPrincipal
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Can reports whether the principal's role grants permission and, for API
+// keys, whether the key was scoped to it
+func (p Principal) Can(permission string) bool {
+	if !HasPermission(p.Role, permission) {
+		return false
+	}
+	if p.APIKeyID == 0 {
+		return true
+	}
+
+	for _, scope := range p.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns every permission the principal can use
+func (p Principal) Permissions() []string {
+	var permissions []string
+	for _, permission := range Permissions(p.Role) {
+		if p.Can(permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
@@ -0,0 +1,62 @@
+// auth/rbac.go
+package auth
+
+// Roles a user can hold
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Permissions checked by RequirePermission
+const (
+	PermListUsers      = "users:list"
+	PermManageUsers    = "users:manage"
+	PermDeleteUsers    = "users:delete"
+	PermRevokeSessions = "sessions:revoke"
+	PermUnlockUsers    = "users:unlock"
+
+	// Every role may read and change its own profile. These permissions only
+	// limit API keys, which need them in their scopes to do so.
+	PermReadProfile  = "profile:read"
+	PermWriteProfile = "profile:write"
+)
+
+// rolePermissions maps every role to the permissions it grants
+var rolePermissions = map[string][]string{
+	RoleAdmin: {PermReadProfile, PermWriteProfile, PermListUsers, PermManageUsers, PermDeleteUsers, PermRevokeSessions, PermUnlockUsers},
+	RoleUser:  {PermReadProfile, PermWriteProfile},
+}
+
+// IsValidRole reports whether role is a known role
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// IsPermission reports whether permission is granted by any role. API key
+// scopes must be permissions.
+func IsPermission(permission string) bool {
+	for _, permissions := range rolePermissions {
+		for _, p := range permissions {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether role grants permission
+func HasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns the permissions role grants
+func Permissions(role string) []string {
+	return append([]string(nil), rolePermissions[role]...)
+}
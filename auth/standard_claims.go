@@ -0,0 +1,112 @@
+// auth/standard_claims.go
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrTokenExpired     = errors.New("token is expired")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrInvalidIssuer    = errors.New("token has an invalid issuer")
+	ErrInvalidAudience  = errors.New("token has an invalid audience")
+	ErrMissingSubject   = errors.New("token has no subject")
+)
+
+// ClaimsConfig describes the registered claims put into access tokens and
+// checked when they are validated
+type ClaimsConfig struct {
+	// Issuer is set as "iss" and, when not empty, required on every token
+	Issuer string
+	// Audience is set as "aud". When not empty, a token must be meant for at
+	// least one of these audiences.
+	Audience []string
+	// AdminAudience is added to the audiences of tokens issued to admins, so
+	// admin routes can require it
+	AdminAudience string
+	// Leeway is the clock skew tolerated when checking "exp", "nbf" and
+	// "iat", for tokens minted by other services sharing the keys
+	Leeway time.Duration
+}
+
+// LoadClaimsConfig reads JWT_ISSUER, JWT_AUDIENCE (comma separated),
+// JWT_ADMIN_AUDIENCE and JWT_LEEWAY (a duration such as "30s")
+func LoadClaimsConfig() (ClaimsConfig, error) {
+	cfg := ClaimsConfig{
+		Issuer:        strings.TrimSuffix(os.Getenv("JWT_ISSUER"), "/"),
+		AdminAudience: strings.TrimSpace(os.Getenv("JWT_ADMIN_AUDIENCE")),
+	}
+
+	for _, audience := range strings.Split(os.Getenv("JWT_AUDIENCE"), ",") {
+		if audience = strings.TrimSpace(audience); audience != "" {
+			cfg.Audience = append(cfg.Audience, audience)
+		}
+	}
+
+	if v := os.Getenv("JWT_LEEWAY"); v != "" {
+		leeway, err := time.ParseDuration(v)
+		if err != nil || leeway < 0 {
+			return cfg, fmt.Errorf("invalid JWT_LEEWAY %q", v)
+		}
+		cfg.Leeway = leeway
+	}
+
+	return cfg, nil
+}
+
+// AudienceFor returns the audiences of a token issued to a user with role
+func (c ClaimsConfig) AudienceFor(role string) []string {
+	if role != RoleAdmin || c.AdminAudience == "" {
+		return c.Audience
+	}
+
+	audience := make([]string, 0, len(c.Audience)+1)
+	audience = append(audience, c.Audience...)
+	return append(audience, c.AdminAudience)
+}
+
+// Validate checks the registered claims of a token whose signature was
+// verified. "exp" and "sub" are required. Tokens issued for one of the
+// internal purposes, such as an mfa pending token, are never valid here.
+func (c ClaimsConfig) Validate(claims *jwt.RegisteredClaims, now time.Time) error {
+	if claims.ExpiresAt == nil || !now.Add(-c.Leeway).Before(claims.ExpiresAt.Time) {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(c.Leeway).Before(claims.NotBefore.Time) {
+		return ErrTokenNotYetValid
+	}
+	if claims.IssuedAt != nil && now.Add(c.Leeway).Before(claims.IssuedAt.Time) {
+		return ErrTokenNotYetValid
+	}
+	if c.Issuer != "" && claims.Issuer != c.Issuer {
+		return ErrInvalidIssuer
+	}
+	if HasAudience(claims, internalAudiences...) {
+		return ErrInvalidAudience
+	}
+	if len(c.Audience) > 0 && !HasAudience(claims, c.Audience...) {
+		return ErrInvalidAudience
+	}
+	if claims.Subject == "" {
+		return ErrMissingSubject
+	}
+	return nil
+}
+
+// HasAudience reports whether the token is meant for any of the audiences
+func HasAudience(claims *jwt.RegisteredClaims, audiences ...string) bool {
+	for _, want := range audiences {
+		for _, got := range claims.Audience {
+			if got == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestClaimsConfig_Validate(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *jwt.NumericDate { return jwt.NewNumericDate(now.Add(d)) }
+	cfg := ClaimsConfig{Issuer: "https://api.example.com", Audience: []string{"api", "admin"}, Leeway: 30 * time.Second}
+
+	valid := func() jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Issuer:    "https://api.example.com",
+			Subject:   "1",
+			Audience:  jwt.ClaimStrings{"api"},
+			ExpiresAt: at(time.Minute),
+			NotBefore: at(0),
+			IssuedAt:  at(0),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*jwt.RegisteredClaims)
+		wantErr error
+	}{
+		{name: "valid", modify: func(*jwt.RegisteredClaims) {}},
+		{name: "second audience", modify: func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other", "admin"} }},
+		{name: "expired within leeway", modify: func(c *jwt.RegisteredClaims) { c.ExpiresAt = at(-10 * time.Second) }},
+		{name: "expired", modify: func(c *jwt.RegisteredClaims) { c.ExpiresAt = at(-time.Minute) }, wantErr: ErrTokenExpired},
+		{name: "no expiry", modify: func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }, wantErr: ErrTokenExpired},
+		{name: "not before within leeway", modify: func(c *jwt.RegisteredClaims) { c.NotBefore = at(10 * time.Second) }},
+		{name: "not before", modify: func(c *jwt.RegisteredClaims) { c.NotBefore = at(time.Minute) }, wantErr: ErrTokenNotYetValid},
+		{name: "issued in the future", modify: func(c *jwt.RegisteredClaims) { c.IssuedAt = at(time.Minute) }, wantErr: ErrTokenNotYetValid},
+		{name: "wrong issuer", modify: func(c *jwt.RegisteredClaims) { c.Issuer = "https://evil.example.com" }, wantErr: ErrInvalidIssuer},
+		{name: "wrong audience", modify: func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other"} }, wantErr: ErrInvalidAudience},
+		{name: "mfa pending token", modify: func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"api", PurposeMFA} }, wantErr: ErrInvalidAudience},
+		{name: "no audience", modify: func(c *jwt.RegisteredClaims) { c.Audience = nil }, wantErr: ErrInvalidAudience},
+		{name: "no subject", modify: func(c *jwt.RegisteredClaims) { c.Subject = "" }, wantErr: ErrMissingSubject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(&claims)
+
+			if err := cfg.Validate(&claims, now); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ClaimsConfig.Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClaimsConfig_Validate_Unconfigured(t *testing.T) {
+	claims := jwt.RegisteredClaims{Subject: "1", Issuer: "anyone", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+
+	if err := (ClaimsConfig{}).Validate(&claims, time.Now()); err != nil {
+		t.Errorf("ClaimsConfig.Validate() without issuer and audience error = %v", err)
+	}
+
+	claims.Audience = jwt.ClaimStrings{PurposeVerifyEmail}
+	if err := (ClaimsConfig{}).Validate(&claims, time.Now()); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("ClaimsConfig.Validate() of a verification token error = %v, want %v", err, ErrInvalidAudience)
+	}
+}
+
+func TestLoadClaimsConfig(t *testing.T) {
+	t.Setenv("JWT_ISSUER", "https://api.example.com/")
+	t.Setenv("JWT_AUDIENCE", "api, admin")
+	t.Setenv("JWT_ADMIN_AUDIENCE", "admin-api")
+	t.Setenv("JWT_LEEWAY", "45s")
+
+	cfg, err := LoadClaimsConfig()
+	if err != nil {
+		t.Fatalf("LoadClaimsConfig() error = %v", err)
+	}
+	want := ClaimsConfig{Issuer: "https://api.example.com", Audience: []string{"api", "admin"}, AdminAudience: "admin-api", Leeway: 45 * time.Second}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("LoadClaimsConfig() = %+v, want %+v", cfg, want)
+	}
+
+	t.Setenv("JWT_LEEWAY", "soon")
+	if _, err := LoadClaimsConfig(); err == nil {
+		t.Errorf("LoadClaimsConfig() with an invalid leeway error = nil")
+	}
+}
+
+func TestClaimsConfig_AudienceFor(t *testing.T) {
+	cfg := ClaimsConfig{Audience: []string{"api"}, AdminAudience: "admin-api"}
+
+	if got := cfg.AudienceFor(RoleAdmin); !reflect.DeepEqual(got, []string{"api", "admin-api"}) {
+		t.Errorf("ClaimsConfig.AudienceFor(admin) = %v", got)
+	}
+	if got := cfg.AudienceFor(RoleUser); !reflect.DeepEqual(got, []string{"api"}) {
+		t.Errorf("ClaimsConfig.AudienceFor(user) = %v", got)
+	}
+	if got := (ClaimsConfig{Audience: []string{"api"}}).AudienceFor(RoleAdmin); !reflect.DeepEqual(got, []string{"api"}) {
+		t.Errorf("ClaimsConfig.AudienceFor(admin) without an admin audience = %v", got)
+	}
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed from the RFC 6238 test vectors
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes; the last 6 digits are the 6 digit code
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, _ := TOTPCode(secret, now)
+
+	step, ok := VerifyTOTP(secret, code, now)
+	if !ok || step != totpStep(now) {
+		t.Errorf("VerifyTOTP() = %d, %v, want %d, true", step, ok, totpStep(now))
+	}
+
+	if _, ok := VerifyTOTP(secret, code, now.Add(totpPeriod*time.Second)); !ok {
+		t.Errorf("VerifyTOTP() rejected a code from the previous period")
+	}
+	if _, ok := VerifyTOTP(secret, code, now.Add(3*totpPeriod*time.Second)); ok {
+		t.Errorf("VerifyTOTP() accepted a code from three periods ago")
+	}
+	if _, ok := VerifyTOTP(secret, "12345", now); ok {
+		t.Errorf("VerifyTOTP() accepted a short code")
+	}
+	if _, ok := VerifyTOTP("not base32!", code, now); ok {
+		t.Errorf("VerifyTOTP() accepted an invalid secret")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("go-rest-api", "rrm", "ABC")
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || !strings.HasSuffix(u.Path, "go-rest-api:rrm") {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	if q := u.Query(); q.Get("secret") != "ABC" || q.Get("issuer") != "go-rest-api" {
+		t.Errorf("unexpected query in %s", uri)
+	}
+}
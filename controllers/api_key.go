@@ -0,0 +1,119 @@
+// controllers/api_key.go
+package controllers
+
+import (
+	"errors"
+	"go-rest-api/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name" binding:"required"`
+	// Scopes are permissions such as "profile:read" or "users:list"; only
+	// those the caller's role grants are allowed
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// writeAPIKeyError writes the response for errors shared by the API key
+// endpoints and reports whether err was one of those
+func writeAPIKeyError(c *gin.Context, err error) bool {
+	var authErr *services.AuthorizationError
+	switch {
+	case errors.As(err, &authErr):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+	case errors.Is(err, services.ErrInvalidScope), errors.Is(err, services.ErrInvalidExpiry):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+
+	return true
+}
+
+// CreateAPIKey creates an API key for the current user
+// @Summary Create an API key
+// @Description Create a named API key with optional scopes and expiry. Send it as "Authorization: ApiKey <key>" or in the X-API-Key header. The key is shown only in this response.
+// @Tags me
+// @Accept json
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param request body CreateAPIKeyRequest true "Name, scopes and expiry"
+// @Success 201 {object} models.NewAPIKey
+// @Failure 400 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /me/api-keys [post]
+func (ctrl *UserController) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "required fields [name]"})
+		return
+	}
+
+	key, err := ctrl.service.CreateAPIKey(c.Request.Context(), req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		if writeAPIKeyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": key})
+}
+
+// ListAPIKeys lists the current user's API keys
+// @Summary List API keys
+// @Description List the current user's API keys that have not been revoked. Only the prefix of each key is shown.
+// @Tags me
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Success 200 {array} models.APIKey
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /me/api-keys [get]
+func (ctrl *UserController) ListAPIKeys(c *gin.Context) {
+	keys, err := ctrl.service.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		if writeAPIKeyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+// RevokeAPIKey revokes one of the current user's API keys
+// @Summary Revoke an API key
+// @Description Revoke an API key of the current user. It stops working immediately.
+// @Tags me
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path int true "API key ID"
+// @Success 200 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /me/api-keys/{id} [delete]
+func (ctrl *UserController) RevokeAPIKey(c *gin.Context) {
+	if err := ctrl.service.RevokeAPIKey(c.Request.Context(), c.Param("id")); err != nil {
+		if writeAPIKeyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "API key revoked"})
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"go-rest-api/auth"
+	"go-rest-api/models"
+	"go-rest-api/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	created := models.NewAPIKey{APIKey: models.APIKey{ID: 3, Name: "batch", Prefix: "gra_abcdefgh", Scopes: models.Scopes{auth.PermListUsers}}, Key: "gra_abcdefgh.secret"}
+	mockUserService.EXPECT().CreateAPIKey(gomock.Any(), "batch", []string{auth.PermListUsers}, gomock.Not(gomock.Nil())).Return(created, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/me/api-keys", strings.NewReader(`{"name":"batch","scopes":["users:list"],"expires_at":"2030-01-01T00:00:00Z"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"key":"gra_abcdefgh.secret"`)
+	assert.Contains(t, w.Body.String(), `"scopes":["users:list"]`)
+	assert.NotContains(t, w.Body.String(), "key_hash")
+}
+
+func TestCreateAPIKey_MissingName(t *testing.T) {
+	router, _, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/me/api-keys", strings.NewReader(`{"scopes":["users:list"]}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCreateAPIKey_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+	}{
+		{name: "Unknown Scope", err: fmt.Errorf("%w: users:everything", services.ErrInvalidScope), wantCode: http.StatusBadRequest},
+		{name: "Expiry In The Past", err: services.ErrInvalidExpiry, wantCode: http.StatusBadRequest},
+		{name: "Scope Not Granted", err: &services.AuthorizationError{Action: "create API key", Reason: "role does not grant users:list"}, wantCode: http.StatusForbidden},
+		{name: "Database Error", err: errors.New("database unavailable"), wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, mockUserService, ctrl := setupTest()
+			defer ctrl.Finish()
+
+			mockUserService.EXPECT().CreateAPIKey(gomock.Any(), "batch", nil, nil).Return(models.NewAPIKey{}, tt.err)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/me/api-keys", strings.NewReader(`{"name":"batch"}`))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestListAPIKeys(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().ListAPIKeys(gomock.Any()).Return([]models.APIKey{{ID: 3, Name: "batch", Prefix: "gra_abcdefgh", KeyHash: "hash"}}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/me/api-keys", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "gra_abcdefgh")
+	assert.NotContains(t, w.Body.String(), "hash")
+}
+
+func TestListAPIKeys_APIKeyNotScoped(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().ListAPIKeys(gomock.Any()).Return(nil, &services.AuthorizationError{Action: "read current user", Reason: "API key is not scoped to profile:read"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/me/api-keys", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().RevokeAPIKey(gomock.Any(), "3").Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/me/api-keys/3", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRevokeAPIKey_NotFound(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().RevokeAPIKey(gomock.Any(), "4").Return(gorm.ErrRecordNotFound)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/me/api-keys/4", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
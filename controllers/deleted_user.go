@@ -0,0 +1,115 @@
+// controllers/deleted_user.go
+package controllers
+
+import (
+	"errors"
+	"go-rest-api/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetDeletedUsers returns a page of deleted users
+// @Summary List deleted users
+// @Description List the users that have been deleted but not purged yet, page by page, with the time they were deleted. Takes the same filters and paging parameters as GET /users.
+// @Tags user
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param country query string false "Exact country"
+// @Param username_prefix query string false "Start of the username"
+// @Param created_after query string false "Only users created after this RFC 3339 time"
+// @Param created_before query string false "Only users created before this RFC 3339 time"
+// @Param sort query string false "id, username, country or created_at; prefix with - for descending order" default(id)
+// @Param limit query int false "Users per page" default(20)
+// @Param offset query int false "Users to skip; cannot be combined with cursor"
+// @Param cursor query string false "next_cursor of the previous page, with the same sort"
+// @Success 200 {object} UserListResponse
+// @Failure 400 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /deleted-users [get]
+func (ctrl *UserController) GetDeletedUsers(c *gin.Context) {
+	var req ListUsersRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := req.toQuery()
+	query.Deleted = true
+	page, err := ctrl.service.GetUsers(query)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidUserQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, newUserListResponse(page))
+}
+
+// RestoreUser undoes the deletion of a user
+// @Summary Restore a deleted user
+// @Description Bring back a user that has been deleted but not purged yet. Fails with 409 when their username was freed and someone else has taken it since.
+// @Tags user
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path int true "User ID"
+// @Success 200 {object} UserResponse
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /deleted-users/{id}/restore [post]
+func (ctrl *UserController) RestoreUser(c *gin.Context) {
+	id := c.Param("id")
+
+	user, err := ctrl.service.RestoreUser(id)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted user not found"})
+			return
+		}
+		if errors.Is(err, services.ErrUsernameTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("ETag", userETag(user))
+	c.JSON(http.StatusOK, gin.H{"data": newUserResponse(user)})
+}
+
+// PurgeUser removes a deleted user for good
+// @Summary Purge a deleted user
+// @Description Remove a deleted user for good, together with their sessions, API keys and linked identities. Only deleted users can be purged; delete the user first.
+// @Tags user
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path int true "User ID"
+// @Success 200 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /deleted-users/{id} [delete]
+func (ctrl *UserController) PurgeUser(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := ctrl.service.PurgeUser(id); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deleted user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "User purged"})
+}
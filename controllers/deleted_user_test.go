@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"encoding/json"
+	"go-rest-api/models"
+	"go-rest-api/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestGetDeletedUsers(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	deletedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	deleted := models.User{Model: gorm.Model{ID: 1, DeletedAt: gorm.DeletedAt{Time: deletedAt, Valid: true}}, Username: "testuser"}
+	mockUserService.EXPECT().GetUsers(models.UserQuery{Country: "usa", Limit: 10, Deleted: true}).
+		Return(models.UserPage{Users: []models.User{deleted}, Total: 1}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/deleted-users?country=usa&limit=10", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body UserListResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Len(t, body.Data, 1)
+	assert.Equal(t, &deletedAt, body.Data[0].DeletedAt)
+}
+
+func TestRestoreUser(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	restored := models.User{Model: gorm.Model{ID: 1}, Username: "testuser", Version: 4}
+	mockUserService.EXPECT().RestoreUser("1").Return(restored, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/deleted-users/1/restore", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1-4"`, w.Header().Get("ETag"))
+	assert.NotContains(t, w.Body.String(), "deleted_at")
+}
+
+func TestRestoreUser_Errors(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().RestoreUser("1").Return(models.User{}, gorm.ErrRecordNotFound)
+	mockUserService.EXPECT().RestoreUser("2").Return(models.User{}, services.ErrUsernameTaken)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/deleted-users/1/restore", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/deleted-users/2/restore", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestPurgeUser(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().PurgeUser("1").Return(nil)
+	mockUserService.EXPECT().PurgeUser("2").Return(gorm.ErrRecordNotFound)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/deleted-users/1", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/deleted-users/2", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,89 @@
+// controllers/email.go
+package controllers
+
+import (
+	"errors"
+	"go-rest-api/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// writeEmailError writes the response for an invalid or taken email address
+// and reports whether err was one of those
+func writeEmailError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrInvalidEmail):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+
+	return true
+}
+
+// VerifyEmail confirms a user's email address
+// @Summary Verify an email address
+// @Description Mark the email address a verification token was mailed to as verified
+// @Tags email
+// @Accept json
+// @Produce json
+// @Param request body VerifyEmailRequest true "Verification token"
+// @Success 200 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /verify-email [post]
+func (ctrl *UserController) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "required fields [token]"})
+		return
+	}
+
+	if err := ctrl.service.VerifyEmail(req.Token); err != nil {
+		if errors.Is(err, services.ErrInvalidVerificationToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "Email address verified"})
+}
+
+// ResendVerification mails a new verification token
+// @Summary Resend the email verification
+// @Description Mail a new verification token to an unverified address. The response is the same whether or not the address is known.
+// @Tags email
+// @Accept json
+// @Produce json
+// @Param request body ResendVerificationRequest true "Email address"
+// @Success 202 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /verify-email/resend [post]
+func (ctrl *UserController) ResendVerification(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "required fields [email]"})
+		return
+	}
+
+	if err := ctrl.service.ResendVerification(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"data": "If the address needs verification, a new link has been sent"})
+}
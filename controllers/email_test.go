@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"go-rest-api/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().VerifyEmail("verify-token").Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/verify-email", strings.NewReader(`{"token":"verify-token"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Email address verified")
+}
+
+func TestVerifyEmail_InvalidToken(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().VerifyEmail("verify-token").Return(services.ErrInvalidVerificationToken)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/verify-email", strings.NewReader(`{"token":"verify-token"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestVerifyEmail_MissingToken(t *testing.T) {
+	router, _, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/verify-email", strings.NewReader(`{}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "required fields [token]")
+}
+
+func TestResendVerification(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().ResendVerification("test@example.com").Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/verify-email/resend", strings.NewReader(`{"email":"test@example.com"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
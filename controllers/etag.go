@@ -0,0 +1,72 @@
+// controllers/etag.go
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"go-rest-api/models"
+	"go-rest-api/services"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userETag is the strong entity tag of a user's representation. It changes
+// with every saved change of the user.
+func userETag(user models.User) string {
+	return fmt.Sprintf(`"%d-%d"`, user.ID, user.Version)
+}
+
+// matchETag reports whether an If-Match or If-None-Match header lists etag or
+// is "*" (RFC 9110, section 13.1). If-Match compares strongly, so that weak
+// tags never match; If-None-Match compares weakly.
+func matchETag(header, etag string, strong bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if strong {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkIfMatch evaluates the If-Match header of a request that changes user.
+// It answers 412 Precondition Failed and returns false when the user has
+// changed since the client read it.
+func checkIfMatch(c *gin.Context, user models.User) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" || matchETag(header, userETag(user), true) {
+		return true
+	}
+
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": "user has been modified, fetch it again"})
+	return false
+}
+
+// writeConflictError answers a ConflictError with 412 Precondition Failed
+// when the client made the request conditional with If-Match, and with 409
+// Conflict when it did not. It reports whether err was one.
+func writeConflictError(c *gin.Context, err error) bool {
+	var conflictErr *services.ConflictError
+	if !errors.As(err, &conflictErr) {
+		return false
+	}
+
+	status := http.StatusConflict
+	if c.GetHeader("If-Match") != "" {
+		status = http.StatusPreconditionFailed
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+	return true
+}
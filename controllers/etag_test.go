@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"go-rest-api/models"
+	"go-rest-api/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		header string
+		strong bool
+		want   bool
+	}{
+		{header: `"1-3"`, strong: true, want: true},
+		{header: `"1-2", "1-3"`, strong: true, want: true},
+		{header: `*`, strong: true, want: true},
+		{header: `"1-2"`, strong: true, want: false},
+		{header: `W/"1-3"`, strong: true, want: false},
+		{header: `W/"1-3"`, strong: false, want: true},
+		{header: ``, strong: false, want: false},
+	}
+	for _, tt := range tests {
+		if got := matchETag(tt.header, `"1-3"`, tt.strong); got != tt.want {
+			t.Errorf("matchETag(%q, strong %v) = %v, want %v", tt.header, tt.strong, got, tt.want)
+		}
+	}
+}
+
+func TestGetUser_ETag(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	user := models.User{Model: gorm.Model{ID: 1}, Username: "testuser", Version: 3}
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(user, nil).Times(2)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/1", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1-3"`, w.Header().Get("ETag"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("If-None-Match", `"1-3"`)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, `"1-3"`, w.Header().Get("ETag"))
+	assert.Empty(t, w.Body.String())
+}
+
+func TestUpdateUser_IfMatch(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	current := models.User{Model: gorm.Model{ID: 1}, Username: "testuser", Country: "india", Version: 3}
+	stored := current
+	stored.Country, stored.Version = "usa", 4
+
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(current, nil)
+	mockUserService.EXPECT().UpdateUser(gomock.Any(), "1", models.User{Country: "usa", Version: 3}).Return(stored, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(`{"country":"usa"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", `"1-3"`)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1-4"`, w.Header().Get("ETag"))
+}
+
+func TestUpdateUser_IfMatchStale(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	current := models.User{Model: gorm.Model{ID: 1}, Username: "testuser", Country: "india", Version: 4}
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(current, nil).Times(2)
+
+	for _, method := range []string{"PUT", "PATCH"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/users/1", strings.NewReader(`{"country":"usa"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", `"1-3"`)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, method)
+	}
+}
+
+func TestUpdateUser_Conflict(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	current := models.User{Model: gorm.Model{ID: 1}, Username: "testuser", Country: "india", Version: 3}
+	conflict := &services.ConflictError{Version: 4}
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(current, nil).Times(2)
+	mockUserService.EXPECT().UpdateUser(gomock.Any(), "1", gomock.Any()).Return(models.User{}, conflict).Times(2)
+
+	// Without If-Match the client did not ask for a precondition
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PATCH", "/users/1", strings.NewReader(`{"country":"usa"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "it is at version 4 now")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/users/1", strings.NewReader(`{"country":"usa"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"1-3"`)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+func TestDeleteUser_IfMatch(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	current := models.User{Model: gorm.Model{ID: 1}, Username: "testuser", Version: 3}
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(current, nil).Times(2)
+	mockUserService.EXPECT().DeleteUser(gomock.Any(), "1", uint(3)).Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/users/1", nil)
+	req.Header.Set("If-Match", `"1-2"`)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/users/1", nil)
+	req.Header.Set("If-Match", `"1-3"`)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,60 @@
+// controllers/introspection.go
+package controllers
+
+import (
+	"go-rest-api/auth"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireClient only lets trusted services through, authenticated with their
+// client credentials in HTTP Basic auth or as client_id and client_secret form
+// fields (RFC 6749, section 2.3.1)
+func RequireClient(clients auth.Clients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, secret, ok := c.Request.BasicAuth()
+		if !ok {
+			id, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+		}
+
+		if id == "" || !clients.Authenticate(id, secret) {
+			c.Header("WWW-Authenticate", `Basic realm="introspection"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// IntrospectToken describes a token for resource servers
+// @Summary Introspect a token
+// @Description Report whether an access token, refresh token or API key is active, with its subject, scopes and expiry (RFC 7662). The token is checked exactly as for authenticating a request. token_type_hint is accepted but not needed. Requires client credentials from INTROSPECTION_CLIENTS in HTTP Basic auth.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Token to introspect"
+// @Param token_type_hint formData string false "access_token, refresh_token or api_key"
+// @Success 200 {object} models.TokenIntrospection
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /oauth/introspect [post]
+func (ctrl *UserController) IntrospectToken(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	result, err := ctrl.service.IntrospectToken(token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, result)
+}
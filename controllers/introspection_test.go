@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"errors"
+	"go-rest-api/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func introspectionRequest(form url.Values) *http.Request {
+	req, _ := http.NewRequest("POST", "/oauth/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestIntrospectToken(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	result := models.TokenIntrospection{Active: true, TokenType: models.TokenTypeAccess, Username: "testuser", Subject: "1", ExpiresAt: 1700000000}
+	mockUserService.EXPECT().IntrospectToken("access-token").Return(result, nil)
+
+	w := httptest.NewRecorder()
+	req := introspectionRequest(url.Values{"token": {"access-token"}})
+	req.SetBasicAuth("gateway", "s3cret")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Contains(t, w.Body.String(), `"active":true`)
+	assert.Contains(t, w.Body.String(), `"sub":"1"`)
+	assert.Contains(t, w.Body.String(), `"exp":1700000000`)
+}
+
+func TestIntrospectToken_FormCredentials(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().IntrospectToken("expired-token").Return(models.TokenIntrospection{}, nil)
+
+	w := httptest.NewRecorder()
+	req := introspectionRequest(url.Values{"token": {"expired-token"}, "client_id": {"gateway"}, "client_secret": {"s3cret"}})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"active":false}`, w.Body.String())
+}
+
+func TestIntrospectToken_InvalidClient(t *testing.T) {
+	router, _, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	tests := []struct {
+		name   string
+		id     string
+		secret string
+	}{
+		{name: "no credentials"},
+		{name: "wrong secret", id: "gateway", secret: "guess"},
+		{name: "unknown client", id: "stranger", secret: "s3cret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := introspectionRequest(url.Values{"token": {"access-token"}})
+			if tt.id != "" {
+				req.SetBasicAuth(tt.id, tt.secret)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Equal(t, `Basic realm="introspection"`, w.Header().Get("WWW-Authenticate"))
+			assert.Contains(t, w.Body.String(), "invalid_client")
+		})
+	}
+}
+
+func TestIntrospectToken_MissingToken(t *testing.T) {
+	router, _, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+	req := introspectionRequest(url.Values{})
+	req.SetBasicAuth("gateway", "s3cret")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_request")
+}
+
+func TestIntrospectToken_ServiceError(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().IntrospectToken("access-token").Return(models.TokenIntrospection{}, errors.New("database unavailable"))
+
+	w := httptest.NewRecorder()
+	req := introspectionRequest(url.Values{"token": {"access-token"}})
+	req.SetBasicAuth("gateway", "s3cret")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
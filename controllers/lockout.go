@@ -0,0 +1,55 @@
+// controllers/lockout.go
+package controllers
+
+import (
+	"errors"
+	"go-rest-api/services"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// writeLockedOut answers a locked out login with 429 and a Retry-After header
+// and reports whether err was a lockout
+func writeLockedOut(c *gin.Context, err error) bool {
+	var lockedErr *services.LockedOutError
+	if !errors.As(err, &lockedErr) {
+		return false
+	}
+
+	seconds := int(math.Ceil(lockedErr.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+	return true
+}
+
+// UnlockUser lifts a login lockout
+// @Summary Unlock a user
+// @Description Clear the failed login counters of a user so they can log in again right away
+// @Tags user
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path int true "User ID"
+// @Success 200 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /users/{id}/lockout [delete]
+func (ctrl *UserController) UnlockUser(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := ctrl.service.UnlockUser(id); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "User unlocked"})
+}
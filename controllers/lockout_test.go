@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"go-rest-api/models"
+	"go-rest-api/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestLogin_LockedOut(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().Login("testuser", "password", "192.0.2.1").
+		Return(models.TokenPair{}, &services.LockedOutError{RetryAfter: 90*time.Second + time.Millisecond})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"username":"testuser","password":"password"}`))
+	req.RemoteAddr = "192.0.2.1:4321"
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "91", w.Header().Get("Retry-After"))
+	assert.NotContains(t, w.Body.String(), "username")
+}
+
+func TestLoginMFA_LockedOut(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().LoginMFA("mfa-token", "123456", gomock.Any()).
+		Return(models.TokenPair{}, &services.LockedOutError{RetryAfter: time.Minute})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login/mfa", strings.NewReader(`{"mfa_token":"mfa-token","code":"123456"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
+
+func TestDisableTOTP_LockedOut(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().DisableTOTP(gomock.Any(), "123456", "192.0.2.1").
+		Return(&services.LockedOutError{RetryAfter: time.Minute})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/me/mfa/totp", strings.NewReader(`{"code":"123456"}`))
+	req.RemoteAddr = "192.0.2.1:4321"
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
+
+func TestUnlockUser(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().UnlockUser("1").Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/users/1/lockout", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "User unlocked")
+}
+
+func TestUnlockUserNotFound(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().UnlockUser("1").Return(gorm.ErrRecordNotFound)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/users/1/lockout", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
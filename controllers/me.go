@@ -0,0 +1,181 @@
+// controllers/me.go
+package controllers
+
+import (
+	"errors"
+	"go-rest-api/models"
+	"go-rest-api/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UpdateProfileRequest struct {
+	Country string `json:"country"`
+	Email   string `json:"email"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// currentUser loads the caller's record and writes the error response when it cannot
+func (ctrl *UserController) currentUser(c *gin.Context) (models.User, bool) {
+	user, err := ctrl.service.GetCurrentUser(c.Request.Context())
+	if err != nil {
+		if writeForbidden(c, err) {
+			return user, false
+		}
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return user, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return user, false
+	}
+
+	return user, true
+}
+
+// GetMe returns the authenticated user
+// @Summary Get the current user
+// @Description Get the profile of the user the access token belongs to
+// @Tags me
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Success 200 {object} UserResponse
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /me [get]
+func (ctrl *UserController) GetMe(c *gin.Context) {
+	user, ok := ctrl.currentUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": newUserResponse(user)})
+}
+
+// UpdateMe updates the authenticated user
+// @Summary Update the current user
+// @Description Update the given fields of the current user's profile. A new email address must be verified again. Use PUT /me/password to change the password.
+// @Tags me
+// @Accept json
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param user body UpdateProfileRequest true "Fields to update"
+// @Success 200 {object} UserResponse
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /me [patch]
+func (ctrl *UserController) UpdateMe(c *gin.Context) {
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := ctrl.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if req.Country != "" {
+		user.Country = req.Country
+	}
+	if req.Email != "" {
+		user.Email = req.Email
+	}
+
+	user, err := ctrl.service.UpdateUser(c.Request.Context(), strconv.FormatUint(uint64(user.ID), 10), user)
+	if err != nil {
+		if writeForbidden(c, err) || writeConflictError(c, err) || writeEmailError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": newUserResponse(user)})
+}
+
+// DeleteMe deletes the authenticated user
+// @Summary Delete the current user
+// @Description Delete the account the access token belongs to
+// @Tags me
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Success 200 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /me [delete]
+func (ctrl *UserController) DeleteMe(c *gin.Context) {
+	user, ok := ctrl.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := ctrl.service.DeleteUser(c.Request.Context(), strconv.FormatUint(uint64(user.ID), 10), 0); err != nil {
+		if writeForbidden(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "User deleted"})
+}
+
+// ChangeMyPassword changes the authenticated user's password
+// @Summary Change the current user's password
+// @Description Replace the password after verifying the current one. All existing sessions, including the current one, are revoked. A password that breaks the password policy is rejected with the list of violations.
+// @Tags me
+// @Accept json
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param request body ChangePasswordRequest true "Current and new password"
+// @Success 200 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /me/password [put]
+func (ctrl *UserController) ChangeMyPassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "required fields [current_password, new_password]"})
+		return
+	}
+
+	user, ok := ctrl.currentUser(c)
+	if !ok {
+		return
+	}
+
+	err := ctrl.service.ChangePassword(c.Request.Context(), strconv.FormatUint(uint64(user.ID), 10), req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		if errors.Is(err, services.ErrIncorrectPassword) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if writeForbidden(c, err) || writePasswordPolicyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "Password updated, please log in again"})
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"errors"
+	"go-rest-api/auth"
+	"go-rest-api/models"
+	"go-rest-api/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestGetMe(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().GetCurrentUser(gomock.Any()).Return(models.User{Username: "testuser", Country: "usa"}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/me", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "testuser")
+}
+
+func TestGetMe_UserGone(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().GetCurrentUser(gomock.Any()).Return(models.User{}, gorm.ErrRecordNotFound)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/me", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestGetMe_APIKeyNotScoped(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().GetCurrentUser(gomock.Any()).Return(models.User{}, &services.AuthorizationError{Action: "read current user", Reason: "API key is not scoped to profile:read"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/me", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestUpdateMe(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	current := models.User{Model: gorm.Model{ID: 7}, Username: "testuser", Password: "hash", Country: "usa"}
+	updated := current
+	updated.Country = "india"
+
+	mockUserService.EXPECT().GetCurrentUser(gomock.Any()).Return(current, nil)
+	mockUserService.EXPECT().UpdateUser(gomock.Any(), "7", updated).Return(updated, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PATCH", "/me", strings.NewReader(`{"country":"india"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "india")
+}
+
+func TestUpdateMe_KeepsOmittedFields(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	current := models.User{Model: gorm.Model{ID: 7}, Username: "testuser", Password: "hash", Country: "usa"}
+
+	mockUserService.EXPECT().GetCurrentUser(gomock.Any()).Return(current, nil)
+	mockUserService.EXPECT().UpdateUser(gomock.Any(), "7", current).Return(current, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PATCH", "/me", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "usa")
+}
+
+func TestDeleteMe(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().GetCurrentUser(gomock.Any()).Return(models.User{Model: gorm.Model{ID: 7}, Username: "testuser"}, nil)
+	mockUserService.EXPECT().DeleteUser(gomock.Any(), "7", uint(0)).Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/me", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "User deleted")
+}
+
+func TestChangeMyPassword(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().GetCurrentUser(gomock.Any()).Return(models.User{Model: gorm.Model{ID: 7}, Username: "testuser"}, nil)
+	mockUserService.EXPECT().ChangePassword(gomock.Any(), "7", "old-password", "new-password").Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/me/password", strings.NewReader(`{"current_password":"old-password","new_password":"new-password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Password updated, please log in again")
+}
+
+func TestChangeMyPassword_MissingCurrent(t *testing.T) {
+	router, _, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/me/password", strings.NewReader(`{"new_password":"new-password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "required fields [current_password, new_password]")
+}
+
+func TestChangeMyPassword_WrongCurrent(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().GetCurrentUser(gomock.Any()).Return(models.User{Model: gorm.Model{ID: 7}, Username: "testuser"}, nil)
+	mockUserService.EXPECT().ChangePassword(gomock.Any(), "7", "wrong", "new-password").Return(services.ErrIncorrectPassword)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/me/password", strings.NewReader(`{"current_password":"wrong","new_password":"new-password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), services.ErrIncorrectPassword.Error())
+}
+
+func TestChangeMyPassword_PasswordPolicy(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	policyErr := &auth.PasswordPolicyError{Violations: []auth.PasswordViolation{
+		{Code: auth.ViolationBreached, Message: "appears in a list of breached passwords"},
+	}}
+	mockUserService.EXPECT().GetCurrentUser(gomock.Any()).Return(models.User{Model: gorm.Model{ID: 7}, Username: "testuser"}, nil)
+	mockUserService.EXPECT().ChangePassword(gomock.Any(), "7", "old-password", "password").Return(policyErr)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/me/password", strings.NewReader(`{"current_password":"old-password","new_password":"password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), auth.ViolationBreached)
+}
+
+func TestChangeMyPassword_Fail_DBErr(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().GetCurrentUser(gomock.Any()).Return(models.User{Model: gorm.Model{ID: 7}, Username: "testuser"}, nil)
+	mockUserService.EXPECT().ChangePassword(gomock.Any(), "7", "old-password", "new-password").Return(errors.New("failed to save"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/me/password", strings.NewReader(`{"current_password":"old-password","new_password":"new-password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "failed to save")
+}
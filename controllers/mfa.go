@@ -0,0 +1,178 @@
+// controllers/mfa.go
+package controllers
+
+import (
+	"errors"
+	"go-rest-api/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	// Code is a TOTP code or one of the recovery codes
+	Code string `json:"code" binding:"required"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// writeMFAError writes the response for errors shared by the MFA management
+// endpoints and reports whether err was one of those
+func writeMFAError(c *gin.Context, err error) bool {
+	var authErr *services.AuthorizationError
+	switch {
+	case errors.As(err, &authErr):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFANotEnrolled), errors.Is(err, services.ErrInvalidMFACode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+
+	return true
+}
+
+// LoginMFA finishes a two step login
+// @Summary Complete a login with a second factor
+// @Description Exchange the mfa_token returned by /login and a TOTP or recovery code for a JWT access token and a refresh token
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body MFALoginRequest true "MFA token and code"
+// @Success 200 {object} models.TokenPair
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 429 {object} gin.H
+// @Header 429 {integer} Retry-After "Seconds until the next attempt is allowed"
+// @Failure 500 {object} gin.H
+// @Router /login/mfa [post]
+func (ctrl *UserController) LoginMFA(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "required fields [mfa_token, code]"})
+		return
+	}
+
+	tokens, err := ctrl.service.LoginMFA(req.MFAToken, req.Code, c.ClientIP())
+	if err != nil {
+		if writeLockedOut(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrInvalidMFAToken) || errors.Is(err, services.ErrInvalidMFACode) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctrl.writeTokens(c, tokens)
+}
+
+// EnrollTOTP starts enrolling an authenticator app
+// @Summary Start TOTP enrollment
+// @Description Generate a TOTP secret for the current user. It takes effect once a code is confirmed.
+// @Tags me
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Success 200 {object} models.TOTPEnrollment
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /me/mfa/totp [post]
+func (ctrl *UserController) EnrollTOTP(c *gin.Context) {
+	enrollment, err := ctrl.service.EnrollTOTP(c.Request.Context())
+	if err != nil {
+		if writeMFAError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": enrollment})
+}
+
+// ConfirmTOTP enables two-factor authentication
+// @Summary Confirm TOTP enrollment
+// @Description Enable two-factor authentication with a code from the authenticator app. The recovery codes in the response are shown only once.
+// @Tags me
+// @Accept json
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param request body TOTPCodeRequest true "Code from the authenticator app"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /me/mfa/totp/confirm [post]
+func (ctrl *UserController) ConfirmTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "required fields [code]"})
+		return
+	}
+
+	codes, err := ctrl.service.ConfirmTOTP(c.Request.Context(), req.Code)
+	if err != nil {
+		if writeMFAError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": RecoveryCodesResponse{RecoveryCodes: codes}})
+}
+
+// DisableTOTP turns two-factor authentication off
+// @Summary Disable TOTP
+// @Description Turn two-factor authentication off using a current TOTP or recovery code. Remaining recovery codes are deleted. Wrong codes count towards the login lockout.
+// @Tags me
+// @Accept json
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param request body TOTPCodeRequest true "TOTP or recovery code"
+// @Success 200 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 429 {object} gin.H
+// @Header 429 {integer} Retry-After "Seconds until the next attempt is allowed"
+// @Failure 500 {object} gin.H
+// @Router /me/mfa/totp [delete]
+func (ctrl *UserController) DisableTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "required fields [code]"})
+		return
+	}
+
+	if err := ctrl.service.DisableTOTP(c.Request.Context(), req.Code, c.ClientIP()); err != nil {
+		if writeLockedOut(c, err) || writeMFAError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "Two-factor authentication disabled"})
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"go-rest-api/models"
+	"go-rest-api/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogin_MFARequired(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	challenge := models.MFAChallenge{MFARequired: true, MFAToken: "mfa-token", ExpiresIn: 300}
+	mockUserService.EXPECT().Login("testuser", "password", gomock.Any()).Return(models.TokenPair{}, &services.MFARequiredError{Challenge: challenge})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"username":"testuser","password":"password"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"mfa_required":true`)
+	assert.Contains(t, w.Body.String(), `"mfa_token":"mfa-token"`)
+	assert.NotContains(t, w.Body.String(), `"refresh_token"`)
+}
+
+func TestLoginMFA(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().LoginMFA("mfa-token", "123456", gomock.Any()).Return(models.TokenPair{AccessToken: "access", RefreshToken: "refresh"}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login/mfa", strings.NewReader(`{"mfa_token":"mfa-token","code":"123456"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "refresh")
+}
+
+func TestLoginMFA_InvalidCode(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().LoginMFA("mfa-token", "000000", gomock.Any()).Return(models.TokenPair{}, services.ErrInvalidMFACode)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login/mfa", strings.NewReader(`{"mfa_token":"mfa-token","code":"000000"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestLoginMFA_BadRequest(t *testing.T) {
+	router, _, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login/mfa", strings.NewReader(`{"code":"123456"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "required fields [mfa_token, code]")
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().EnrollTOTP(gomock.Any()).Return(models.TOTPEnrollment{Secret: "SECRET", URI: "otpauth://totp/x"}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/me/mfa/totp", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"otpauth_uri":"otpauth://totp/x"`)
+}
+
+func TestEnrollTOTP_AlreadyEnabled(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().EnrollTOTP(gomock.Any()).Return(models.TOTPEnrollment{}, services.ErrMFAAlreadyEnabled)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/me/mfa/totp", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestEnrollTOTP_APIKey(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().EnrollTOTP(gomock.Any()).Return(models.TOTPEnrollment{}, &services.AuthorizationError{Action: "enroll two-factor authentication", Reason: "needs a login, not an API key"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/me/mfa/totp", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestConfirmTOTP(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().ConfirmTOTP(gomock.Any(), "123456").Return([]string{"aaaa-bbbb-cccc-dddd"}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/me/mfa/totp/confirm", strings.NewReader(`{"code":"123456"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "aaaa-bbbb-cccc-dddd")
+}
+
+func TestConfirmTOTP_InvalidCode(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().ConfirmTOTP(gomock.Any(), "000000").Return(nil, services.ErrInvalidMFACode)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/me/mfa/totp/confirm", strings.NewReader(`{"code":"000000"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDisableTOTP(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().DisableTOTP(gomock.Any(), "123456", gomock.Any()).Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/me/mfa/totp", strings.NewReader(`{"code":"123456"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestDisableTOTP_NotEnrolled(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().DisableTOTP(gomock.Any(), "123456", gomock.Any()).Return(services.ErrMFANotEnrolled)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/me/mfa/totp", strings.NewReader(`{"code":"123456"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
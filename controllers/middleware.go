@@ -1,38 +1,160 @@
 package controllers
 
 import (
-	"fmt"
+	"errors"
+	"go-rest-api/auth"
+	"go-rest-api/services"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 )
 
-// Middleware to verify JWT
-func AuthMiddleware() gin.HandlerFunc {
+// apiKeyFromRequest returns the API key sent as "Authorization: ApiKey <key>"
+// or in the X-API-Key header
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	scheme, key, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, "ApiKey") {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
+
+// tokenFromRequest returns the token of the first source that finds one
+func tokenFromRequest(c *gin.Context, sources []TokenSource) (string, error) {
+	for _, source := range sources {
+		token, err := source.Token(c)
+		if err != nil || token != "" {
+			return token, err
+		}
+	}
+	return "", nil
+}
+
+// AuthMiddleware verifies the API key or the access token of a request. The
+// access token is looked up in sources in order; only "Authorization: Bearer"
+// is accepted when none are given.
+func AuthMiddleware(service services.UserService, sources ...TokenSource) gin.HandlerFunc {
+	if len(sources) == 0 {
+		sources = []TokenSource{BearerToken{}}
+	}
+
 	return func(c *gin.Context) {
-		tokenStr := c.Request.Header.Get("Authorization")
+		if key := apiKeyFromRequest(c.Request); key != "" {
+			principal, err := service.ValidateAPIKey(key)
+			if err != nil {
+				if errors.Is(err, services.ErrInvalidAPIKey) {
+					c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+					c.Abort()
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+
+			setPrincipal(c, principal)
+			c.Next()
+			return
+		}
+
+		tokenStr, err := tokenFromRequest(c, sources)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
 		if tokenStr == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Request does not contain an access token"})
 			c.Abort()
 			return
 		}
 
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		claims, err := service.ValidateToken(tokenStr)
+		if err != nil {
+			if errors.Is(err, services.ErrTokenRevoked) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+				c.Abort()
+				return
+			}
+			if errors.Is(err, services.ErrInvalidToken) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
 			}
-			return jwtKey, nil
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.Set("claims", claims)
+		setPrincipal(c, auth.Principal{
+			Username: claims.Username,
+			Role:     claims.Role,
 		})
+		c.Next()
+	}
+}
+
+// setPrincipal stores the authenticated caller in the gin context and in the
+// request context the services read it from
+func setPrincipal(c *gin.Context, principal auth.Principal) {
+	c.Set("username", principal.Username)
+	c.Set("role", principal.Role)
+	c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), principal))
+}
+
+// RequireAudience only lets access tokens meant for one of the audiences
+// through, on top of the audiences checked for every token. API keys are not
+// tokens and pass; their scopes restrict them instead. Without audiences it
+// lets everything through, so routes can be wired unconditionally. It must
+// run after AuthMiddleware.
+func RequireAudience(audiences ...string) gin.HandlerFunc {
+	var required []string
+	for _, audience := range audiences {
+		if audience != "" {
+			required = append(required, audience)
+		}
+	}
+
+	return func(c *gin.Context) {
+		value, isToken := c.Get("claims")
+		if len(required) == 0 || !isToken {
+			c.Next()
+			return
+		}
+
+		claims := value.(*auth.Claims)
+		if !auth.HasAudience(&claims.RegisteredClaims, required...) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token is not valid for this audience"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequirePermission only lets callers whose role grants permission through.
+// Callers using an API key also need the permission among the key's scopes.
+// It must run after AuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := auth.PrincipalFromContext(c.Request.Context())
+		if !ok {
+			principal = auth.Principal{Role: c.GetString("role")}
+		}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		if !principal.Can(permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
 			c.Abort()
 			return
 		}
 
-		c.Set("username", claims.Username)
 		c.Next()
 	}
 }
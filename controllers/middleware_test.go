@@ -1,22 +1,31 @@
 package controllers
 
 import (
+	"errors"
+	"go-rest-api/auth"
+	"go-rest-api/services"
+	svcMock "go-rest-api/services/mocks"
 	"net/http"
 	"net/http/httptest"
 	"testing"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestAuthMiddleware(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockUserService := svcMock.NewMockUserService(ctrl)
+
 	router := gin.New()
-	router.Use(AuthMiddleware())
+	router.Use(AuthMiddleware(mockUserService))
 	router.GET("/test", func(c *gin.Context) {
 		username := c.MustGet("username").(string)
-		c.JSON(http.StatusOK, gin.H{"username": username})
+		principal, _ := auth.PrincipalFromContext(c.Request.Context())
+		c.JSON(http.StatusOK, gin.H{"username": username, "role": c.GetString("role"), "principal": principal.Username})
 	})
 
 	t.Run("No Authorization Header", func(t *testing.T) {
@@ -28,57 +37,265 @@ func TestAuthMiddleware(t *testing.T) {
 		assert.Contains(t, w.Body.String(), "Request does not contain an access token")
 	})
 
-	t.Run("Invalid Token - Incorrect Signing Method", func(t *testing.T) {
-		token := jwt.NewWithClaims(jwt.SigningMethodHS512, &Claims{
-			Username: "testuser",
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(time.Hour).Unix(),
-			},
-		})
-		tokenStr, _ := token.SignedString([]byte("my_secret_key"))
+	t.Run("Invalid Token", func(t *testing.T) {
+		mockUserService.EXPECT().ValidateToken("invalid-token").Return(nil, services.ErrInvalidToken)
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/test", nil)
-		req.Header.Set("Authorization", tokenStr)
+		req.Header.Set("Authorization", "Bearer invalid-token")
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusUnauthorized, w.Code)
 		assert.Contains(t, w.Body.String(), "Invalid token")
 	})
 
-	t.Run("Invalid Token - Tampered Token", func(t *testing.T) {
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
-			Username: "testuser",
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(time.Hour).Unix(),
-			},
-		})
-		tokenStr, _ := token.SignedString([]byte("wrong_secret_key"))
+	t.Run("Revoked Token", func(t *testing.T) {
+		mockUserService.EXPECT().ValidateToken("revoked-token").Return(nil, services.ErrTokenRevoked)
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/test", nil)
-		req.Header.Set("Authorization", tokenStr)
+		req.Header.Set("Authorization", "Bearer revoked-token")
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusUnauthorized, w.Code)
-		assert.Contains(t, w.Body.String(), "Invalid token")
+		assert.Contains(t, w.Body.String(), "Token has been revoked")
+	})
+
+	t.Run("Revocation Lookup Fails", func(t *testing.T) {
+		mockUserService.EXPECT().ValidateToken("some-token").Return(nil, errors.New("database unavailable"))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "Bearer some-token")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
 	})
 
 	t.Run("Valid Token", func(t *testing.T) {
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
-			Username: "testuser",
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(time.Hour).Unix(),
-			},
-		})
-		tokenStr, _ := token.SignedString(jwtKey)
+		mockUserService.EXPECT().ValidateToken("valid-token").Return(&auth.Claims{Username: "testuser", Role: auth.RoleAdmin}, nil)
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/test", nil)
-		req.Header.Set("Authorization", tokenStr)
+		req.Header.Set("Authorization", "Bearer valid-token")
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
-		assert.Contains(t, w.Body.String(), "testuser")
+		assert.Contains(t, w.Body.String(), `"principal":"testuser"`)
+		assert.Contains(t, w.Body.String(), auth.RoleAdmin)
+	})
+
+	t.Run("API Key Scheme", func(t *testing.T) {
+		principal := auth.Principal{Username: "batchuser", Role: auth.RoleUser, APIKeyID: 3}
+		mockUserService.EXPECT().ValidateAPIKey("gra_abcdefgh.secret").Return(principal, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "ApiKey gra_abcdefgh.secret")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"principal":"batchuser"`)
+		assert.Contains(t, w.Body.String(), `"username":"batchuser"`)
+	})
+
+	t.Run("X-API-Key Header", func(t *testing.T) {
+		principal := auth.Principal{Username: "batchuser", Role: auth.RoleUser, APIKeyID: 3}
+		mockUserService.EXPECT().ValidateAPIKey("gra_abcdefgh.secret").Return(principal, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-API-Key", "gra_abcdefgh.secret")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"principal":"batchuser"`)
+	})
+
+	t.Run("Invalid API Key", func(t *testing.T) {
+		mockUserService.EXPECT().ValidateAPIKey("gra_revoked.secret").Return(auth.Principal{}, services.ErrInvalidAPIKey)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "apikey gra_revoked.secret")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "Invalid API key")
 	})
 }
+
+func TestAuthMiddleware_TokenSources(t *testing.T) {
+	cookie := DefaultCookieToken
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		header    map[string]string
+		cookies   map[string]string
+		wantToken string
+		wantCode  int
+	}{
+		{name: "Token Without Scheme", method: "GET", target: "/test", header: map[string]string{"Authorization": "valid-token"}, wantCode: http.StatusUnauthorized},
+		{name: "Lower Case Bearer", method: "GET", target: "/test", header: map[string]string{"Authorization": "bearer valid-token"}, wantToken: "valid-token", wantCode: http.StatusOK},
+		{name: "Cookie On GET", method: "GET", target: "/test", cookies: map[string]string{"access_token": "valid-token"}, wantToken: "valid-token", wantCode: http.StatusOK},
+		{name: "Cookie Without CSRF Token", method: "POST", target: "/test", cookies: map[string]string{"access_token": "valid-token", "csrf_token": "csrf"}, wantCode: http.StatusForbidden},
+		{
+			name:     "Cookie With Wrong CSRF Token",
+			method:   "POST",
+			target:   "/test",
+			header:   map[string]string{"X-CSRF-Token": "forged"},
+			cookies:  map[string]string{"access_token": "valid-token", "csrf_token": "csrf"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:      "Cookie With CSRF Token",
+			method:    "POST",
+			target:    "/test",
+			header:    map[string]string{"X-CSRF-Token": "csrf"},
+			cookies:   map[string]string{"access_token": "valid-token", "csrf_token": "csrf"},
+			wantToken: "valid-token",
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "Bearer Header Wins Over Cookie",
+			method:    "POST",
+			target:    "/test",
+			header:    map[string]string{"Authorization": "Bearer header-token"},
+			cookies:   map[string]string{"access_token": "valid-token"},
+			wantToken: "header-token",
+			wantCode:  http.StatusOK,
+		},
+		{name: "Query Parameter On WebSocket Upgrade", method: "GET", target: "/test?access_token=valid-token", header: map[string]string{"Upgrade": "websocket"}, wantToken: "valid-token", wantCode: http.StatusOK},
+		{name: "Query Parameter On Event Stream", method: "GET", target: "/test?access_token=valid-token", header: map[string]string{"Accept": "text/event-stream"}, wantToken: "valid-token", wantCode: http.StatusOK},
+		{name: "Query Parameter On Plain Request", method: "GET", target: "/test?access_token=valid-token", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockUserService := svcMock.NewMockUserService(ctrl)
+			if tt.wantToken != "" {
+				mockUserService.EXPECT().ValidateToken(tt.wantToken).Return(&auth.Claims{Username: "testuser", Role: auth.RoleUser}, nil)
+			}
+
+			router := gin.New()
+			router.Use(AuthMiddleware(mockUserService, BearerToken{}, cookie, QueryToken{Param: "access_token"}))
+			router.Handle(tt.method, "/test", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"username": c.GetString("username")})
+			})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.target, nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			for name, value := range tt.cookies {
+				req.AddCookie(&http.Cookie{Name: name, Value: value})
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestRequirePermission_APIKeyScopes(t *testing.T) {
+	tests := []struct {
+		name     string
+		scopes   []string
+		wantCode int
+	}{
+		{name: "Scoped", scopes: []string{auth.PermListUsers}, wantCode: http.StatusOK},
+		{name: "Not Scoped", scopes: []string{auth.PermUnlockUsers}, wantCode: http.StatusForbidden},
+		{name: "No Scopes", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal := auth.Principal{Username: "admin", Role: auth.RoleAdmin, APIKeyID: 3, Scopes: tt.scopes}
+			router := gin.New()
+			router.GET("/test", func(c *gin.Context) {
+				setPrincipal(c, principal)
+			}, RequirePermission(auth.PermListUsers), func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"data": "ok"})
+			})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/test", nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestRequireAudience(t *testing.T) {
+	tests := []struct {
+		name     string
+		required []string
+		claims   *auth.Claims
+		wantCode int
+	}{
+		{name: "Matching Audience", required: []string{"admin"}, claims: &auth.Claims{RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"api", "admin"}}}, wantCode: http.StatusOK},
+		{name: "Other Audience", required: []string{"admin"}, claims: &auth.Claims{RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"api"}}}, wantCode: http.StatusForbidden},
+		{name: "No Requirement", required: []string{""}, claims: &auth.Claims{}, wantCode: http.StatusOK},
+		{name: "API Key", required: []string{"admin"}, wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/test", func(c *gin.Context) {
+				if tt.claims != nil {
+					c.Set("claims", tt.claims)
+				}
+			}, RequireAudience(tt.required...), func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"data": "ok"})
+			})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/test", nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func setupRoleRouter(role string, guard gin.HandlerFunc) *gin.Engine {
+	router := gin.New()
+	router.GET("/test", func(c *gin.Context) {
+		c.Set("role", role)
+	}, guard, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": "ok"})
+	})
+	return router
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       string
+		permission string
+		wantCode   int
+	}{
+		{name: "Admin Lists Users", role: auth.RoleAdmin, permission: auth.PermListUsers, wantCode: http.StatusOK},
+		{name: "Admin Deletes Users", role: auth.RoleAdmin, permission: auth.PermDeleteUsers, wantCode: http.StatusOK},
+		{name: "User Lists Users", role: auth.RoleUser, permission: auth.PermListUsers, wantCode: http.StatusForbidden},
+		{name: "User Deletes Users", role: auth.RoleUser, permission: auth.PermDeleteUsers, wantCode: http.StatusForbidden},
+		{name: "Unknown Role", role: "superuser", permission: auth.PermListUsers, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupRoleRouter(tt.role, RequirePermission(tt.permission))
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/test", nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode == http.StatusForbidden {
+				assert.Contains(t, w.Body.String(), "Insufficient permissions")
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+// controllers/oidc.go
+package controllers
+
+import (
+	"errors"
+	"go-rest-api/models"
+	"go-rest-api/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// oidcStateCookie binds a sign in at an external provider to the browser that
+// started it
+const oidcStateCookie = "oidc_state"
+
+// writeOIDCError writes the response for errors shared by the social login
+// endpoints and reports whether err was one of those
+func writeOIDCError(c *gin.Context, err error) bool {
+	var authErr *services.AuthorizationError
+	switch {
+	case errors.Is(err, services.ErrUnknownProvider):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidOIDCState):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrOIDCLoginFailed):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrOIDCSignUpDisabled), errors.Is(err, services.ErrEmailNotVerified), errors.As(err, &authErr):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrIdentityLinked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err == gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+	default:
+		return false
+	}
+
+	return true
+}
+
+// setOIDCState stores the state token for the callback in an HttpOnly cookie.
+// SameSite=Lax lets it through on the top-level redirect back from the
+// provider.
+func (ctrl *UserController) setOIDCState(c *gin.Context, redirect models.OIDCRedirect) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, redirect.StateToken, 600, "/auth", "", !ctrl.insecureCookies, true)
+}
+
+// OIDCLogin redirects to an external provider to sign in
+// @Summary Sign in with an external provider
+// @Description Redirect to the OpenID Connect provider's sign in page. The provider sends the user back to /auth/{provider}/callback.
+// @Tags user
+// @Param provider path string true "Provider name"
+// @Success 302
+// @Failure 404 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /auth/{provider}/login [get]
+func (ctrl *UserController) OIDCLogin(c *gin.Context) {
+	redirect, err := ctrl.service.StartOIDCLogin(c.Request.Context(), c.Param("provider"), false)
+	if err != nil {
+		if writeOIDCError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctrl.setOIDCState(c, redirect)
+	c.Redirect(http.StatusFound, redirect.AuthorizationURL)
+}
+
+// OIDCCallback finishes a sign in at an external provider
+// @Summary Complete a sign in with an external provider
+// @Description Exchange the code the provider redirected back with for a JWT access token and a refresh token. Users with two-factor authentication get an MFA challenge to complete at /login/mfa instead. Unlinked identities are linked or signed up as the provider config allows.
+// @Tags user
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State"
+// @Success 200 {object} models.TokenPair
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /auth/{provider}/callback [get]
+func (ctrl *UserController) OIDCCallback(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign in was not completed: " + providerErr})
+		return
+	}
+
+	stateToken, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidOIDCState.Error()})
+		return
+	}
+	// The state is good for a single attempt
+	c.SetCookie(oidcStateCookie, "", -1, "/auth", "", !ctrl.insecureCookies, true)
+
+	tokens, err := ctrl.service.CompleteOIDCLogin(c.Request.Context(), c.Param("provider"), stateToken, c.Query("state"), c.Query("code"))
+	if err != nil {
+		var mfaErr *services.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			c.JSON(http.StatusOK, mfaErr.Challenge)
+			return
+		}
+		if writeOIDCError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctrl.writeTokens(c, tokens)
+}
+
+// LinkIdentity starts linking an external identity to the current user
+// @Summary Link an external identity
+// @Description Start a sign in at the provider that links the identity to the current user. Open authorization_url in the same browser; the callback at /auth/{provider}/callback completes the link.
+// @Tags me
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param provider path string true "Provider name"
+// @Success 200 {object} models.OIDCRedirect
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /me/identities/{provider} [post]
+func (ctrl *UserController) LinkIdentity(c *gin.Context) {
+	redirect, err := ctrl.service.StartOIDCLogin(c.Request.Context(), c.Param("provider"), true)
+	if err != nil {
+		if writeOIDCError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctrl.setOIDCState(c, redirect)
+	c.JSON(http.StatusOK, gin.H{"data": redirect})
+}
+
+// ListIdentities lists the external identities of the current user
+// @Summary List linked identities
+// @Description List the external provider identities linked to the current user
+// @Tags me
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Success 200 {array} models.UserIdentity
+// @Failure 403 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /me/identities [get]
+func (ctrl *UserController) ListIdentities(c *gin.Context) {
+	identities, err := ctrl.service.ListIdentities(c.Request.Context())
+	if err != nil {
+		if writeOIDCError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": identities})
+}
+
+// UnlinkIdentity removes an external identity from the current user
+// @Summary Unlink an identity
+// @Description Remove a linked external identity. The provider can no longer be used to sign in as the current user.
+// @Tags me
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path int true "Identity ID"
+// @Success 200 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /me/identities/{id} [delete]
+func (ctrl *UserController) UnlinkIdentity(c *gin.Context) {
+	if err := ctrl.service.UnlinkIdentity(c.Request.Context(), c.Param("id")); err != nil {
+		if writeOIDCError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "Identity unlinked"})
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"errors"
+	"go-rest-api/models"
+	"go-rest-api/services"
+	svcMock "go-rest-api/services/mocks"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	redirect := models.OIDCRedirect{AuthorizationURL: "https://accounts.example.com/authorize?state=abc", StateToken: "state-token"}
+	mockUserService.EXPECT().StartOIDCLogin(gomock.Any(), "google", false).Return(redirect, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/google/login", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, redirect.AuthorizationURL, w.Header().Get("Location"))
+	cookie := w.Header().Get("Set-Cookie")
+	assert.Contains(t, cookie, "oidc_state=state-token")
+	assert.Contains(t, cookie, "HttpOnly")
+	assert.Contains(t, cookie, "SameSite=Lax")
+	// Set even over plain HTTP, which is all the API sees behind a TLS
+	// terminating proxy
+	assert.Contains(t, cookie, "Secure")
+}
+
+func TestOIDCLogin_InsecureStateCookie(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockUserService := svcMock.NewMockUserService(ctrl)
+	userController := NewUserController(mockUserService)
+	userController.AllowInsecureCookies()
+
+	router := gin.New()
+	router.GET("/auth/:provider/login", userController.OIDCLogin)
+	router.GET("/auth/:provider/callback", userController.OIDCCallback)
+
+	redirect := models.OIDCRedirect{AuthorizationURL: "https://accounts.example.com/authorize?state=abc", StateToken: "state-token"}
+	mockUserService.EXPECT().StartOIDCLogin(gomock.Any(), "google", false).Return(redirect, nil)
+	mockUserService.EXPECT().CompleteOIDCLogin(gomock.Any(), "google", "state-token", "abc", "code-1").Return(models.TokenPair{AccessToken: "access"}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/google/login", nil)
+	router.ServeHTTP(w, req)
+
+	w2 := httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/auth/google/callback?code=code-1&state=abc", nil)
+	req.AddCookie(&http.Cookie{Name: "oidc_state", Value: "state-token"})
+	router.ServeHTTP(w2, req)
+
+	for _, cookie := range append(w.Result().Cookies(), w2.Result().Cookies()...) {
+		assert.False(t, cookie.Secure, cookie.Name)
+	}
+}
+
+func TestOIDCLogin_UnknownProvider(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().StartOIDCLogin(gomock.Any(), "nope", false).Return(models.OIDCRedirect{}, services.ErrUnknownProvider)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/nope/login", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestOIDCCallback(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	tokens := models.TokenPair{AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 300}
+	mockUserService.EXPECT().CompleteOIDCLogin(gomock.Any(), "google", "state-token", "abc", "code-1").Return(tokens, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/google/callback?code=code-1&state=abc", nil)
+	req.AddCookie(&http.Cookie{Name: "oidc_state", Value: "state-token"})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"token":"access"`)
+	assert.Contains(t, w.Header().Get("Set-Cookie"), "oidc_state=;")
+	assert.Contains(t, w.Header().Get("Set-Cookie"), "Secure")
+}
+
+func TestOIDCCallback_MissingCookie(t *testing.T) {
+	router, _, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/google/callback?code=code-1&state=abc", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestOIDCCallback_ProviderError(t *testing.T) {
+	router, _, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/google/callback?error=access_denied&state=abc", nil)
+	req.AddCookie(&http.Cookie{Name: "oidc_state", Value: "state-token"})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "access_denied")
+}
+
+func TestOIDCCallback_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+		wantBody string
+	}{
+		{name: "MFA Required", err: &services.MFARequiredError{Challenge: models.MFAChallenge{MFARequired: true, MFAToken: "mfa"}}, wantCode: http.StatusOK, wantBody: `"mfa_token":"mfa"`},
+		{name: "Invalid State", err: services.ErrInvalidOIDCState, wantCode: http.StatusBadRequest},
+		{name: "Exchange Failed", err: services.ErrOIDCLoginFailed, wantCode: http.StatusUnauthorized},
+		{name: "Sign Up Disabled", err: services.ErrOIDCSignUpDisabled, wantCode: http.StatusForbidden},
+		{name: "Identity Of Another User", err: services.ErrIdentityLinked, wantCode: http.StatusConflict},
+		{name: "Database Error", err: errors.New("database unavailable"), wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, mockUserService, ctrl := setupTest()
+			defer ctrl.Finish()
+
+			mockUserService.EXPECT().CompleteOIDCLogin(gomock.Any(), "google", "state-token", "abc", "code-1").Return(models.TokenPair{}, tt.err)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/auth/google/callback?code=code-1&state=abc", nil)
+			req.AddCookie(&http.Cookie{Name: "oidc_state", Value: "state-token"})
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantBody != "" {
+				assert.Contains(t, w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestLinkIdentity(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	redirect := models.OIDCRedirect{AuthorizationURL: "https://accounts.example.com/authorize?state=abc", StateToken: "state-token"}
+	mockUserService.EXPECT().StartOIDCLogin(gomock.Any(), "google", true).Return(redirect, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/me/identities/google", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"authorization_url"`)
+	assert.NotContains(t, w.Body.String(), "state-token")
+	assert.Contains(t, w.Header().Get("Set-Cookie"), "oidc_state=state-token")
+}
+
+func TestListIdentities(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	identities := []models.UserIdentity{{ID: 3, UserID: 1, Provider: "google", Subject: "248289761001", Email: "jane@example.com"}}
+	mockUserService.EXPECT().ListIdentities(gomock.Any()).Return(identities, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/me/identities", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"provider":"google"`)
+	assert.NotContains(t, w.Body.String(), "user_id")
+}
+
+func TestListIdentities_APIKeyNotScoped(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().ListIdentities(gomock.Any()).Return(nil, &services.AuthorizationError{Action: "read current user", Reason: "API key is not scoped to profile:read"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/me/identities", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+	}{
+		{name: "Success", wantCode: http.StatusOK},
+		{name: "Not Found", err: gorm.ErrRecordNotFound, wantCode: http.StatusNotFound},
+		{name: "Database Error", err: errors.New("database unavailable"), wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, mockUserService, ctrl := setupTest()
+			defer ctrl.Finish()
+
+			mockUserService.EXPECT().UnlinkIdentity(gomock.Any(), "3").Return(tt.err)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("DELETE", "/me/identities/3", nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
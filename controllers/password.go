@@ -0,0 +1,92 @@
+// controllers/password.go
+package controllers
+
+import (
+	"errors"
+	"go-rest-api/auth"
+	"go-rest-api/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ForgotPasswordRequest struct {
+	// Username also accepts the email address of the account
+	Username string `json:"username" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// writePasswordPolicyError answers 400 with the list of policy violations when
+// err rejects a new password and reports whether it did
+func writePasswordPolicyError(c *gin.Context, err error) bool {
+	var policyErr *auth.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "violations": policyErr.Violations})
+	return true
+}
+
+// ForgotPassword starts a password reset
+// @Summary Request a password reset
+// @Description Mail a single-use reset token to the user's email address. The response is the same whether or not the user exists.
+// @Tags password
+// @Accept json
+// @Produce json
+// @Param request body ForgotPasswordRequest true "Account to reset"
+// @Success 202 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /password/forgot [post]
+func (ctrl *UserController) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "required fields [username]"})
+		return
+	}
+
+	if err := ctrl.service.ForgotPassword(req.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"data": "If the account exists, a reset link has been sent"})
+}
+
+// ResetPassword completes a password reset
+// @Summary Reset a password
+// @Description Set a new password using a reset token. The token can be used once and all existing sessions are revoked. A password that breaks the password policy is rejected with the list of violations and leaves the token usable.
+// @Tags password
+// @Accept json
+// @Produce json
+// @Param request body ResetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /password/reset [post]
+func (ctrl *UserController) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "required fields [token, new_password]"})
+		return
+	}
+
+	if err := ctrl.service.ResetPassword(req.Token, req.NewPassword); err != nil {
+		if errors.Is(err, services.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if writePasswordPolicyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "Password updated, please log in again"})
+}
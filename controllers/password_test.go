@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+	"go-rest-api/auth"
+	"go-rest-api/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForgotPassword(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().ForgotPassword("testuser").Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/password/forgot", strings.NewReader(`{"username":"testuser"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, w.Body.String(), "If the account exists")
+}
+
+func TestForgotPassword_MissingUsername(t *testing.T) {
+	router, _, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/password/forgot", strings.NewReader(`{}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestResetPassword(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().ResetPassword("reset-token", "new-password").Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/password/reset", strings.NewReader(`{"token":"reset-token","new_password":"new-password"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestResetPassword_InvalidToken(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().ResetPassword("reset-token", "new-password").Return(services.ErrInvalidResetToken)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/password/reset", strings.NewReader(`{"token":"reset-token","new_password":"new-password"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), services.ErrInvalidResetToken.Error())
+}
+
+func TestResetPassword_PasswordPolicy(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	policyErr := &auth.PasswordPolicyError{Violations: []auth.PasswordViolation{
+		{Code: auth.ViolationContainsUsername, Message: "must not contain the username"},
+	}}
+	mockUserService.EXPECT().ResetPassword("reset-token", "testuser-1").Return(policyErr)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/password/reset", strings.NewReader(`{"token":"reset-token","new_password":"testuser-1"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), auth.ViolationContainsUsername)
+}
+
+func TestResetPassword_ServiceError(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().ResetPassword("reset-token", "new-password").Return(errors.New("database unavailable"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/password/reset", strings.NewReader(`{"token":"reset-token","new_password":"new-password"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
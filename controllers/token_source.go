@@ -0,0 +1,118 @@
+// controllers/token_source.go
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"go-rest-api/models"
+	"go-rest-api/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrCSRFTokenMismatch is returned by CookieToken when a state changing request
+// does not echo the CSRF cookie in the CSRF header
+var ErrCSRFTokenMismatch = errors.New("missing or invalid CSRF token")
+
+// TokenSource finds the access token in a request. It returns "" when the
+// request does not carry a token its way, and an error when it carries one
+// that must not be used.
+type TokenSource interface {
+	Token(c *gin.Context) (string, error)
+}
+
+// BearerToken reads the token from "Authorization: Bearer <token>" (RFC 6750)
+type BearerToken struct{}
+
+func (BearerToken) Token(c *gin.Context) (string, error) {
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", nil
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// CookieToken reads the token from an HttpOnly cookie, for browser clients.
+// Browsers send cookies along with cross-site requests, so every request other
+// than GET, HEAD and OPTIONS must repeat the value of the CSRF cookie in the
+// CSRF header (double submit). Only scripts of the site itself can read that
+// cookie.
+type CookieToken struct {
+	// Name of the cookie holding the access token
+	Name string
+	// CSRFCookie names the cookie holding the CSRF token. It is readable by
+	// scripts.
+	CSRFCookie string
+	// CSRFHeader names the header the CSRF token is echoed in
+	CSRFHeader string
+	// Insecure leaves the Secure attribute off the cookies, so that browsers
+	// also send them over plain HTTP. Only meant for local development; behind
+	// a TLS terminating proxy the API cannot tell that the client used HTTPS.
+	Insecure bool
+}
+
+// DefaultCookieToken is the session cookie used when AUTH_COOKIE is enabled
+var DefaultCookieToken = CookieToken{Name: "access_token", CSRFCookie: "csrf_token", CSRFHeader: "X-CSRF-Token"}
+
+func (s CookieToken) Token(c *gin.Context) (string, error) {
+	token, err := c.Cookie(s.Name)
+	if err != nil || token == "" {
+		return "", nil
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return token, nil
+	}
+
+	csrfCookie, _ := c.Cookie(s.CSRFCookie)
+	csrfHeader := c.GetHeader(s.CSRFHeader)
+	if csrfCookie == "" || subtle.ConstantTimeCompare([]byte(csrfCookie), []byte(csrfHeader)) != 1 {
+		return "", ErrCSRFTokenMismatch
+	}
+	return token, nil
+}
+
+// Set stores the access token of a login in the cookie, next to a new CSRF
+// token. Both expire with the access token.
+func (s CookieToken) Set(c *gin.Context, tokens models.TokenPair) error {
+	csrfToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(s.Name, tokens.AccessToken, int(tokens.ExpiresIn), "/", "", !s.Insecure, true)
+	c.SetCookie(s.CSRFCookie, csrfToken, int(tokens.ExpiresIn), "/", "", !s.Insecure, false)
+	return nil
+}
+
+// Clear removes the cookies on logout
+func (s CookieToken) Clear(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(s.Name, "", -1, "/", "", !s.Insecure, true)
+	c.SetCookie(s.CSRFCookie, "", -1, "/", "", !s.Insecure, false)
+}
+
+// QueryToken reads the token from a query parameter. Browsers cannot set
+// headers on EventSource and WebSocket requests, so it is only accepted on GET
+// requests for an event stream or a WebSocket upgrade. URLs end up in logs;
+// keep the token short-lived.
+type QueryToken struct {
+	Param string
+}
+
+func (s QueryToken) Token(c *gin.Context) (string, error) {
+	if c.Request.Method != http.MethodGet {
+		return "", nil
+	}
+
+	upgrade := strings.EqualFold(c.GetHeader("Upgrade"), "websocket")
+	eventStream := strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+	if !upgrade && !eventStream {
+		return "", nil
+	}
+	return c.Query(s.Param), nil
+}
@@ -1,77 +1,125 @@
 package controllers
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go-rest-api/auth"
 	"go-rest-api/models"
+	"go-rest-api/patch"
 	"go-rest-api/services"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	"gorm.io/gorm"
 )
 
-var jwtKey = []byte("secret_key")
-
 type Credentials struct {
+	// Username also accepts the email address of the account
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
-type Claims struct {
-	Username string `json:"username"`
-	jwt.StandardClaims
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 type UserController struct {
 	service services.UserService
+	// sessionCookie, when set, also hands the access token of a login to
+	// browsers as a cookie
+	sessionCookie *CookieToken
+	// insecureCookies leaves the Secure attribute off the other cookies the
+	// controller sets, like CookieToken.Insecure does for the session cookie
+	insecureCookies bool
 }
 
 func NewUserController(service services.UserService) *UserController {
 	return &UserController{service: service}
 }
 
+// UseSessionCookie makes the login endpoints set the access token cookie read
+// by cookie, and Logout clear it
+func (ctrl *UserController) UseSessionCookie(cookie CookieToken) {
+	ctrl.sessionCookie = &cookie
+}
+
+// AllowInsecureCookies sends the cookies the controller sets without the
+// Secure attribute. Only meant for local development over plain HTTP.
+func (ctrl *UserController) AllowInsecureCookies() {
+	ctrl.insecureCookies = true
+}
+
+// writeTokens answers a successful login with the token pair
+func (ctrl *UserController) writeTokens(c *gin.Context, tokens models.TokenPair) {
+	if ctrl.sessionCookie != nil {
+		if err := ctrl.sessionCookie.Set(c, tokens); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
 // SignUp creates a new user
 // @Summary Sign up a new user
-// @Description Create a new user with a username, password, and country
+// @Description Create a new user with a username, password, country and an optional email address. A username that is taken, also by a deleted user unless their usernames are freed, is rejected with 409. A verification link is mailed to the address. A password that breaks the password policy is rejected with the list of violations.
 // @Tags user
 // @Accept json
 // @Produce json
-// @Param user body models.User true "User to create"
-// @Success 200 {object} models.User
+// @Param user body SignUpRequest true "User to create"
+// @Success 200 {object} UserResponse
 // @Failure 400 {object} gin.H
+// @Failure 409 {object} gin.H
 // @Failure 500 {object} gin.H
 // @Router /signup [post]
 func (ctrl *UserController) SignUp(c *gin.Context) {
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	var req SignUpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if user.Country == "" || user.Password == "" {
+	if req.Country == "" || req.Password == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "required fields [country, password]"})
 		return
 	}
 
-	if err := ctrl.service.SignUp(user); err != nil {
+	user, err := ctrl.service.SignUp(req.toUser())
+	if err != nil {
+		if writePasswordPolicyError(c, err) || writeEmailError(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrUsernameTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": user})
+	c.JSON(http.StatusOK, gin.H{"data": newUserResponse(user)})
 }
 
 // Login user
 // Login logs in a user and returns a JWT token
 // @Summary Log in a user
-// @Description Authenticate a user and return a JWT token
+// @Description Authenticate a user by username or email address and return a JWT access token and a refresh token. Users with two-factor authentication get an MFA challenge to complete at /login/mfa instead.
 // @Tags user
 // @Accept json
 // @Produce json
 // @Param credentials body Credentials true "User credentials"
-// @Success 200 {object} gin.H
+// @Success 200 {object} models.TokenPair "Tokens, or a models.MFAChallenge when a second factor is required"
 // @Failure 400 {object} gin.H
 // @Failure 401 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 429 {object} gin.H
+// @Header 429 {integer} Retry-After "Seconds until the next attempt is allowed"
 // @Failure 500 {object} gin.H
 // @Router /login [post]
 func (ctrl *UserController) Login(c *gin.Context) {
@@ -81,118 +129,434 @@ func (ctrl *UserController) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := ctrl.service.Login(creds.Username, creds.Password)
+	tokens, err := ctrl.service.Login(creds.Username, creds.Password, c.ClientIP())
+	if err != nil {
+		if writeLockedOut(c, err) {
+			return
+		}
+		var mfaErr *services.MFARequiredError
+		if errors.As(err, &mfaErr) {
+			c.JSON(http.StatusOK, mfaErr.Challenge)
+			return
+		}
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctrl.writeTokens(c, tokens)
+}
+
+// RefreshToken exchanges a refresh token for a new token pair
+// @Summary Refresh an access token
+// @Description Rotate a refresh token and return a new JWT access token and refresh token
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body RefreshRequest true "Refresh token"
+// @Success 200 {object} models.TokenPair
+// @Failure 400 {object} gin.H
+// @Failure 401 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /token/refresh [post]
+func (ctrl *UserController) RefreshToken(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	tokens, err := ctrl.service.RefreshToken(req.RefreshToken)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	ctrl.writeTokens(c, tokens)
 }
 
-// GetUsers returns all users
-// @Summary Get all users
-// @Description Get a list of all users
+// Logout revokes the current access token
+// @Summary Log out
+// @Description Revoke the access token used for this request and, when given, its refresh token
+// @Tags user
+// @Accept json
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param request body LogoutRequest false "Refresh token to revoke"
+// @Success 200 {object} gin.H
+// @Failure 400 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /logout [post]
+func (ctrl *UserController) Logout(c *gin.Context) {
+	var req LogoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+
+	claims, ok := c.Get("claims")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "API keys are revoked with DELETE /me/api-keys/{id}"})
+		return
+	}
+	if err := ctrl.service.Logout(claims.(*auth.Claims), req.RefreshToken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if ctrl.sessionCookie != nil {
+		ctrl.sessionCookie.Clear(c)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": "Logged out"})
+}
+
+// RevokeSessions revokes all sessions of a user
+// @Summary Revoke all sessions of a user
+// @Description Invalidate every access and refresh token issued to the user
 // @Tags user
 // @Security BearerAuth
 // @Produce json
 // @Param Authorization header string true "Authorization token"
-// @Success 200 {array} models.User
+// @Param id path int true "User ID"
+// @Success 200 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /users/{id}/sessions [delete]
+func (ctrl *UserController) RevokeSessions(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := ctrl.service.RevokeAllSessions(id); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": "Sessions revoked"})
+}
+
+// GetUsers returns a page of users
+// @Summary List users
+// @Description List users page by page, optionally filtered by country, username prefix and creation time. Follow next_cursor to get the next page; it is omitted on the last one. limit defaults to 20 and is capped at 100. offset skips users instead of a cursor.
+// @Tags user
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param country query string false "Exact country"
+// @Param username_prefix query string false "Start of the username"
+// @Param created_after query string false "Only users created after this RFC 3339 time"
+// @Param created_before query string false "Only users created before this RFC 3339 time"
+// @Param sort query string false "id, username, country or created_at; prefix with - for descending order" default(id)
+// @Param limit query int false "Users per page" default(20)
+// @Param offset query int false "Users to skip; cannot be combined with cursor"
+// @Param cursor query string false "next_cursor of the previous page, with the same sort"
+// @Success 200 {object} UserListResponse
+// @Failure 400 {object} gin.H
+// @Failure 403 {object} gin.H
 // @Failure 500 {object} gin.H
 // @Router /users [get]
 func (ctrl *UserController) GetUsers(c *gin.Context) {
-	users, err := ctrl.service.GetUsers()
+	var req ListUsersRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := ctrl.service.GetUsers(req.toQuery())
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidUserQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"data": users})
+	c.JSON(http.StatusOK, newUserListResponse(page))
 }
 
 // GetUser returns a user by ID
 // @Summary Get a user by ID
-// @Description Get a user by their ID
+// @Description Get a user by their ID. Users can get themselves; getting anyone else needs the users:list permission. The ETag header identifies the version of the user; send it back in If-None-Match to get 304 Not Modified while the user is unchanged, or in If-Match to update or delete only that version.
 // @Tags user
 // @Security BearerAuth
 // @Produce json
 // @Param Authorization header string true "Authorization token"
 // @Param id path int true "User ID"
-// @Success 200 {object} models.User
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {object} UserResponse
+// @Header 200 {string} ETag "Version of the user"
+// @Success 304 "Not modified"
 // @Failure 400 {object} gin.H
+// @Failure 403 {object} gin.H
 // @Failure 404 {object} gin.H
 // @Failure 500 {object} gin.H
 // @Router /users/{id} [get]
 func (ctrl *UserController) GetUser(c *gin.Context) {
 	id := c.Param("id")
-	user, err := ctrl.service.GetUser(id)
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	user, ok := ctrl.userToUpdate(c, id)
+	if !ok {
+		return
+	}
+
+	etag := userETag(user)
+	c.Header("ETag", etag)
+	if matchETag(c.GetHeader("If-None-Match"), etag, false) {
+		c.Status(http.StatusNotModified)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"data": user})
+	c.JSON(http.StatusOK, gin.H{"data": newUserResponse(user)})
 }
 
-// UpdateUser updates a user by ID
-// @Summary Update a user by ID
-// @Description Update a user's information by their ID
+// UpdateUser replaces a user by ID
+// @Summary Replace a user by ID
+// @Description Replace the user with the given representation. country and email are taken as given; an omitted email removes the address. Read-only fields such as username may be left out, but changing them is rejected with 422. With If-Match, the user is only replaced while it still has that ETag; otherwise 412 is returned. A concurrent update returns 409. Users can update themselves; updating anyone else needs admin permissions. The password is not changed here, use PUT /me/password.
 // @Tags user
 // @Accept json
 // @Security BearerAuth
 // @Produce json
 // @Param Authorization header string true "Authorization token"
 // @Param id path int true "User ID"
-// @Param user body models.User true "Updated user information"
-// @Success 200 {object} models.User
+// @Param If-Match header string false "ETag the user must still have"
+// @Param user body UpdateUserRequest true "Updated user information"
+// @Success 200 {object} UserResponse
+// @Header 200 {string} ETag "New version of the user"
 // @Failure 400 {object} gin.H
+// @Failure 403 {object} gin.H
 // @Failure 404 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Failure 412 {object} gin.H
+// @Failure 422 {object} gin.H
 // @Failure 500 {object} gin.H
 // @Router /users/{id} [put]
 func (ctrl *UserController) UpdateUser(c *gin.Context) {
-	var user models.User
 	id := c.Param("id")
 
-	if err := c.ShouldBindJSON(&user); err != nil {
+	body, err := c.GetRawData()
+	if err == nil {
+		err = json.NewDecoder(bytes.NewReader(body)).Decode(&UpdateUserRequest{})
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	current, ok := ctrl.userToUpdate(c, id)
+	if !ok || !checkIfMatch(c, current) {
+		return
+	}
+
+	user, err := userFromRepresentation(current, body)
+	if err != nil {
+		if !writeUpdateUserError(c, err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	ctrl.saveUser(c, id, user)
+}
+
+// PatchUser partially updates a user by ID
+// @Summary Patch a user by ID
+// @Description Change some fields of a user with a JSON Merge Patch (RFC 7396, also accepted as application/json) or a JSON Patch (RFC 6902) against the representation returned by GET /users/{id}. Only country and email can change; patching a read-only field such as username is rejected with 422. A failed JSON Patch test operation returns 409. With If-Match, the user is only patched while it still has that ETag; otherwise 412 is returned. Users can update themselves; updating anyone else needs admin permissions.
+// @Tags user
+// @Accept json
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Security BearerAuth
+// @Produce json
+// @Param Authorization header string true "Authorization token"
+// @Param id path int true "User ID"
+// @Param If-Match header string false "ETag the user must still have"
+// @Param patch body object true "Merge patch, or an array of JSON Patch operations"
+// @Success 200 {object} UserResponse
+// @Header 200 {string} ETag "New version of the user"
+// @Failure 400 {object} gin.H
+// @Failure 403 {object} gin.H
+// @Failure 404 {object} gin.H
+// @Failure 409 {object} gin.H
+// @Failure 412 {object} gin.H
+// @Failure 415 {object} gin.H
+// @Failure 422 {object} gin.H
+// @Failure 500 {object} gin.H
+// @Router /users/{id} [patch]
+func (ctrl *UserController) PatchUser(c *gin.Context) {
+	id := c.Param("id")
+
+	var apply func(doc, p []byte) ([]byte, error)
+	switch c.ContentType() {
+	case patch.MergePatchType, "application/json":
+		apply = patch.Merge
+	case patch.JSONPatchType:
+		apply = patch.Apply
+	default:
+		c.Header("Accept-Patch", patch.MergePatchType+", "+patch.JSONPatchType)
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "patches must be " + patch.MergePatchType + " or " + patch.JSONPatchType})
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := ctrl.service.UpdateUser(id, user); err != nil {
+	current, ok := ctrl.userToUpdate(c, id)
+	if !ok || !checkIfMatch(c, current) {
+		return
+	}
+
+	doc, err := json.Marshal(newUserResponse(current))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	patched, err := apply(doc, body)
+	if err != nil {
+		if errors.Is(err, patch.ErrTestFailed) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := userFromRepresentation(current, patched)
+	if err != nil {
+		if !writeUpdateUserError(c, err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	ctrl.saveUser(c, id, user)
+}
+
+// userToUpdate loads the user that GET, PUT, PATCH or DELETE /users/{id}
+// works on and writes the error response when it cannot
+func (ctrl *UserController) userToUpdate(c *gin.Context, id string) (models.User, bool) {
+	user, err := ctrl.service.GetUser(c.Request.Context(), id)
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return user, false
+		}
+		if writeForbidden(c, err) {
+			return user, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return user, false
+	}
+
+	return user, true
+}
+
+// saveUser stores the new state of a user and answers with it
+func (ctrl *UserController) saveUser(c *gin.Context, id string, user models.User) {
+	updated, err := ctrl.service.UpdateUser(c.Request.Context(), id, user)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if writeForbidden(c, err) {
+			return
+		}
+		if writeUpdateUserError(c, err) || writeConflictError(c, err) || writeEmailError(c, err) {
 			return
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": user})
+	c.Header("ETag", userETag(updated))
+	c.JSON(http.StatusOK, gin.H{"data": newUserResponse(updated)})
+}
+
+// writeUpdateUserError writes the response for validation errors of an
+// update and reports whether err was one
+func writeUpdateUserError(c *gin.Context, err error) bool {
+	var readOnlyErr *services.ReadOnlyFieldError
+	if errors.As(err, &readOnlyErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return true
+	}
+	if errors.Is(err, services.ErrCountryRequired) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return true
+	}
+
+	return false
+}
+
+// writeForbidden writes the response for an AuthorizationError and reports
+// whether err was one
+func writeForbidden(c *gin.Context, err error) bool {
+	var authErr *services.AuthorizationError
+	if !errors.As(err, &authErr) {
+		return false
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	return true
 }
 
 // DeleteUser deletes a user by ID
 // @Summary Delete a user by ID
-// @Description Delete a user by their ID
+// @Description Delete a user by their ID. With If-Match, the user is only deleted while it still has that ETag; otherwise 412 is returned. Users can delete themselves; deleting anyone else needs admin permissions. The user's sessions and API keys are revoked.
 // @Tags user
 // @Security BearerAuth
 // @Produce json
 // @Param Authorization header string true "Authorization token"
 // @Param id path int true "User ID"
+// @Param If-Match header string false "ETag the user must still have"
 // @Success 200 {object} gin.H
 // @Failure 400 {object} gin.H
+// @Failure 403 {object} gin.H
 // @Failure 404 {object} gin.H
+// @Failure 412 {object} gin.H
 // @Failure 500 {object} gin.H
 // @Router /users/{id} [delete]
 func (ctrl *UserController) DeleteUser(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := ctrl.service.DeleteUser(id); err != nil {
+	var version uint
+	if c.GetHeader("If-Match") != "" {
+		current, ok := ctrl.userToUpdate(c, id)
+		if !ok || !checkIfMatch(c, current) {
+			return
+		}
+		version = current.Version
+	}
+
+	if err := ctrl.service.DeleteUser(c.Request.Context(), id, version); err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
+		if writeForbidden(c, err) {
+			return
+		}
+		if writeConflictError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -0,0 +1,172 @@
+// controllers/user_dto.go
+package controllers
+
+import (
+	"encoding/json"
+	"go-rest-api/models"
+	"go-rest-api/services"
+	"reflect"
+	"time"
+)
+
+// SignUpRequest is the body of POST /signup
+type SignUpRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Country  string `json:"country"`
+	// Email is optional; a verification link is mailed to it
+	Email string `json:"email"`
+}
+
+// UpdateUserRequest holds the fields of a user that PUT and PATCH
+// /users/{id} can change. Passwords change through PUT /me/password only.
+type UpdateUserRequest struct {
+	Country string `json:"country"`
+	Email   string `json:"email"`
+}
+
+// userMutableFields are the members of UserResponse in UpdateUserRequest;
+// all others are read-only
+var userMutableFields = map[string]bool{
+	"country": true,
+	"email":   true,
+}
+
+// ListUsersRequest holds the query parameters of GET /users
+type ListUsersRequest struct {
+	Country        string     `form:"country"`
+	UsernamePrefix string     `form:"username_prefix"`
+	CreatedAfter   *time.Time `form:"created_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedBefore  *time.Time `form:"created_before" time_format:"2006-01-02T15:04:05Z07:00"`
+	Sort           string     `form:"sort"`
+	Limit          int        `form:"limit"`
+	Offset         int        `form:"offset"`
+	Cursor         string     `form:"cursor"`
+}
+
+// UserResponse is the public view of a user. Handlers never write
+// models.User itself, so hashes and secrets stay in the database.
+type UserResponse struct {
+	ID              uint       `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Country         string     `json:"country"`
+	Role            string     `json:"role"`
+	TOTPEnabled     bool       `json:"totp_enabled"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	// DeletedAt is only set on users listed by GET /deleted-users
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+func (r SignUpRequest) toUser() models.User {
+	return models.User{
+		Username: r.Username,
+		Password: r.Password,
+		Country:  r.Country,
+		Email:    r.Email,
+	}
+}
+
+func (r UpdateUserRequest) toUser() models.User {
+	return models.User{
+		Country: r.Country,
+		Email:   r.Email,
+	}
+}
+
+// UserListResponse is one page of users. NextCursor is omitted on the last
+// page; Total counts the matching users on all pages.
+type UserListResponse struct {
+	Data       []UserResponse `json:"data"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	Total      int64          `json:"total"`
+}
+
+func (r ListUsersRequest) toQuery() models.UserQuery {
+	return models.UserQuery{
+		Country:        r.Country,
+		UsernamePrefix: r.UsernamePrefix,
+		CreatedAfter:   r.CreatedAfter,
+		CreatedBefore:  r.CreatedBefore,
+		Sort:           r.Sort,
+		Limit:          r.Limit,
+		Offset:         r.Offset,
+		Cursor:         r.Cursor,
+	}
+}
+
+func newUserResponse(user models.User) UserResponse {
+	response := UserResponse{
+		ID:              user.ID,
+		Username:        user.Username,
+		Email:           user.Email,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		Country:         user.Country,
+		Role:            user.Role,
+		TOTPEnabled:     user.TOTPEnabled,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+	}
+	if user.DeletedAt.Valid {
+		response.DeletedAt = &user.DeletedAt.Time
+	}
+
+	return response
+}
+
+func newUserResponses(users []models.User) []UserResponse {
+	responses := make([]UserResponse, len(users))
+	for i, user := range users {
+		responses[i] = newUserResponse(user)
+	}
+
+	return responses
+}
+
+func newUserListResponse(page models.UserPage) UserListResponse {
+	return UserListResponse{
+		Data:       newUserResponses(page.Users),
+		NextCursor: page.NextCursor,
+		Total:      page.Total,
+	}
+}
+
+// userFromRepresentation reads a full representation of current, as sent to
+// PUT or produced by applying a PATCH, and returns the user to store in place
+// of current. Read-only fields may be left out, but not changed; omitted
+// mutable fields are cleared.
+func userFromRepresentation(current models.User, body []byte) (models.User, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return models.User{}, err
+	}
+
+	raw, err := json.Marshal(newUserResponse(current))
+	if err != nil {
+		return models.User{}, err
+	}
+	var currentFields map[string]interface{}
+	if err := json.Unmarshal(raw, &currentFields); err != nil {
+		return models.User{}, err
+	}
+
+	for name, value := range fields {
+		if userMutableFields[name] {
+			continue
+		}
+		if was, ok := currentFields[name]; !ok || !reflect.DeepEqual(value, was) {
+			return models.User{}, &services.ReadOnlyFieldError{Field: name}
+		}
+	}
+
+	var req UpdateUserRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return models.User{}, err
+	}
+
+	user := req.toUser()
+	user.Version = current.Version
+	return user, nil
+}
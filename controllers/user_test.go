@@ -3,12 +3,16 @@ package controllers
 
 import (
 	"errors"
+	"fmt"
+	"go-rest-api/auth"
 	"go-rest-api/models"
+	"go-rest-api/services"
 	svcMock "go-rest-api/services/mocks"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/mock/gomock"
@@ -16,6 +20,11 @@ import (
 	"gorm.io/gorm"
 )
 
+// testIntrospectionClients may call /oauth/introspect in setupTest
+var testIntrospectionClients = auth.NewClients(map[string]string{"gateway": "s3cret"})
+
+// setupTest serves every UserController handler on its route, backed by a
+// mock service
 func setupTest() (*gin.Engine, *svcMock.MockUserService, *gomock.Controller) {
 	ctrl := gomock.NewController(nil)
 	mockUserService := svcMock.NewMockUserService(ctrl)
@@ -24,10 +33,45 @@ func setupTest() (*gin.Engine, *svcMock.MockUserService, *gomock.Controller) {
 	router := gin.Default()
 	router.POST("/signup", userController.SignUp)
 	router.POST("/login", userController.Login)
+	router.POST("/token/refresh", userController.RefreshToken)
 	router.GET("/users", userController.GetUsers)
 	router.GET("/users/:id", userController.GetUser)
 	router.PUT("/users/:id", userController.UpdateUser)
+	router.PATCH("/users/:id", userController.PatchUser)
 	router.DELETE("/users/:id", userController.DeleteUser)
+	router.DELETE("/users/:id/sessions", userController.RevokeSessions)
+	router.DELETE("/users/:id/lockout", userController.UnlockUser)
+	router.POST("/logout", func(c *gin.Context) {
+		c.Set("claims", &auth.Claims{Username: "testuser"})
+	}, userController.Logout)
+
+	router.POST("/password/forgot", userController.ForgotPassword)
+	router.POST("/password/reset", userController.ResetPassword)
+	router.POST("/verify-email", userController.VerifyEmail)
+	router.POST("/verify-email/resend", userController.ResendVerification)
+	router.POST("/login/mfa", userController.LoginMFA)
+	router.POST("/oauth/introspect", RequireClient(testIntrospectionClients), userController.IntrospectToken)
+
+	router.GET("/me", userController.GetMe)
+	router.PATCH("/me", userController.UpdateMe)
+	router.DELETE("/me", userController.DeleteMe)
+	router.PUT("/me/password", userController.ChangeMyPassword)
+	router.POST("/me/mfa/totp", userController.EnrollTOTP)
+	router.POST("/me/mfa/totp/confirm", userController.ConfirmTOTP)
+	router.DELETE("/me/mfa/totp", userController.DisableTOTP)
+	router.POST("/me/api-keys", userController.CreateAPIKey)
+	router.GET("/me/api-keys", userController.ListAPIKeys)
+	router.DELETE("/me/api-keys/:id", userController.RevokeAPIKey)
+
+	router.GET("/auth/:provider/login", userController.OIDCLogin)
+	router.GET("/auth/:provider/callback", userController.OIDCCallback)
+	router.POST("/me/identities/:provider", userController.LinkIdentity)
+	router.GET("/me/identities", userController.ListIdentities)
+	router.DELETE("/me/identities/:id", userController.UnlinkIdentity)
+
+	router.GET("/deleted-users", userController.GetDeletedUsers)
+	router.POST("/deleted-users/:id/restore", userController.RestoreUser)
+	router.DELETE("/deleted-users/:id", userController.PurgeUser)
 
 	return router, mockUserService, ctrl
 }
@@ -42,7 +86,8 @@ func TestSignUp(t *testing.T) {
 		Country:  "usa",
 	}
 
-	mockUserService.EXPECT().SignUp(user).Return(nil)
+	stored := models.User{Model: gorm.Model{ID: 1}, Username: "testuser", Password: "$2a$10$hash", Country: "usa", Role: "user"}
+	mockUserService.EXPECT().SignUp(user).Return(stored, nil)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(`{"username":"testuser","password":"password", "country":"usa"}`))
@@ -50,7 +95,10 @@ func TestSignUp(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":1`)
 	assert.Contains(t, w.Body.String(), "testuser")
+	assert.NotContains(t, w.Body.String(), "password")
+	assert.NotContains(t, w.Body.String(), "$2a$10$hash")
 }
 
 func TestSignUp_BadRequest(t *testing.T) {
@@ -75,7 +123,7 @@ func TestSignUp_Fail_NoPassword(t *testing.T) {
 		Country:  "usa",
 	}
 
-	mockUserService.EXPECT().SignUp(user).Return(nil).Times(0)
+	mockUserService.EXPECT().SignUp(user).Times(0)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(`{"username":"testuser","country":"usa"}`))
@@ -95,7 +143,7 @@ func TestSignUp_Fail_NoCountry(t *testing.T) {
 		Password: "asopa#010",
 	}
 
-	mockUserService.EXPECT().SignUp(user).Return(nil).Times(0)
+	mockUserService.EXPECT().SignUp(user).Times(0)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(`{"username":"testuser","password":"asopa#010"}`))
@@ -116,7 +164,7 @@ func TestSignUp_Fail_DBErr(t *testing.T) {
 		Country:  "usa",
 	}
 
-	mockUserService.EXPECT().SignUp(user).Return(errors.New("failed to fetch record"))
+	mockUserService.EXPECT().SignUp(user).Return(models.User{}, errors.New("failed to fetch record"))
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(`{"username":"testuser","password":"password", "country":"usa"}`))
@@ -127,6 +175,93 @@ func TestSignUp_Fail_DBErr(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "failed to fetch record")
 }
 
+func TestSignUp_Fail_EmailTaken(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	user := models.User{
+		Username: "testuser",
+		Password: "password",
+		Country:  "usa",
+		Email:    "test@example.com",
+	}
+
+	mockUserService.EXPECT().SignUp(user).Return(models.User{}, services.ErrEmailTaken)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(`{"username":"testuser","password":"password","country":"usa","email":"test@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestSignUp_Fail_UsernameTaken(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	user := models.User{
+		Username: "testuser",
+		Password: "password",
+		Country:  "usa",
+	}
+
+	mockUserService.EXPECT().SignUp(user).Return(models.User{}, services.ErrUsernameTaken)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(`{"username":"testuser","password":"password","country":"usa"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestSignUp_Fail_InvalidEmail(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	user := models.User{
+		Username: "testuser",
+		Password: "password",
+		Country:  "usa",
+		Email:    "nope",
+	}
+
+	mockUserService.EXPECT().SignUp(user).Return(models.User{}, services.ErrInvalidEmail)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(`{"username":"testuser","password":"password","country":"usa","email":"nope"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid email address")
+}
+
+func TestSignUp_Fail_PasswordPolicy(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	user := models.User{
+		Username: "testuser",
+		Password: "short",
+		Country:  "usa",
+	}
+
+	policyErr := &auth.PasswordPolicyError{Violations: []auth.PasswordViolation{
+		{Code: auth.ViolationTooShort, Message: "must be at least 8 characters long"},
+	}}
+	mockUserService.EXPECT().SignUp(user).Return(models.User{}, policyErr)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(`{"username":"testuser","password":"short","country":"usa"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"violations":[{"code":"too_short"`)
+}
+
 func TestLogin(t *testing.T) {
 	router, mockUserService, ctrl := setupTest()
 	defer ctrl.Finish()
@@ -135,7 +270,7 @@ func TestLogin(t *testing.T) {
 	password := "password"
 	token := "mocked-jwt-token"
 
-	mockUserService.EXPECT().Login(username, password).Return(token, nil)
+	mockUserService.EXPECT().Login(username, password, gomock.Any()).Return(models.TokenPair{AccessToken: token, RefreshToken: "mocked-refresh-token"}, nil)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"username":"testuser","password":"password"}`))
@@ -144,6 +279,79 @@ func TestLogin(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), token)
+	assert.Contains(t, w.Body.String(), "mocked-refresh-token")
+}
+
+func TestLogin_SessionCookie(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockUserService := svcMock.NewMockUserService(ctrl)
+	userController := NewUserController(mockUserService)
+	userController.UseSessionCookie(DefaultCookieToken)
+
+	router := gin.New()
+	router.POST("/login", userController.Login)
+	router.POST("/logout", func(c *gin.Context) {
+		c.Set("claims", &auth.Claims{Username: "testuser"})
+	}, userController.Logout)
+
+	mockUserService.EXPECT().Login("testuser", "password", gomock.Any()).Return(models.TokenPair{AccessToken: "mocked-jwt-token", RefreshToken: "mocked-refresh-token", ExpiresIn: 300}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"username":"testuser","password":"password"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	cookies := w.Result().Cookies()
+	if assert.Len(t, cookies, 2) {
+		assert.Equal(t, "access_token", cookies[0].Name)
+		assert.Equal(t, "mocked-jwt-token", cookies[0].Value)
+		assert.True(t, cookies[0].HttpOnly)
+		assert.Equal(t, 300, cookies[0].MaxAge)
+		assert.Equal(t, "csrf_token", cookies[1].Name)
+		assert.NotEmpty(t, cookies[1].Value)
+		assert.False(t, cookies[1].HttpOnly)
+		// Secure even though this request came over plain HTTP, as it does
+		// behind a TLS terminating proxy
+		assert.True(t, cookies[0].Secure)
+		assert.True(t, cookies[1].Secure)
+	}
+
+	mockUserService.EXPECT().Logout(gomock.Any(), "").Return(nil)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/logout", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	for _, cookie := range w.Result().Cookies() {
+		assert.Empty(t, cookie.Value)
+		assert.Negative(t, cookie.MaxAge)
+	}
+}
+
+func TestLogin_InsecureSessionCookie(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockUserService := svcMock.NewMockUserService(ctrl)
+	userController := NewUserController(mockUserService)
+	cookie := DefaultCookieToken
+	cookie.Insecure = true
+	userController.UseSessionCookie(cookie)
+
+	router := gin.New()
+	router.POST("/login", userController.Login)
+
+	mockUserService.EXPECT().Login("testuser", "password", gomock.Any()).Return(models.TokenPair{AccessToken: "mocked-jwt-token", ExpiresIn: 300}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"username":"testuser","password":"password"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	for _, cookie := range w.Result().Cookies() {
+		assert.False(t, cookie.Secure, cookie.Name)
+	}
 }
 
 func TestLoginInvalidRequest(t *testing.T) {
@@ -166,7 +374,7 @@ func TestLoginErr(t *testing.T) {
 	username := "testuser"
 	password := "invalid"
 
-	mockUserService.EXPECT().Login(username, password).Return("", errors.New("invalid credential"))
+	mockUserService.EXPECT().Login(username, password, gomock.Any()).Return(models.TokenPair{}, services.ErrInvalidCredentials)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"username":"testuser","password":"invalid"}`))
@@ -174,36 +382,246 @@ func TestLoginErr(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
-	assert.Contains(t, w.Body.String(), "invalid credential")
+	assert.Equal(t, `{"error":"Invalid credentials"}`, w.Body.String())
+}
+
+func TestLogin_ServiceError(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().Login("testuser", "password", gomock.Any()).Return(models.TokenPair{}, errors.New("database unavailable"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"username":"testuser","password":"password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestLogin_EmailNotVerified(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().Login("test@example.com", "password", gomock.Any()).Return(models.TokenPair{}, services.ErrEmailNotVerified)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"username":"test@example.com","password":"password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "has not been verified")
+}
+
+func TestRefreshToken(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().RefreshToken("old-refresh-token").Return(models.TokenPair{AccessToken: "new-jwt-token", RefreshToken: "new-refresh-token"}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/token/refresh", strings.NewReader(`{"refresh_token":"old-refresh-token"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "new-jwt-token")
+	assert.Contains(t, w.Body.String(), "new-refresh-token")
+}
+
+func TestRefreshTokenInvalidRequest(t *testing.T) {
+	router, _, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/token/refresh", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid request")
+}
+
+func TestRefreshToken_Reused(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().RefreshToken("rotated-refresh-token").Return(models.TokenPair{}, services.ErrRefreshTokenReused)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/token/refresh", strings.NewReader(`{"refresh_token":"rotated-refresh-token"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), services.ErrRefreshTokenReused.Error())
+}
+
+func TestLogout(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().Logout(&auth.Claims{Username: "testuser"}, "refresh-token").Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/logout", strings.NewReader(`{"refresh_token":"refresh-token"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Logged out")
+}
+
+func TestLogout_NoBody(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().Logout(&auth.Claims{Username: "testuser"}, "").Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/logout", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Logged out")
+}
+
+func TestLogout_Fail_DBErr(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().Logout(gomock.Any(), "").Return(errors.New("failed to revoke"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/logout", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "failed to revoke")
+}
+
+func TestLogout_APIKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockUserService := svcMock.NewMockUserService(ctrl)
+	userController := NewUserController(mockUserService)
+
+	// API keys authenticate without claims; there is no session to end
+	router := gin.New()
+	router.POST("/logout", userController.Logout)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/logout", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "/me/api-keys")
+}
+
+func TestRevokeSessions(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().RevokeAllSessions("1").Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/users/1/sessions", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Sessions revoked")
+}
+
+func TestRevokeSessionsNotFound(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().RevokeAllSessions("1").Return(gorm.ErrRecordNotFound)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/users/1/sessions", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "User not found")
 }
 
 func TestGetUsers(t *testing.T) {
 	router, mockUserService, ctrl := setupTest()
 	defer ctrl.Finish()
 
-	users := []models.User{
-		{Username: "user1", Password: "password1"},
-		{Username: "user2", Password: "password2"},
+	page := models.UserPage{
+		Users: []models.User{
+			{Username: "user1", Password: "password1"},
+			{Username: "user2", Password: "password2"},
+		},
+		NextCursor: "next-page",
+		Total:      5,
 	}
 
-	mockUserService.EXPECT().GetUsers().Return(users, nil)
+	mockUserService.EXPECT().GetUsers(models.UserQuery{Limit: 2}).Return(page, nil)
 
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("GET", "/users", nil)
+	req, _ := http.NewRequest("GET", "/users?limit=2", nil)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "user1")
 	assert.Contains(t, w.Body.String(), "user2")
+	assert.Contains(t, w.Body.String(), `"next_cursor":"next-page"`)
+	assert.Contains(t, w.Body.String(), `"total":5`)
+	assert.NotContains(t, w.Body.String(), "password")
 }
 
-func TestGetUsers_DBErr(t *testing.T) {
+func TestGetUsers_Query(t *testing.T) {
 	router, mockUserService, ctrl := setupTest()
 	defer ctrl.Finish()
 
-	users := []models.User{}
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	query := models.UserQuery{
+		Country:        "usa",
+		UsernamePrefix: "rr",
+		CreatedAfter:   &after,
+		Sort:           "-created_at",
+		Limit:          10,
+		Cursor:         "abc",
+	}
+	mockUserService.EXPECT().GetUsers(query).Return(models.UserPage{Total: 0}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users?country=usa&username_prefix=rr&created_after=2024-01-01T00:00:00Z&sort=-created_at&limit=10&cursor=abc", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"data":[],"total":0}`, w.Body.String())
+}
+
+func TestGetUsers_InvalidQuery(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().GetUsers(models.UserQuery{Sort: "password"}).Return(models.UserPage{}, fmt.Errorf("%w: cannot sort by %q", services.ErrInvalidUserQuery, "password"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users?sort=password", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "cannot sort by")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/users?created_after=yesterday", nil)
+	router.ServeHTTP(w, req)
 
-	mockUserService.EXPECT().GetUsers().Return(users, errors.New("failed to query"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetUsers_DBErr(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().GetUsers(models.UserQuery{}).Return(models.UserPage{}, errors.New("failed to query"))
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/users", nil)
@@ -219,7 +637,7 @@ func TestGetUser(t *testing.T) {
 
 	user := models.User{Username: "testuser", Password: "password"}
 
-	mockUserService.EXPECT().GetUser("1").Return(user, nil)
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(user, nil)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/users/1", nil)
@@ -233,7 +651,7 @@ func TestGetUserNotFound(t *testing.T) {
 	router, mockUserService, ctrl := setupTest()
 	defer ctrl.Finish()
 
-	mockUserService.EXPECT().GetUser("1").Return(models.User{}, gorm.ErrRecordNotFound)
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(models.User{}, gorm.ErrRecordNotFound)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/users/1", nil)
@@ -243,21 +661,79 @@ func TestGetUserNotFound(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "User not found")
 }
 
+func TestGetUserForbidden(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(models.User{}, &services.AuthorizationError{Action: "view user", Reason: "user belongs to someone else"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/users/1", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NotContains(t, w.Body.String(), "username")
+}
+
 func TestUpdateUser(t *testing.T) {
 	router, mockUserService, ctrl := setupTest()
 	defer ctrl.Finish()
 
-	user := models.User{Username: "updateduser", Password: "newpassword"}
+	current := models.User{Model: gorm.Model{ID: 1}, Username: "updateduser", Password: "$2a$10$hash", Country: "india"}
+	user := models.User{Country: "usa", Email: "new@example.com"}
+	stored := models.User{Model: gorm.Model{ID: 1}, Username: "updateduser", Password: "$2a$10$hash", Country: "usa", Email: "new@example.com"}
 
-	mockUserService.EXPECT().UpdateUser("1", user).Return(nil)
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(current, nil)
+	mockUserService.EXPECT().UpdateUser(gomock.Any(), "1", user).Return(stored, nil)
 
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(`{"username":"updateduser","password":"newpassword"}`))
+	req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(`{"id":1,"username":"updateduser","country":"usa","email":"new@example.com"}`))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "updateduser")
+	assert.NotContains(t, w.Body.String(), "$2a$10$hash")
+}
+
+func TestUpdateUser_ClearsOmittedFields(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	current := models.User{Model: gorm.Model{ID: 1}, Username: "testuser", Country: "india", Email: "old@example.com"}
+
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(current, nil)
+	mockUserService.EXPECT().UpdateUser(gomock.Any(), "1", models.User{Country: "usa"}).Return(models.User{Country: "usa"}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(`{"country":"usa"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestUpdateUser_ReadOnlyField(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	current := models.User{Model: gorm.Model{ID: 1}, Username: "testuser", Country: "india", Role: "user"}
+
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(current, nil).Times(3)
+
+	for _, body := range []string{
+		`{"username":"renamed","country":"usa"}`,
+		`{"role":"admin","country":"usa"}`,
+		`{"password":"secret","country":"usa"}`,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, body)
+		assert.Contains(t, w.Body.String(), "cannot be changed", body)
+	}
 }
 
 func TestUpdateUserInvalidRequest(t *testing.T) {
@@ -277,12 +753,10 @@ func TestUpdateUserNoRecordFound(t *testing.T) {
 	router, mockUserService, ctrl := setupTest()
 	defer ctrl.Finish()
 
-	user := models.User{Username: "updateduser", Password: "newpassword"}
-
-	mockUserService.EXPECT().UpdateUser("1", user).Return(gorm.ErrRecordNotFound)
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(models.User{}, gorm.ErrRecordNotFound)
 
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(`{"username":"updateduser","password":"newpassword"}`))
+	req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(`{"country":"usa","email":"new@example.com"}`))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
@@ -294,12 +768,13 @@ func TestUpdateUser_Fail_DBErr(t *testing.T) {
 	router, mockUserService, ctrl := setupTest()
 	defer ctrl.Finish()
 
-	user := models.User{Username: "updateduser", Password: "newpassword"}
+	user := models.User{Country: "usa", Email: "new@example.com"}
 
-	mockUserService.EXPECT().UpdateUser("1", user).Return(errors.New("failed to update"))
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(models.User{Username: "updateduser"}, nil)
+	mockUserService.EXPECT().UpdateUser(gomock.Any(), "1", user).Return(models.User{}, errors.New("failed to update"))
 
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(`{"username":"updateduser","password":"newpassword"}`))
+	req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(`{"country":"usa","email":"new@example.com"}`))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
@@ -307,11 +782,200 @@ func TestUpdateUser_Fail_DBErr(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "failed to update")
 }
 
+func TestUpdateUser_Forbidden(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	user := models.User{Country: "usa", Email: "new@example.com"}
+
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(models.User{Username: "updateduser"}, nil)
+	mockUserService.EXPECT().UpdateUser(gomock.Any(), "1", user).Return(models.User{}, &services.AuthorizationError{Action: "update user", Reason: "user belongs to someone else"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(`{"country":"usa","email":"new@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "not allowed to update user")
+}
+
+func TestUpdateUser_EmailTaken(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	user := models.User{Country: "usa", Email: "taken@example.com"}
+
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(models.User{Username: "updateduser"}, nil)
+	mockUserService.EXPECT().UpdateUser(gomock.Any(), "1", user).Return(models.User{}, services.ErrEmailTaken)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(`{"country":"usa","email":"taken@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestUpdateUser_CountryRequired(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(models.User{Username: "updateduser", Country: "usa"}, nil)
+	mockUserService.EXPECT().UpdateUser(gomock.Any(), "1", models.User{Email: "new@example.com"}).Return(models.User{}, services.ErrCountryRequired)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(`{"email":"new@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "country is required")
+}
+
+func TestPatchUser(t *testing.T) {
+	current := models.User{Model: gorm.Model{ID: 1}, Username: "testuser", Country: "india", Email: "old@example.com", Role: "user"}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        models.User
+	}{
+		{
+			name:        "merge patch",
+			contentType: "application/merge-patch+json",
+			body:        `{"country":"usa"}`,
+			want:        models.User{Country: "usa", Email: "old@example.com"},
+		},
+		{
+			name:        "merge patch removing the email",
+			contentType: "application/merge-patch+json",
+			body:        `{"email":null}`,
+			want:        models.User{Country: "india"},
+		},
+		{
+			name:        "plain JSON as merge patch",
+			contentType: "application/json",
+			body:        `{"email":"new@example.com"}`,
+			want:        models.User{Country: "india", Email: "new@example.com"},
+		},
+		{
+			name:        "JSON Patch",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"test","path":"/username","value":"testuser"},{"op":"replace","path":"/country","value":"usa"},{"op":"remove","path":"/email"}]`,
+			want:        models.User{Country: "usa"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, mockUserService, ctrl := setupTest()
+			defer ctrl.Finish()
+
+			stored := current
+			stored.Country, stored.Email = tt.want.Country, tt.want.Email
+			mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(current, nil)
+			mockUserService.EXPECT().UpdateUser(gomock.Any(), "1", tt.want).Return(stored, nil)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PATCH", "/users/1", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), `"username":"testuser"`)
+		})
+	}
+}
+
+func TestPatchUser_Rejected(t *testing.T) {
+	current := models.User{Model: gorm.Model{ID: 1}, Username: "testuser", Country: "india", Role: "user"}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantCode    int
+		wantBody    string
+	}{
+		{
+			name:        "username is read-only",
+			contentType: "application/merge-patch+json",
+			body:        `{"username":"renamed"}`,
+			wantCode:    http.StatusUnprocessableEntity,
+			wantBody:    `field \"username\" cannot be changed`,
+		},
+		{
+			name:        "role is read-only",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"replace","path":"/role","value":"admin"}]`,
+			wantCode:    http.StatusUnprocessableEntity,
+			wantBody:    `field \"role\" cannot be changed`,
+		},
+		{
+			name:        "unknown fields are read-only",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"add","path":"/password","value":"secret"}]`,
+			wantCode:    http.StatusUnprocessableEntity,
+			wantBody:    `field \"password\" cannot be changed`,
+		},
+		{
+			name:        "failed test operation",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"test","path":"/country","value":"usa"},{"op":"replace","path":"/country","value":"uae"}]`,
+			wantCode:    http.StatusConflict,
+			wantBody:    "patch test failed",
+		},
+		{
+			name:        "missing path",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"remove","path":"/nickname"}]`,
+			wantCode:    http.StatusBadRequest,
+			wantBody:    "does not exist",
+		},
+		{
+			name:        "mistyped value",
+			contentType: "application/merge-patch+json",
+			body:        `{"country":5}`,
+			wantCode:    http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, mockUserService, ctrl := setupTest()
+			defer ctrl.Finish()
+
+			mockUserService.EXPECT().GetUser(gomock.Any(), "1").Return(current, nil)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PATCH", "/users/1", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Contains(t, w.Body.String(), tt.wantBody)
+		})
+	}
+}
+
+func TestPatchUser_UnsupportedMediaType(t *testing.T) {
+	router, _, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PATCH", "/users/1", strings.NewReader(`country=usa`))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Equal(t, "application/merge-patch+json, application/json-patch+json", w.Header().Get("Accept-Patch"))
+}
+
 func TestDeleteUser(t *testing.T) {
 	router, mockUserService, ctrl := setupTest()
 	defer ctrl.Finish()
 
-	mockUserService.EXPECT().DeleteUser("1").Return(nil)
+	mockUserService.EXPECT().DeleteUser(gomock.Any(), "1", uint(0)).Return(nil)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("DELETE", "/users/1", nil)
@@ -325,7 +989,7 @@ func TestDeleteUser_Fail_DBErr(t *testing.T) {
 	router, mockUserService, ctrl := setupTest()
 	defer ctrl.Finish()
 
-	mockUserService.EXPECT().DeleteUser("1").Return(errors.New("failed to delete"))
+	mockUserService.EXPECT().DeleteUser(gomock.Any(), "1", uint(0)).Return(errors.New("failed to delete"))
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("DELETE", "/users/1", nil)
@@ -334,3 +998,17 @@ func TestDeleteUser_Fail_DBErr(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 	assert.Contains(t, w.Body.String(), "failed to delete")
 }
+
+func TestDeleteUser_Forbidden(t *testing.T) {
+	router, mockUserService, ctrl := setupTest()
+	defer ctrl.Finish()
+
+	mockUserService.EXPECT().DeleteUser(gomock.Any(), "1", uint(0)).Return(&services.AuthorizationError{Action: "delete user", Reason: "user belongs to someone else"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/users/1", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "not allowed to delete user")
+}
@@ -0,0 +1,72 @@
+// controllers/wellknown.go
+package controllers
+
+import (
+	"go-rest-api/auth"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenIDConfiguration is the discovery document served at
+// /.well-known/openid-configuration
+type OpenIDConfiguration struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported                  []string `json:"claims_supported"`
+}
+
+type WellKnownController struct {
+	keys   *auth.KeySet
+	issuer string
+}
+
+// NewWellKnownController serves discovery documents for the key set. The
+// discovery document is only served when issuer is set: deriving it from the
+// Host header of a request would let clients point cached copies at keys of
+// their choosing.
+func NewWellKnownController(keys *auth.KeySet, issuer string) *WellKnownController {
+	return &WellKnownController{keys: keys, issuer: strings.TrimSuffix(issuer, "/")}
+}
+
+// JWKS returns the public signing keys
+// @Summary JSON Web Key Set
+// @Description Public keys that verify tokens issued by this service. Shared HMAC keys are never published.
+// @Tags discovery
+// @Produce json
+// @Success 200 {object} auth.JWKS
+// @Router /.well-known/jwks.json [get]
+func (ctrl *WellKnownController) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, ctrl.keys.JWKS())
+}
+
+// OpenIDConfiguration returns the discovery document
+// @Summary OpenID provider configuration
+// @Description Minimal OpenID Connect discovery document generated from the active key set. Only served when JWT_ISSUER is set.
+// @Tags discovery
+// @Produce json
+// @Success 200 {object} OpenIDConfiguration
+// @Failure 404 {object} gin.H
+// @Router /.well-known/openid-configuration [get]
+func (ctrl *WellKnownController) OpenIDConfiguration(c *gin.Context) {
+	issuer := ctrl.issuer
+	if issuer == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Discovery is not configured, set JWT_ISSUER"})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, OpenIDConfiguration{
+		Issuer:                           issuer,
+		JWKSURI:                          issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:           []string{"token"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: ctrl.keys.SigningAlgorithms(),
+		ClaimsSupported:                  []string{"sub", "iss", "aud", "username", "role", "ver", "jti", "iat", "nbf", "exp"},
+	})
+}
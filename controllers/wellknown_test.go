@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"go-rest-api/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupWellKnown(issuer string) *gin.Engine {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	keys, _ := auth.NewKeySet("ed", auth.NewEdDSAKey("ed", edKey), auth.NewHMACKey("hs", []byte("secret_key")))
+	wellKnownController := NewWellKnownController(keys, issuer)
+
+	router := gin.Default()
+	router.GET("/.well-known/jwks.json", wellKnownController.JWKS)
+	router.GET("/.well-known/openid-configuration", wellKnownController.OpenIDConfiguration)
+	return router
+}
+
+func TestJWKS(t *testing.T) {
+	router := setupWellKnown("")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var jwks auth.JWKS
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &jwks))
+	assert.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "ed", jwks.Keys[0].KeyID)
+	assert.NotContains(t, w.Body.String(), "secret_key")
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	router := setupWellKnown("https://auth.example.com/")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/.well-known/openid-configuration", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var config OpenIDConfiguration
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &config))
+	assert.Equal(t, "https://auth.example.com", config.Issuer)
+	assert.Equal(t, "https://auth.example.com/.well-known/jwks.json", config.JWKSURI)
+	assert.Equal(t, []string{"EdDSA"}, config.IDTokenSigningAlgValuesSupported)
+	assert.Subset(t, config.ClaimsSupported, []string{"sub", "iss", "aud", "nbf"})
+	assert.NotContains(t, w.Body.String(), "token_endpoint")
+}
+
+func TestOpenIDConfiguration_NoIssuer(t *testing.T) {
+	router := setupWellKnown("")
+
+	// The issuer is never taken from the request, which shared caches would
+	// then serve to everyone
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/.well-known/openid-configuration", nil)
+	req.Host = "attacker.example"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NotContains(t, w.Body.String(), "attacker.example")
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+}
@@ -7,6 +7,7 @@ import (
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // var DB Database
@@ -17,9 +18,23 @@ type Database interface {
 	First(out interface{}, where ...interface{}) *gorm.DB
 	Find(out interface{}, where ...interface{}) *gorm.DB
 	Save(value interface{}) *gorm.DB
+	// UpdateWhere updates the columns in values of the row of model, only
+	// while the condition holds; RowsAffected is 0 otherwise
+	UpdateWhere(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB
+	// Upsert inserts value, or applies the assignments of onConflict to the
+	// row it conflicts with, and reads the stored row back into value
+	Upsert(value interface{}, onConflict clause.OnConflict) *gorm.DB
 	Delete(value interface{}, where ...interface{}) *gorm.DB
 	Distinct(args ...interface{}) *gorm.DB
 	Pluck(column string, dest interface{}) *gorm.DB
+	// Count stores the number of rows of model that match where in count
+	Count(model interface{}, count *int64, where ...interface{}) *gorm.DB
+	// FindPage is Find with ORDER BY order, at most limit rows and the first
+	// offset rows skipped
+	FindPage(out interface{}, order string, limit, offset int, where ...interface{}) *gorm.DB
+	// Unscoped returns a Database whose queries include soft deleted rows and
+	// whose Delete removes rows for good
+	Unscoped() Database
 }
 
 type GormDatabase struct {
@@ -46,6 +61,14 @@ func (g *GormDatabase) Save(value interface{}) *gorm.DB {
 	return g.DB.Save(value)
 }
 
+func (g *GormDatabase) UpdateWhere(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+	return g.DB.Model(model).Where(query, args...).Updates(values)
+}
+
+func (g *GormDatabase) Upsert(value interface{}, onConflict clause.OnConflict) *gorm.DB {
+	return g.DB.Clauses(onConflict, clause.Returning{}).Create(value)
+}
+
 func (g *GormDatabase) Delete(value interface{}, where ...interface{}) *gorm.DB {
 	return g.DB.Delete(value, where...)
 }
@@ -58,6 +81,22 @@ func (g *GormDatabase) Pluck(col string, dest interface{}) *gorm.DB {
 	return g.DB.Pluck(col, dest)
 }
 
+func (g *GormDatabase) Count(model interface{}, count *int64, where ...interface{}) *gorm.DB {
+	db := g.DB.Model(model)
+	if len(where) > 0 {
+		db = db.Where(where[0], where[1:]...)
+	}
+	return db.Count(count)
+}
+
+func (g *GormDatabase) FindPage(out interface{}, order string, limit, offset int, where ...interface{}) *gorm.DB {
+	return g.DB.Order(order).Limit(limit).Offset(offset).Find(out, where...)
+}
+
+func (g *GormDatabase) Unscoped() Database {
+	return &GormDatabase{DB: g.DB.Unscoped()}
+}
+
 func InitDatabase() (*GormDatabase, error) {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
 		os.Getenv("DB_HOST"),
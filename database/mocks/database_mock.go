@@ -5,10 +5,12 @@
 package database
 
 import (
+	database "go-rest-api/database"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
 	gorm "gorm.io/gorm"
+	clause "gorm.io/gorm/clause"
 )
 
 // MockDatabase is a mock of Database interface.
@@ -34,6 +36,25 @@ func (m *MockDatabase) EXPECT() *MockDatabaseMockRecorder {
 	return m.recorder
 }
 
+// Count mocks base method.
+func (m *MockDatabase) Count(model interface{}, count *int64, where ...interface{}) *gorm.DB {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{model, count}
+	for _, a := range where {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Count", varargs...)
+	ret0, _ := ret[0].(*gorm.DB)
+	return ret0
+}
+
+// Count indicates an expected call of Count.
+func (mr *MockDatabaseMockRecorder) Count(model, count interface{}, where ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{model, count}, where...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Count", reflect.TypeOf((*MockDatabase)(nil).Count), varargs...)
+}
+
 // Create mocks base method.
 func (m *MockDatabase) Create(value interface{}) *gorm.DB {
 	m.ctrl.T.Helper()
@@ -104,6 +125,25 @@ func (mr *MockDatabaseMockRecorder) Find(out interface{}, where ...interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Find", reflect.TypeOf((*MockDatabase)(nil).Find), varargs...)
 }
 
+// FindPage mocks base method.
+func (m *MockDatabase) FindPage(out interface{}, order string, limit, offset int, where ...interface{}) *gorm.DB {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{out, order, limit, offset}
+	for _, a := range where {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "FindPage", varargs...)
+	ret0, _ := ret[0].(*gorm.DB)
+	return ret0
+}
+
+// FindPage indicates an expected call of FindPage.
+func (mr *MockDatabaseMockRecorder) FindPage(out, order, limit, offset interface{}, where ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{out, order, limit, offset}, where...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindPage", reflect.TypeOf((*MockDatabase)(nil).FindPage), varargs...)
+}
+
 // First mocks base method.
 func (m *MockDatabase) First(out interface{}, where ...interface{}) *gorm.DB {
 	m.ctrl.T.Helper()
@@ -151,6 +191,53 @@ func (mr *MockDatabaseMockRecorder) Save(value interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockDatabase)(nil).Save), value)
 }
 
+// Unscoped mocks base method.
+func (m *MockDatabase) Unscoped() database.Database {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Unscoped")
+	ret0, _ := ret[0].(database.Database)
+	return ret0
+}
+
+// Unscoped indicates an expected call of Unscoped.
+func (mr *MockDatabaseMockRecorder) Unscoped() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unscoped", reflect.TypeOf((*MockDatabase)(nil).Unscoped))
+}
+
+// UpdateWhere mocks base method.
+func (m *MockDatabase) UpdateWhere(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{model, values, query}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "UpdateWhere", varargs...)
+	ret0, _ := ret[0].(*gorm.DB)
+	return ret0
+}
+
+// UpdateWhere indicates an expected call of UpdateWhere.
+func (mr *MockDatabaseMockRecorder) UpdateWhere(model, values, query interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{model, values, query}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateWhere", reflect.TypeOf((*MockDatabase)(nil).UpdateWhere), varargs...)
+}
+
+// Upsert mocks base method.
+func (m *MockDatabase) Upsert(value interface{}, onConflict clause.OnConflict) *gorm.DB {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Upsert", value, onConflict)
+	ret0, _ := ret[0].(*gorm.DB)
+	return ret0
+}
+
+// Upsert indicates an expected call of Upsert.
+func (mr *MockDatabaseMockRecorder) Upsert(value, onConflict interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Upsert", reflect.TypeOf((*MockDatabase)(nil).Upsert), value, onConflict)
+}
+
 // Where mocks base method.
 func (m *MockDatabase) Where(query interface{}, args ...interface{}) *gorm.DB {
 	m.ctrl.T.Helper()
@@ -0,0 +1,45 @@
+// mail/log.go
+package mail
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogMailer writes messages instead of delivering them. It is meant for local
+// development and tests.
+type LogMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLogMailer writes messages to w, or to the standard logger when w is nil
+func NewLogMailer(w io.Writer) *LogMailer {
+	return &LogMailer{w: w}
+}
+
+// NewFileMailer appends messages to the file at path
+func NewFileMailer(path string) (*LogMailer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogMailer(f), nil
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	entry := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", msg.To, msg.Subject, msg.Body)
+	if m.w == nil {
+		log.Printf("Mail not sent (log mailer):\n%s", entry)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.w, "--- %s\n%s", time.Now().Format(time.RFC3339), entry)
+	return err
+}
@@ -0,0 +1,45 @@
+// mail/mailer.go
+package mail
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages to users
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailerFromEnv picks the mailer configured by MAIL_DRIVER. "smtp" sends
+// through SMTP_HOST; anything else writes messages to MAIL_LOG_FILE, or to the
+// application log when no file is set, so local runs need no mail server.
+func NewMailerFromEnv() (Mailer, error) {
+	switch os.Getenv("MAIL_DRIVER") {
+	case "smtp":
+		port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}, nil
+	default:
+		if path := os.Getenv("MAIL_LOG_FILE"); path != "" {
+			return NewFileMailer(path)
+		}
+		return NewLogMailer(nil), nil
+	}
+}
@@ -0,0 +1,129 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf)
+
+	if err := m.Send(Message{To: "rrm@example.com", Subject: "Hello", Body: "token"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	for _, want := range []string{"To: rrm@example.com", "Subject: Hello", "token"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output %q does not contain %q", buf.String(), want)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m, err := NewFileMailer(path)
+	if err != nil {
+		t.Fatalf("NewFileMailer() error = %v", err)
+	}
+
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := m.Send(Message{To: to, Subject: "Hello", Body: "body"}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "To: a@example.com") || !strings.Contains(string(data), "To: b@example.com") {
+		t.Errorf("file does not contain both messages: %s", data)
+	}
+}
+
+// fakeSMTPServer accepts a single SMTP session and returns the received DATA
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &SMTPMailer{Host: host, From: "noreply@example.com"}
+	if m.Port, err = strconv.Atoi(port); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Send(Message{To: "rrm@example.com", Subject: "Reset", Body: "line one\nline two"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	data := <-received
+	for _, want := range []string{"From: noreply@example.com", "To: rrm@example.com", "Subject: Reset", "line one\r\nline two"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message %q does not contain %q", data, want)
+		}
+	}
+}
@@ -2,9 +2,20 @@
 package main
 
 import (
+	"context"
+	"go-rest-api/auth"
 	"go-rest-api/database"
+	"go-rest-api/mail"
+	"go-rest-api/oidc"
 	"go-rest-api/routes"
+	"go-rest-api/services"
+	"go-rest-api/utils"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	_ "go-rest-api/docs"
 
@@ -28,6 +39,11 @@ import (
 // @host localhost:8080
 // @BasePath /
 // @Schemes http
+
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description Access token as "Bearer <token>"
 func main() {
 	// Load environment variables
 	err := godotenv.Load()
@@ -40,9 +56,82 @@ func main() {
 		return
 	}
 
-	r := routes.SetupRouter(dbInstance)
+	keys, err := auth.LoadKeySet()
+	if err != nil {
+		log.Fatalf("Error loading JWT keys: %v", err)
+	}
+
+	mailer, err := mail.NewMailerFromEnv()
+	if err != nil {
+		log.Fatalf("Error configuring mailer: %v", err)
+	}
+
+	passwordPolicy, err := auth.LoadPasswordPolicy()
+	if err != nil {
+		log.Fatalf("Error loading password policy: %v", err)
+	}
+
+	passwordHasher, err := utils.LoadPasswordHasher()
+	if err != nil {
+		log.Fatalf("Error configuring password hashing: %v", err)
+	}
+
+	claims, err := auth.LoadClaimsConfig()
+	if err != nil {
+		log.Fatalf("Error loading JWT claims config: %v", err)
+	}
+
+	introspectionClients, err := auth.LoadIntrospectionClients()
+	if err != nil {
+		log.Fatalf("Error loading introspection clients: %v", err)
+	}
+
+	oidcProviders, err := oidc.LoadProviders()
+	if err != nil {
+		log.Fatalf("Error loading OIDC providers: %v", err)
+	}
+
+	deletedUsers, err := services.LoadDeletedUserPolicy()
+	if err != nil {
+		log.Fatalf("Error loading deleted user policy: %v", err)
+	}
+
+	cfg := routes.Config{
+		DB:                   dbInstance,
+		Keys:                 keys,
+		Mailer:               mailer,
+		PasswordPolicy:       passwordPolicy,
+		PasswordHasher:       passwordHasher,
+		OIDCProviders:        oidcProviders,
+		Claims:               claims,
+		IntrospectionClients: introspectionClients,
+		DeletedUsers:         deletedUsers,
+	}
+	userService := routes.NewUserService(cfg)
+	r := routes.SetupRouter(userService, cfg)
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(files.Handler))
-	if err := r.Run(":8080"); err != nil {
-		log.Fatal("Failed to run server: ", err)
+
+	// Background jobs and the server stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if deletedUsers.Retention > 0 {
+		go services.RunUserPurge(ctx, userService, deletedUsers.Retention, time.Hour)
+	}
+
+	server := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to run server: ", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down server: %v", err)
 	}
 }
@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email VARCHAR(255);
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE users SET email = LOWER(email) WHERE email IS NOT NULL;
+
+CREATE UNIQUE INDEX idx_users_email ON users (email) WHERE email IS NOT NULL AND email <> '';
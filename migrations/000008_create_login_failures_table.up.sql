@@ -0,0 +1,10 @@
+CREATE TABLE login_failures (
+    id SERIAL PRIMARY KEY,
+    key VARCHAR(320) UNIQUE NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_login_failures_last_failure_at ON login_failures (last_failure_at);
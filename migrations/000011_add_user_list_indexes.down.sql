@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_username_pattern;
+DROP INDEX IF EXISTS idx_users_country;
+DROP INDEX IF EXISTS idx_users_created_at;
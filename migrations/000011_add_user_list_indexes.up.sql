@@ -0,0 +1,3 @@
+CREATE INDEX idx_users_created_at ON users (created_at, id);
+CREATE INDEX idx_users_country ON users (country, id);
+CREATE INDEX idx_users_username_pattern ON users (username varchar_pattern_ops);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_deleted_at;
+DROP INDEX IF EXISTS idx_users_username;
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
@@ -0,0 +1,10 @@
+-- 000001 defaulted deleted_at to the insert time, which hid every row that was
+-- inserted without it. A real deletion never happens at the creation time.
+ALTER TABLE users ALTER COLUMN deleted_at DROP DEFAULT;
+UPDATE users SET deleted_at = NULL WHERE deleted_at = created_at;
+
+-- Usernames only have to be unique among users that are not deleted; whether
+-- deleted users keep theirs is up to the application
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_username_key;
+CREATE UNIQUE INDEX idx_users_username ON users (username) WHERE deleted_at IS NULL;
+CREATE INDEX idx_users_deleted_at ON users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,52 @@
+// models/api_key.go
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// APIKey is a long-lived credential for non-interactive clients. Only the
+// SHA-256 hash of the key is stored; Prefix is kept in clear so that users can
+// tell their keys apart.
+type APIKey struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"-"`
+	Name       string     `gorm:"not null" json:"name"`
+	Prefix     string     `gorm:"not null;index" json:"prefix"`
+	KeyHash    string     `gorm:"unique;not null" json:"-"`
+	Scopes     Scopes     `gorm:"type:text;not null" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// NewAPIKey is returned once when a key is created; Key is never shown again
+type NewAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// Scopes are stored as a space separated list, like the OAuth scope parameter
+type Scopes []string
+
+func (s Scopes) Value() (driver.Value, error) {
+	return strings.Join(s, " "), nil
+}
+
+func (s *Scopes) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = Scopes{}
+	case string:
+		*s = strings.Fields(v)
+	case []byte:
+		*s = strings.Fields(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into Scopes", value)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+// models/identity.go
+package models
+
+import (
+	"time"
+)
+
+// UserIdentity links an account at an external OpenID Connect provider to a
+// user. Subject is the provider's stable ID for the account.
+type UserIdentity struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"-"`
+	Provider  string    `gorm:"not null;uniqueIndex:idx_user_identities_provider_subject" json:"provider"`
+	Subject   string    `gorm:"not null;uniqueIndex:idx_user_identities_provider_subject" json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCRedirect starts a sign in at an external provider. The user is sent to
+// AuthorizationURL; StateToken goes into a cookie and is checked on return.
+type OIDCRedirect struct {
+	AuthorizationURL string `json:"authorization_url"`
+	StateToken       string `json:"-"`
+}
@@ -0,0 +1,18 @@
+// models/lockout.go
+package models
+
+import (
+	"time"
+)
+
+// LoginFailure counts recent failed logins for one key: a user ID
+// ("user:<id>"), a login name that matches no user ("login:<name>") or a
+// client address ("ip:192.0.2.1").
+type LoginFailure struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	Key           string    `gorm:"unique;not null" json:"key"`
+	Failures      int       `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt time.Time `gorm:"not null" json:"last_failure_at"`
+	LockedUntil   time.Time `gorm:"not null" json:"locked_until"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
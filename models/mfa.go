@@ -0,0 +1,30 @@
+// models/mfa.go
+package models
+
+import (
+	"time"
+)
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the user has
+// lost their authenticator. Only the bcrypt hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TOTPEnrollment is returned when a user starts enrolling an authenticator
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFAChallenge is returned instead of a TokenPair when the password was
+// correct but a second factor is still required
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
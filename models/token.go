@@ -0,0 +1,71 @@
+// models/token.go
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshToken is a long-lived, opaque credential that can be exchanged for a
+// new access token. Only the SHA-256 hash of the token is stored. Tokens that
+// descend from the same login share a FamilyID so that reuse of a rotated
+// token can revoke the whole chain.
+type RefreshToken struct {
+	gorm.Model
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"unique;not null" json:"-"`
+	FamilyID  string     `gorm:"not null;index" json:"family_id"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// TokenPair is returned to clients after a successful login or refresh.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// RevokedToken records the jti of an access token that was revoked before it
+// expired. Rows can be dropped once ExpiresAt has passed.
+type RevokedToken struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	JTI       string    `gorm:"column:jti;unique;not null" json:"jti"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PasswordResetToken is a single-use credential mailed to a user who forgot
+// their password. Only the SHA-256 hash of the token is stored.
+type PasswordResetToken struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"unique;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Token types reported by introspection
+const (
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+	TokenTypeAPIKey  = "api_key"
+)
+
+// TokenIntrospection describes a token as defined by RFC 7662. Inactive tokens
+// only report active=false, whatever the reason.
+type TokenIntrospection struct {
+	Active    bool     `json:"active"`
+	TokenType string   `json:"token_type,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	JTI       string   `json:"jti,omitempty"`
+}
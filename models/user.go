@@ -2,12 +2,34 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type User struct {
 	gorm.Model
-	Username string `gorm:"unique;not null" json:"username"`
-	Password string `gorm:"not null" json:"password"`
-	Country  string `gorm:"not null" json:"country"`
+	// Username is unique among users that are not deleted. Deleted users
+	// keep theirs reserved unless the deleted user policy frees it.
+	Username string `gorm:"uniqueIndex:idx_users_username,where:deleted_at IS NULL;not null" json:"username"`
+	Password string `gorm:"not null" json:"-"`
+	// Email is optional and unique among users that set one. It is stored
+	// lower case.
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Country         string     `gorm:"not null" json:"country"`
+	Role            string     `gorm:"not null;default:user" json:"role"`
+	// TokenVersion is embedded in every access token; bumping it invalidates
+	// all tokens issued before.
+	TokenVersion uint `gorm:"not null;default:0" json:"-"`
+	// TOTPSecret is set once enrollment starts; logins only require a code
+	// after TOTPEnabled is set by confirming one. TOTPLastStep is the time
+	// step of the last accepted code, so that no code is accepted twice.
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `gorm:"not null;default:false" json:"totp_enabled"`
+	TOTPLastStep int64  `gorm:"not null;default:0" json:"-"`
+	// Version counts the saved changes of the user. It is the entity tag of
+	// the user and guards updates against concurrent ones. Every update
+	// increments it in the database.
+	Version uint `gorm:"not null;default:1" json:"-"`
 }
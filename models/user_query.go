@@ -0,0 +1,29 @@
+// models/user_query.go
+package models
+
+import "time"
+
+// UserQuery selects a page of users. Filters that are left empty match every
+// user. Sort names a field, prefixed with "-" for descending order. Cursor
+// continues after the last user of a previous page with the same Sort; Offset
+// skips users instead and cannot be combined with it. Deleted selects the
+// users that have been deleted but not purged instead of the others.
+type UserQuery struct {
+	Country        string
+	UsernamePrefix string
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	Sort           string
+	Limit          int
+	Offset         int
+	Cursor         string
+	Deleted        bool
+}
+
+// UserPage is one page of users. NextCursor is empty on the last page; Total
+// counts every user matching the filters, on all pages.
+type UserPage struct {
+	Users      []User
+	NextCursor string
+	Total      int64
+}
@@ -0,0 +1,90 @@
+// oidc/config.go
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// ProviderConfig describes an external OpenID Connect provider users can sign
+// in with. Endpoints are discovered from the issuer unless they are set.
+type ProviderConfig struct {
+	// Name identifies the provider in /auth/{name}/login
+	Name         string `json:"name"`
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// ClientSecretEnv names an environment variable holding the client
+	// secret, to keep it out of the config file
+	ClientSecretEnv string `json:"client_secret_env"`
+	// RedirectURL is the absolute URL of /auth/{name}/callback as registered
+	// with the provider
+	RedirectURL string `json:"redirect_url"`
+	// Scopes are requested in addition to "openid"; defaults to email and
+	// profile
+	Scopes []string `json:"scopes"`
+	// AllowSignUp creates a new user for identities that are not linked yet
+	AllowSignUp bool `json:"allow_signup"`
+	// LinkByEmail links an identity to the user with the same verified email
+	// address, when the provider has verified it as well
+	LinkByEmail bool `json:"link_by_email"`
+
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var providerName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+func (c ProviderConfig) validate() error {
+	if !providerName.MatchString(c.Name) {
+		return fmt.Errorf("invalid provider name %q", c.Name)
+	}
+	if c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" {
+		return fmt.Errorf("provider %s: issuer, client_id and redirect_url are required", c.Name)
+	}
+	return nil
+}
+
+// LoadProviders reads the provider list from the JSON file named by
+// OIDC_PROVIDERS_FILE. Social login is disabled when it is not set.
+func LoadProviders() ([]ProviderConfig, error) {
+	path := os.Getenv("OIDC_PROVIDERS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	return LoadProvidersFile(path)
+}
+
+// LoadProvidersFile reads a JSON array of provider configs
+func LoadProvidersFile(path string) ([]ProviderConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []ProviderConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	seen := map[string]bool{}
+	for i, c := range configs {
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("provider %s is configured twice", c.Name)
+		}
+		seen[c.Name] = true
+
+		if c.ClientSecretEnv != "" {
+			configs[i].ClientSecret = os.Getenv(c.ClientSecretEnv)
+		}
+	}
+
+	return configs, nil
+}
@@ -0,0 +1,369 @@
+// oidc/provider.go
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// keyRefreshInterval limits how often the provider's keys are fetched again
+// when an ID token names an unknown key
+const keyRefreshInterval = time.Minute
+
+// ErrInvalidIDToken is returned when the ID token fails verification
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// signingMethods are the ID token algorithms that are accepted
+var signingMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// Identity is the verified result of a sign in at a provider
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// IDTokenClaims are the ID token claims this package reads
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	AuthorizedParty   string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// Provider signs users in at one OpenID Connect provider with the
+// authorization code flow and PKCE
+type Provider struct {
+	Config ProviderConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	endpoints     *discoveryDocument
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// NewProvider returns a provider that talks to it with client, or with a
+// client with a ten second timeout when client is nil
+func NewProvider(config ProviderConfig, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{Config: config, client: client}
+}
+
+// AuthCodeURL returns the URL to send the user to. state and nonce are
+// checked again on the way back; codeChallenge is the S256 challenge of the
+// verifier later given to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	endpoints, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := p.Config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {p.Config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(endpoints.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return endpoints.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the identity from the
+// verified ID token. nonce must be the one sent with AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (Identity, error) {
+	endpoints, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.doJSON(req, &token); err != nil {
+		if token.Error != "" {
+			return Identity{}, fmt.Errorf("token request failed: %s %s", token.Error, token.ErrorDescription)
+		}
+		return Identity{}, err
+	}
+	if token.IDToken == "" {
+		return Identity{}, fmt.Errorf("%w: token response has no id_token", ErrInvalidIDToken)
+	}
+
+	claims, err := p.VerifyIDToken(ctx, token.IDToken, nonce)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience, lifetime and nonce
+// of an ID token
+func (p *Provider) VerifyIDToken(ctx context.Context, idToken, nonce string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(signingMethods))
+	_, err := parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	switch {
+	case !claims.VerifyIssuer(p.Config.Issuer, true):
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.VerifyAudience(p.Config.ClientID, true):
+		return nil, fmt.Errorf("%w: not issued for this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.Config.ClientID:
+		return nil, fmt.Errorf("%w: unexpected authorized party %q", ErrInvalidIDToken, claims.AuthorizedParty)
+	case claims.ExpiresAt == nil:
+		return nil, fmt.Errorf("%w: no expiry", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	case nonce == "" || claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+// discover returns the provider's endpoints, fetching the discovery document
+// on first use unless every endpoint is configured
+func (p *Provider) discover(ctx context.Context) (*discoveryDocument, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+
+	doc := &discoveryDocument{
+		Issuer:                p.Config.Issuer,
+		AuthorizationEndpoint: p.Config.AuthorizationEndpoint,
+		TokenEndpoint:         p.Config.TokenEndpoint,
+		JWKSURI:               p.Config.JWKSURI,
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		discoveryURL := strings.TrimSuffix(p.Config.Issuer, "/") + "/.well-known/openid-configuration"
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var discovered discoveryDocument
+		if err := p.doJSON(req, &discovered); err != nil {
+			return nil, fmt.Errorf("discovering %s: %w", p.Config.Issuer, err)
+		}
+		if discovered.Issuer != p.Config.Issuer {
+			return nil, fmt.Errorf("discovery document of %s names issuer %q", p.Config.Issuer, discovered.Issuer)
+		}
+
+		if doc.AuthorizationEndpoint == "" {
+			doc.AuthorizationEndpoint = discovered.AuthorizationEndpoint
+		}
+		if doc.TokenEndpoint == "" {
+			doc.TokenEndpoint = discovered.TokenEndpoint
+		}
+		if doc.JWKSURI == "" {
+			doc.JWKSURI = discovered.JWKSURI
+		}
+	}
+
+	p.endpoints = doc
+	return doc, nil
+}
+
+// key returns the provider's public key with the given ID, fetching the key
+// set again when the key is unknown
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	endpoints, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoints.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.doJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("fetching keys of %s: %w", p.Config.Issuer, err)
+	}
+
+	p.keys = map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if public, err := k.publicKey(); err == nil {
+			p.keys[k.KeyID] = public
+		}
+	}
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// lookupKey finds a cached key. Tokens without a kid are accepted when the
+// provider publishes a single key.
+func (p *Provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// doJSON performs req and decodes the JSON response into v. Error responses
+// are decoded too, so that callers can read OAuth error fields.
+func (p *Provider) doJSON(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	decodeErr := json.Unmarshal(body, v)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+	}
+	return decodeErr
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
@@ -0,0 +1,201 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-rest-api/oidc"
+	"go-rest-api/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const redirectURL = "http://localhost:8080/auth/test/callback"
+
+func newTestProvider(t *testing.T) (*oidc.Provider, *oidctest.Server) {
+	server := oidctest.NewServer("client-id", "client-secret")
+	t.Cleanup(server.Close)
+
+	provider := oidc.NewProvider(oidc.ProviderConfig{
+		Name:         "test",
+		Issuer:       server.Issuer(),
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  redirectURL,
+	}, server.Client())
+	return provider, server
+}
+
+// signIn runs the authorization code flow up to the code exchange
+func signIn(t *testing.T, provider *oidc.Provider, server *oidctest.Server, verifier, nonce string) (oidc.Identity, error) {
+	t.Helper()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state-123", nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+
+	code, state, err := server.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	if state != "state-123" {
+		t.Fatalf("state = %q, want state-123", state)
+	}
+
+	return provider.Exchange(context.Background(), code, verifier, nonce)
+}
+
+func TestProvider_AuthCodeURL(t *testing.T) {
+	provider, server := newTestProvider(t)
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "challenge")
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != server.URL+"/authorize" {
+		t.Errorf("endpoint = %s, want the discovered authorization endpoint", got)
+	}
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "client-id",
+		"redirect_uri":          redirectURL,
+		"scope":                 "openid email profile",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        "challenge",
+		"code_challenge_method": "S256",
+	}
+	for param, value := range want {
+		if got := u.Query().Get(param); got != value {
+			t.Errorf("%s = %q, want %q", param, got, value)
+		}
+	}
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	provider, server := newTestProvider(t)
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := signIn(t, provider, server, verifier, "nonce-abc")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	want := oidc.Identity{Subject: "248289761001", Email: "jane@example.com", EmailVerified: true, Name: "Jane Doe", PreferredUsername: "jane"}
+	if identity != want {
+		t.Errorf("Exchange() = %+v, want %+v", identity, want)
+	}
+}
+
+func TestProvider_Exchange_WrongVerifier(t *testing.T) {
+	provider, server := newTestProvider(t)
+
+	authURL, _ := provider.AuthCodeURL(context.Background(), "state", "nonce", oidc.CodeChallenge("the-real-verifier"))
+	code, _, err := server.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.Exchange(context.Background(), code, "another-verifier", "nonce"); err == nil {
+		t.Errorf("Exchange() with the wrong verifier error = nil")
+	}
+}
+
+func TestProvider_Exchange_WrongClientSecret(t *testing.T) {
+	server := oidctest.NewServer("client-id", "client-secret")
+	defer server.Close()
+
+	provider := oidc.NewProvider(oidc.ProviderConfig{
+		Name:         "test",
+		Issuer:       server.Issuer(),
+		ClientID:     "client-id",
+		ClientSecret: "wrong",
+		RedirectURL:  redirectURL,
+	}, server.Client())
+
+	if _, err := signIn(t, provider, server, "verifier-verifier-verifier-verifier-verif", "nonce"); err == nil {
+		t.Errorf("Exchange() with the wrong client secret error = nil")
+	}
+}
+
+func TestProvider_VerifyIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+	}{
+		{name: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = "another-client" }},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "wrong nonce", modify: func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{name: "no subject", modify: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{
+			name: "several audiences without azp",
+			modify: func(c jwt.MapClaims) {
+				c["aud"] = []string{"client-id", "another-client"}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, server := newTestProvider(t)
+			server.ModifyClaims = tt.modify
+
+			_, err := signIn(t, provider, server, "verifier-verifier-verifier-verifier-verif", "nonce")
+			if !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Errorf("Exchange() error = %v, want %v", err, oidc.ErrInvalidIDToken)
+			}
+		})
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// Example from RFC 7636, appendix B
+	got := oidc.CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallenge() = %s, want %s", got, want)
+	}
+}
+
+func TestLoadProvidersFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TEST_OIDC_SECRET", "from-env")
+
+	valid := filepath.Join(dir, "providers.json")
+	os.WriteFile(valid, []byte(`[{
+		"name": "google",
+		"issuer": "https://accounts.google.com",
+		"client_id": "id",
+		"client_secret_env": "TEST_OIDC_SECRET",
+		"redirect_url": "https://api.example.com/auth/google/callback",
+		"allow_signup": true
+	}]`), 0o600)
+
+	configs, err := oidc.LoadProvidersFile(valid)
+	if err != nil {
+		t.Fatalf("LoadProvidersFile() error = %v", err)
+	}
+	if len(configs) != 1 || configs[0].ClientSecret != "from-env" || !configs[0].AllowSignUp {
+		t.Errorf("LoadProvidersFile() = %+v", configs)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`[{"name": "Google!", "issuer": "https://accounts.google.com", "client_id": "id", "redirect_url": "https://x"}]`), 0o600)
+	if _, err := oidc.LoadProvidersFile(invalid); err == nil {
+		t.Errorf("LoadProvidersFile() with an invalid name error = nil")
+	}
+}
@@ -0,0 +1,371 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902)
+// documents to JSON documents.
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Media types of the patch formats, as sent in Content-Type
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+var (
+	// ErrInvalidPatch is wrapped by the errors about patches that are
+	// malformed or do not fit the document, such as a path that does not exist
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrTestFailed is wrapped when a JSON Patch test operation fails
+	ErrTestFailed = errors.New("patch test failed")
+)
+
+// Merge applies a merge patch to doc (RFC 7396). Members of the patch replace
+// those of doc, objects are merged recursively and null removes a member.
+func Merge(doc, mergePatch []byte) ([]byte, error) {
+	var target, p interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(mergePatch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target, p interface{}) interface{} {
+	members, ok := p.(map[string]interface{})
+	if !ok {
+		return p
+	}
+
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{}
+	}
+	for name, value := range members {
+		if value == nil {
+			delete(object, name)
+			continue
+		}
+		object[name] = mergeValue(object[name], value)
+	}
+
+	return object
+}
+
+// Apply applies the operations of a JSON Patch to doc in order (RFC 6902).
+// Either all of them succeed or an error is returned.
+func Apply(doc, jsonPatch []byte) ([]byte, error) {
+	var target interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	// Operations are decoded member by member to tell a null value from a
+	// missing one
+	var operations []map[string]json.RawMessage
+	if err := json.Unmarshal(jsonPatch, &operations); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	for i, operation := range operations {
+		var err error
+		target, err = applyOperation(target, operation)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyOperation(doc interface{}, operation map[string]json.RawMessage) (interface{}, error) {
+	var op string
+	if err := json.Unmarshal(operation["op"], &op); err != nil {
+		return nil, fmt.Errorf("%w: missing op", ErrInvalidPatch)
+	}
+	path, err := pointerMember(operation, "path")
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "add":
+		value, err := valueMember(operation)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+
+	case "remove":
+		doc, _, err := remove(doc, path)
+		return doc, err
+
+	case "replace":
+		value, err := valueMember(operation)
+		if err != nil {
+			return nil, err
+		}
+		doc, _, err := remove(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+
+	case "move":
+		from, err := pointerMember(operation, "from")
+		if err != nil {
+			return nil, err
+		}
+		if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+			return nil, fmt.Errorf("%w: cannot move a value into itself", ErrInvalidPatch)
+		}
+		doc, value, err := remove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+
+	case "copy":
+		from, err := pointerMember(operation, "from")
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, deepCopy(value))
+
+	case "test":
+		value, err := valueMember(operation)
+		if err != nil {
+			return nil, err
+		}
+		actual, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(actual, value) {
+			return nil, fmt.Errorf("%w: unexpected value at %s", ErrTestFailed, formatPointer(path))
+		}
+		return doc, nil
+
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, op)
+	}
+}
+
+func valueMember(operation map[string]json.RawMessage) (interface{}, error) {
+	raw, ok := operation["value"]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing value", ErrInvalidPatch)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	return value, nil
+}
+
+func pointerMember(operation map[string]json.RawMessage, name string) ([]string, error) {
+	var pointer string
+	if err := json.Unmarshal(operation[name], &pointer); err != nil {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidPatch, name)
+	}
+
+	return parsePointer(pointer)
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped reference
+// tokens. The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: pointer %q does not start with /", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+func formatPointer(tokens []string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteString("/")
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+
+	return b.String()
+}
+
+// arrayIndex parses an array index below limit, without leading zeros
+func arrayIndex(token string, limit int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i >= limit || (len(token) > 1 && token[0] == '0') || token[0] == '+' {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+
+	return i, nil
+}
+
+func notFound(tokens []string) error {
+	return fmt.Errorf("%w: %s does not exist", ErrInvalidPatch, formatPointer(tokens))
+}
+
+func get(node interface{}, tokens []string) (interface{}, error) {
+	for i, token := range tokens {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, notFound(tokens[:i+1])
+			}
+			node = child
+		case []interface{}:
+			index, err := arrayIndex(token, len(n))
+			if err != nil {
+				return nil, err
+			}
+			node = n[index]
+		default:
+			return nil, notFound(tokens[:i+1])
+		}
+	}
+
+	return node, nil
+}
+
+// add returns node with value added at tokens. Members are replaced, array
+// elements are inserted and "-" appends to an array.
+func add(node interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	token, rest := tokens[0], tokens[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			n[token] = value
+			return n, nil
+		}
+		child, ok := n[token]
+		if !ok {
+			return nil, notFound(tokens[:1])
+		}
+		child, err := add(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = child
+		return n, nil
+
+	case []interface{}:
+		if len(rest) == 0 {
+			if token == "-" {
+				return append(n, value), nil
+			}
+			index, err := arrayIndex(token, len(n)+1)
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[index+1:], n[index:])
+			n[index] = value
+			return n, nil
+		}
+		index, err := arrayIndex(token, len(n))
+		if err != nil {
+			return nil, err
+		}
+		child, err := add(n[index], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[index] = child
+		return n, nil
+
+	default:
+		return nil, notFound(tokens[:1])
+	}
+}
+
+// remove returns node without the value at tokens, and that value
+func remove(node interface{}, tokens []string) (interface{}, interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, node, nil
+	}
+	token, rest := tokens[0], tokens[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, nil, notFound(tokens[:1])
+		}
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, child, nil
+		}
+		child, removed, err := remove(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[token] = child
+		return n, removed, nil
+
+	case []interface{}:
+		index, err := arrayIndex(token, len(n))
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := n[index]
+			return append(n[:index:index], n[index+1:]...), removed, nil
+		}
+		child, removed, err := remove(n[index], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[index] = child
+		return n, removed, nil
+
+	default:
+		return nil, nil, notFound(tokens[:1])
+	}
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for name, member := range v {
+			object[name] = deepCopy(member)
+		}
+		return object
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, element := range v {
+			array[i] = deepCopy(element)
+		}
+		return array
+	default:
+		return v
+	}
+}
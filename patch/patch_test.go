@@ -0,0 +1,220 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// assertJSON compares two JSON documents regardless of formatting and member order
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("result %s is not JSON: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("want %s is not JSON: %v", want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	// Examples from RFC 7396, appendix A
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got, err := Merge([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("Merge(%s, %s) error = %v", tt.doc, tt.patch, err)
+			continue
+		}
+		assertJSON(t, got, tt.want)
+	}
+
+	if _, err := Merge([]byte(`{}`), []byte(`{"a":`)); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("Merge() with malformed patch error = %v, want ErrInvalidPatch", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	// Mostly examples from RFC 6902, appendix A
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		{
+			name:  "add an object member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want:  `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			name:  "add an array element",
+			doc:   `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want:  `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			name:  "append to an array",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			want:  `{"foo":["bar",["abc","def"]]}`,
+		},
+		{
+			name:  "add a null value",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":null}]`,
+			want:  `{"foo":"bar","baz":null}`,
+		},
+		{
+			name:  "remove an object member",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"}]`,
+			want:  `{"foo":"bar"}`,
+		},
+		{
+			name:  "remove an array element",
+			doc:   `{"foo":["bar","qux","baz"]}`,
+			patch: `[{"op":"remove","path":"/foo/1"}]`,
+			want:  `{"foo":["bar","baz"]}`,
+		},
+		{
+			name:  "replace a value",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			want:  `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			name:  "move a value",
+			doc:   `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			want:  `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			name:  "move an array element",
+			doc:   `{"foo":["all","grass","cows","eat"]}`,
+			patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			want:  `{"foo":["all","cows","eat","grass"]}`,
+		},
+		{
+			name:  "copy a value",
+			doc:   `{"foo":{"bar":[1]}}`,
+			patch: `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"add","path":"/baz/bar/-","value":2}]`,
+			want:  `{"foo":{"bar":[1]},"baz":{"bar":[1,2]}}`,
+		},
+		{
+			name:  "test and replace",
+			doc:   `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2},{"op":"replace","path":"/baz","value":"x"}]`,
+			want:  `{"baz":"x","foo":["a",2,"c"]}`,
+		},
+		{
+			name:  "escaped pointer",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":10},{"op":"remove","path":"/~1"}]`,
+			want:  `{"~1":10}`,
+		},
+		{
+			name:    "failed test",
+			doc:     `{"baz":"qux"}`,
+			patch:   `[{"op":"test","path":"/baz","value":"bar"}]`,
+			wantErr: ErrTestFailed,
+		},
+		{
+			name:    "add to a missing parent",
+			doc:     `{"foo":"bar"}`,
+			patch:   `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "remove a missing member",
+			doc:     `{"foo":"bar"}`,
+			patch:   `[{"op":"remove","path":"/baz"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "replace a missing member",
+			doc:     `{"foo":"bar"}`,
+			patch:   `[{"op":"replace","path":"/baz","value":1}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "array index out of bounds",
+			doc:     `{"foo":["bar","baz"]}`,
+			patch:   `[{"op":"add","path":"/foo/3","value":"qux"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "array index with leading zero",
+			doc:     `{"foo":["bar","baz"]}`,
+			patch:   `[{"op":"remove","path":"/foo/01"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "move into itself",
+			doc:     `{"foo":{"bar":1}}`,
+			patch:   `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "missing value",
+			doc:     `{"foo":"bar"}`,
+			patch:   `[{"op":"add","path":"/baz"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "unknown op",
+			doc:     `{"foo":"bar"}`,
+			patch:   `[{"op":"merge","path":"/foo","value":1}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "not a list of operations",
+			doc:     `{"foo":"bar"}`,
+			patch:   `{"op":"remove","path":"/foo"}`,
+			wantErr: ErrInvalidPatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Apply() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
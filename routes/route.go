@@ -2,30 +2,123 @@
 package routes
 
 import (
+	"go-rest-api/auth"
 	"go-rest-api/controllers"
 	"go-rest-api/database"
+	"go-rest-api/mail"
+	"go-rest-api/oidc"
 	"go-rest-api/services"
+	"go-rest-api/utils"
+	"os"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRouter(db *database.GormDatabase) *gin.Engine {
+// Config holds the settings main loads for the services and routes. The rest
+// is read from the environment here.
+type Config struct {
+	DB                   *database.GormDatabase
+	Keys                 *auth.KeySet
+	Mailer               mail.Mailer
+	PasswordPolicy       auth.PasswordPolicy
+	PasswordHasher       utils.PasswordHasher
+	OIDCProviders        []oidc.ProviderConfig
+	Claims               auth.ClaimsConfig
+	IntrospectionClients auth.Clients
+	DeletedUsers         services.DeletedUserPolicy
+}
+
+// NewUserService creates the user service for SetupRouter
+func NewUserService(cfg Config) services.UserService {
+	var lockoutStore services.LockoutStore
+	if os.Getenv("LOCKOUT_STORE") == "database" {
+		lockoutStore = services.NewDatabaseLockoutStore(cfg.DB, services.DefaultLockoutPolicy.ResetAfter)
+	}
+
+	return services.NewUserService(cfg.DB, cfg.Keys, services.Config{
+		Mailer:               cfg.Mailer,
+		PasswordResetURL:     os.Getenv("PASSWORD_RESET_URL"),
+		EmailVerificationURL: os.Getenv("EMAIL_VERIFICATION_URL"),
+		RequireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+		TOTPIssuer:           os.Getenv("TOTP_ISSUER"),
+		LockoutStore:         lockoutStore,
+		PasswordPolicy:       &cfg.PasswordPolicy,
+		PasswordHasher:       cfg.PasswordHasher,
+		Claims:               cfg.Claims,
+		OIDCProviders:        cfg.OIDCProviders,
+		DeletedUsers:         cfg.DeletedUsers,
+	})
+}
+
+// SetupRouter registers the routes of the API, served by userService
+func SetupRouter(userService services.UserService, cfg Config) *gin.Engine {
 	r := gin.Default()
 
-	userService := services.NewUserService(db)
 	userController := controllers.NewUserController(userService)
 
+	insecureCookies := os.Getenv("AUTH_COOKIE_SECURE") == "false"
+	if insecureCookies {
+		userController.AllowInsecureCookies()
+	}
+
+	tokenSources := []controllers.TokenSource{controllers.BearerToken{}}
+	if os.Getenv("AUTH_COOKIE") == "true" {
+		cookie := controllers.DefaultCookieToken
+		cookie.Insecure = insecureCookies
+		userController.UseSessionCookie(cookie)
+		tokenSources = append(tokenSources, cookie)
+	}
+	if param := os.Getenv("AUTH_QUERY_PARAM"); param != "" {
+		tokenSources = append(tokenSources, controllers.QueryToken{Param: param})
+	}
+	wellKnownController := controllers.NewWellKnownController(cfg.Keys, cfg.Claims.Issuer)
+
+	r.GET("/.well-known/jwks.json", wellKnownController.JWKS)
+	r.GET("/.well-known/openid-configuration", wellKnownController.OpenIDConfiguration)
+
 	r.POST("/signup", userController.SignUp)
 	r.POST("/login", userController.Login)
+	r.POST("/login/mfa", userController.LoginMFA)
+	r.POST("/token/refresh", userController.RefreshToken)
+	r.POST("/password/forgot", userController.ForgotPassword)
+	r.POST("/password/reset", userController.ResetPassword)
+	r.POST("/verify-email", userController.VerifyEmail)
+	r.POST("/verify-email/resend", userController.ResendVerification)
+	r.GET("/auth/:provider/login", userController.OIDCLogin)
+	r.GET("/auth/:provider/callback", userController.OIDCCallback)
+	r.POST("/oauth/introspect", controllers.RequireClient(cfg.IntrospectionClients), userController.IntrospectToken)
+
+	// Admin routes can additionally require the audience of tokens issued to admins
+	adminAudience := controllers.RequireAudience(cfg.Claims.AdminAudience)
 
 	// Protected routes
 	authorized := r.Group("/")
-	authorized.Use(controllers.AuthMiddleware())
+	authorized.Use(controllers.AuthMiddleware(userService, tokenSources...))
 	{
-		authorized.GET("/users", userController.GetUsers)
+		authorized.POST("/logout", userController.Logout)
+		authorized.GET("/me", userController.GetMe)
+		authorized.PATCH("/me", userController.UpdateMe)
+		authorized.DELETE("/me", userController.DeleteMe)
+		authorized.PUT("/me/password", userController.ChangeMyPassword)
+		authorized.POST("/me/mfa/totp", userController.EnrollTOTP)
+		authorized.POST("/me/mfa/totp/confirm", userController.ConfirmTOTP)
+		authorized.DELETE("/me/mfa/totp", userController.DisableTOTP)
+		authorized.POST("/me/api-keys", userController.CreateAPIKey)
+		authorized.GET("/me/api-keys", userController.ListAPIKeys)
+		authorized.DELETE("/me/api-keys/:id", userController.RevokeAPIKey)
+		authorized.GET("/me/identities", userController.ListIdentities)
+		authorized.POST("/me/identities/:provider", userController.LinkIdentity)
+		authorized.DELETE("/me/identities/:id", userController.UnlinkIdentity)
+		authorized.GET("/users", adminAudience, controllers.RequirePermission(auth.PermListUsers), userController.GetUsers)
 		authorized.GET("/users/:id", userController.GetUser)
 		authorized.PUT("/users/:id", userController.UpdateUser)
+		authorized.PATCH("/users/:id", userController.PatchUser)
 		authorized.DELETE("/users/:id", userController.DeleteUser)
+		authorized.DELETE("/users/:id/sessions", adminAudience, controllers.RequirePermission(auth.PermRevokeSessions), userController.RevokeSessions)
+		authorized.DELETE("/users/:id/lockout", adminAudience, controllers.RequirePermission(auth.PermUnlockUsers), userController.UnlockUser)
+		authorized.GET("/deleted-users", adminAudience, controllers.RequirePermission(auth.PermDeleteUsers), userController.GetDeletedUsers)
+		authorized.POST("/deleted-users/:id/restore", adminAudience, controllers.RequirePermission(auth.PermDeleteUsers), userController.RestoreUser)
+		authorized.DELETE("/deleted-users/:id", adminAudience, controllers.RequirePermission(auth.PermDeleteUsers), userController.PurgeUser)
 		authorized.GET("/fetch-countries", controllers.FetchCountries)
 		authorized.GET("/countries", userController.GetCountries)
 	}
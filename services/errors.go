@@ -0,0 +1,102 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-rest-api/models"
+	"time"
+)
+
+var (
+	// ErrInvalidCredentials is returned for every failed password login,
+	// whatever the reason, so that callers cannot tell which users exist
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+	ErrIncorrectPassword   = errors.New("current password is incorrect")
+	ErrInvalidResetToken   = errors.New("invalid or expired password reset token")
+	ErrInvalidEmail        = errors.New("invalid email address")
+	ErrEmailTaken          = errors.New("email address is already in use")
+	ErrEmailNotVerified    = errors.New("email address has not been verified")
+	ErrCountryRequired     = errors.New("country is required")
+	ErrUsernameTaken       = errors.New("username is already taken")
+
+	ErrInvalidVerificationToken = errors.New("invalid or expired email verification token")
+
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = errors.New("two-factor authentication has not been set up")
+	ErrInvalidMFACode    = errors.New("invalid two-factor authentication code")
+	ErrInvalidMFAToken   = errors.New("invalid or expired mfa token")
+
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrInvalidScope  = errors.New("unknown scope")
+	ErrInvalidExpiry = errors.New("expiry must be in the future")
+
+	// ErrInvalidUserQuery is wrapped with the offending parameter
+	ErrInvalidUserQuery = errors.New("invalid user query")
+
+	ErrUnknownProvider    = errors.New("unknown identity provider")
+	ErrInvalidOIDCState   = errors.New("invalid or expired sign in state")
+	ErrOIDCLoginFailed    = errors.New("sign in at the identity provider failed")
+	ErrIdentityLinked     = errors.New("identity is already linked to another user")
+	ErrOIDCSignUpDisabled = errors.New("no user is linked to this identity")
+)
+
+// AuthorizationError is returned when the caller is not allowed to perform an
+// action on a resource. Controllers map it to 403 Forbidden.
+type AuthorizationError struct {
+	Action string
+	Reason string
+}
+
+func (e *AuthorizationError) Error() string {
+	return fmt.Sprintf("not allowed to %s: %s", e.Action, e.Reason)
+}
+
+// ReadOnlyFieldError is returned when an update tries to change a field that
+// is fixed once the user exists, such as the username. Controllers map it to
+// 422 Unprocessable Entity.
+type ReadOnlyFieldError struct {
+	Field string
+}
+
+func (e *ReadOnlyFieldError) Error() string {
+	return fmt.Sprintf("field %q cannot be changed", e.Field)
+}
+
+// ConflictError is returned when a user was changed after the caller read
+// it. Version is the current version of the user. Controllers map it to 412
+// Precondition Failed when the client sent If-Match, and to 409 Conflict
+// otherwise.
+type ConflictError struct {
+	Version uint
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("user has been modified, it is at version %d now", e.Version)
+}
+
+// MFARequiredError is returned by Login when the password was correct but the
+// user has two-factor authentication enabled. Its challenge carries the token
+// to present to LoginMFA together with a code.
+type MFARequiredError struct {
+	Challenge models.MFAChallenge
+}
+
+func (e *MFARequiredError) Error() string {
+	return "two-factor authentication required"
+}
+
+// LockedOutError is returned by Login while a login name or client address is
+// locked out after too many failures. It is returned whether or not the user
+// exists. Controllers map it to 429 Too Many Requests.
+type LockedOutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedOutError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
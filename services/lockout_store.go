@@ -0,0 +1,169 @@
+package services
+
+import (
+	"go-rest-api/database"
+	"go-rest-api/models"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LockoutStore persists failed login counters. Records whose last failure is
+// older than the store's retention can be dropped.
+type LockoutStore interface {
+	// Get returns the record for key, or a record with only Key set when
+	// there is none
+	Get(key string) (models.LoginFailure, error)
+	// Increment counts a failure of key at now in one atomic step and returns
+	// the updated record. A counter that is not locked and whose last failure
+	// is more than resetAfter ago starts over.
+	Increment(key string, now time.Time, resetAfter time.Duration) (models.LoginFailure, error)
+	// Lock locks key until the given time, unless it is locked longer already
+	Lock(key string, until time.Time) error
+	Delete(key string) error
+}
+
+type memoryLockoutStore struct {
+	mu        sync.Mutex
+	records   map[string]models.LoginFailure
+	retention time.Duration
+	lastPrune time.Time
+}
+
+// NewMemoryLockoutStore keeps counters in process memory. They are lost on
+// restart and not shared between instances.
+func NewMemoryLockoutStore(retention time.Duration) LockoutStore {
+	return &memoryLockoutStore{records: map[string]models.LoginFailure{}, retention: retention}
+}
+
+func (m *memoryLockoutStore) Get(key string) (models.LoginFailure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if record, ok := m.records[key]; ok {
+		return record, nil
+	}
+	return models.LoginFailure{Key: key}, nil
+}
+
+func (m *memoryLockoutStore) Increment(key string, now time.Time, resetAfter time.Duration) (models.LoginFailure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Guessing many different usernames must not grow the map forever
+	if now.Sub(m.lastPrune) > time.Minute {
+		for k, r := range m.records {
+			if m.stale(r, now) {
+				delete(m.records, k)
+			}
+		}
+		m.lastPrune = now
+	}
+
+	record, ok := m.records[key]
+	if !ok {
+		record = models.LoginFailure{Key: key}
+	}
+	if now.Sub(record.LastFailureAt) > resetAfter && now.After(record.LockedUntil) {
+		record.Failures = 0
+	}
+
+	record.Failures++
+	record.LastFailureAt = now
+	m.records[key] = record
+	return record, nil
+}
+
+func (m *memoryLockoutStore) Lock(key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if record, ok := m.records[key]; ok && until.After(record.LockedUntil) {
+		record.LockedUntil = until
+		m.records[key] = record
+	}
+	return nil
+}
+
+func (m *memoryLockoutStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+	return nil
+}
+
+func (m *memoryLockoutStore) stale(r models.LoginFailure, now time.Time) bool {
+	return now.Sub(r.LastFailureAt) > m.retention && now.After(r.LockedUntil)
+}
+
+type databaseLockoutStore struct {
+	db        database.Database
+	retention time.Duration
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// NewDatabaseLockoutStore keeps counters in the login_failures table so that
+// every instance of the API shares them.
+func NewDatabaseLockoutStore(db database.Database, retention time.Duration) LockoutStore {
+	return &databaseLockoutStore{db: db, retention: retention}
+}
+
+func (d *databaseLockoutStore) Get(key string) (models.LoginFailure, error) {
+	var records []models.LoginFailure
+	if err := d.db.Find(&records, "key = ?", key).Error; err != nil {
+		return models.LoginFailure{}, err
+	}
+
+	if len(records) == 0 {
+		return models.LoginFailure{Key: key}, nil
+	}
+	return records[0], nil
+}
+
+func (d *databaseLockoutStore) Increment(key string, now time.Time, resetAfter time.Duration) (models.LoginFailure, error) {
+	if err := d.prune(now); err != nil {
+		return models.LoginFailure{}, err
+	}
+
+	// INSERT ... ON CONFLICT DO UPDATE ... RETURNING counts concurrent
+	// failures one after the other, where reading and saving the record
+	// would let them overwrite each other
+	record := models.LoginFailure{Key: key, Failures: 1, LastFailureAt: now}
+	err := d.db.Upsert(&record, clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "failures"}, Value: gorm.Expr(
+				"CASE WHEN login_failures.last_failure_at < ? AND login_failures.locked_until < ? THEN 1 ELSE login_failures.failures + 1 END",
+				now.Add(-resetAfter), now)},
+			{Column: clause.Column{Name: "last_failure_at"}, Value: now},
+			{Column: clause.Column{Name: "updated_at"}, Value: now},
+		},
+	}).Error
+	return record, err
+}
+
+func (d *databaseLockoutStore) Lock(key string, until time.Time) error {
+	return d.db.UpdateWhere(&models.LoginFailure{}, map[string]interface{}{"locked_until": until}, "key = ? AND locked_until < ?", key, until).Error
+}
+
+// prune drops stale counters, at most once a minute
+func (d *databaseLockoutStore) prune(now time.Time) error {
+	d.mu.Lock()
+	if now.Sub(d.lastPrune) <= time.Minute {
+		d.mu.Unlock()
+		return nil
+	}
+	d.lastPrune = now
+	d.mu.Unlock()
+
+	return d.db.Delete(&models.LoginFailure{}, "last_failure_at < ? AND locked_until < ?", now.Add(-d.retention), now).Error
+}
+
+func (d *databaseLockoutStore) Delete(key string) error {
+	return d.db.Delete(&models.LoginFailure{}, "key = ?", key).Error
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	mockDB "go-rest-api/database/mocks"
+	"go-rest-api/models"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+)
+
+func Test_memoryLockoutStore(t *testing.T) {
+	store := NewMemoryLockoutStore(time.Hour)
+	now := time.Now()
+
+	record, err := store.Get("user:1")
+	if err != nil || record.Key != "user:1" || record.Failures != 0 {
+		t.Fatalf("Get() = %+v, %v, want empty record", record, err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		record, err := store.Increment("user:1", now, time.Hour)
+		if err != nil || record.Failures != i || !record.LastFailureAt.Equal(now) {
+			t.Fatalf("Increment() = %+v, %v, want %d failures", record, err, i)
+		}
+	}
+
+	if err := store.Lock("user:1", now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// A shorter lock does not cut the current one short
+	if err := store.Lock("user:1", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Get("user:1"); got.Failures != 2 || !got.LockedUntil.Equal(now.Add(2*time.Minute)) {
+		t.Errorf("Get() after Lock() = %+v", got)
+	}
+
+	// The counter starts over once it is stale and not locked
+	if got, _ := store.Increment("user:1", now.Add(2*time.Hour), time.Hour); got.Failures != 1 {
+		t.Errorf("Increment() of a stale counter = %+v, want 1 failure", got)
+	}
+
+	if err := store.Delete("user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Get("user:1"); got.Failures != 0 {
+		t.Errorf("Get() after Delete() = %+v", got)
+	}
+}
+
+func Test_memoryLockoutStore_Prune(t *testing.T) {
+	store := NewMemoryLockoutStore(time.Hour).(*memoryLockoutStore)
+	now := time.Now()
+
+	store.Increment("login:old", now.Add(-2*time.Hour), time.Hour)
+	store.Increment("login:locked", now.Add(-2*time.Hour), time.Hour)
+	store.Lock("login:locked", now.Add(time.Hour))
+	store.lastPrune = time.Time{}
+	store.Increment("login:new", now, time.Hour)
+
+	if _, ok := store.records["login:old"]; ok {
+		t.Errorf("stale record was not pruned")
+	}
+	if _, ok := store.records["login:locked"]; !ok {
+		t.Errorf("record of an active lockout was pruned")
+	}
+}
+
+func Test_databaseLockoutStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	store := NewDatabaseLockoutStore(mkdb, time.Hour)
+
+	t.Run("get missing", func(t *testing.T) {
+		mkdb.EXPECT().Find(gomock.Any(), "key = ?", "user:1").Return(&gorm.DB{}).Times(1)
+
+		record, err := store.Get("user:1")
+		if err != nil || record.Key != "user:1" || record.ID != 0 {
+			t.Errorf("Get() = %+v, %v, want new record", record, err)
+		}
+	})
+
+	t.Run("get existing", func(t *testing.T) {
+		stored := []models.LoginFailure{{ID: 4, Key: "user:1", Failures: 3}}
+		mkdb.EXPECT().Find(gomock.Any(), "key = ?", "user:1").SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+
+		record, err := store.Get("user:1")
+		if err != nil || record.ID != 4 || record.Failures != 3 {
+			t.Errorf("Get() = %+v, %v", record, err)
+		}
+	})
+
+	t.Run("increment prunes stale records", func(t *testing.T) {
+		stored := models.LoginFailure{ID: 4, Key: "user:1", Failures: 3}
+		mkdb.EXPECT().Delete(gomock.Any(), "last_failure_at < ? AND locked_until < ?", gomock.Any(), gomock.Any()).Return(&gorm.DB{}).Times(1)
+		mkdb.EXPECT().Upsert(gomock.Any(), gomock.Any()).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+
+		record, err := store.Increment("user:1", time.Now(), time.Hour)
+		if err != nil || record.Failures != 3 {
+			t.Errorf("Increment() = %+v, %v", record, err)
+		}
+	})
+
+	t.Run("increment again", func(t *testing.T) {
+		mkdb.EXPECT().Upsert(gomock.Any(), gomock.Any()).Return(&gorm.DB{Error: fmt.Errorf("upsert error")}).Times(1)
+
+		if _, err := store.Increment("user:1", time.Now(), time.Hour); err == nil {
+			t.Errorf("Increment() error = nil, want error")
+		}
+	})
+
+	t.Run("lock", func(t *testing.T) {
+		until := time.Now().Add(time.Minute)
+		mkdb.EXPECT().UpdateWhere(gomock.Any(), map[string]interface{}{"locked_until": until}, "key = ? AND locked_until < ?", "user:1", until).Return(&gorm.DB{}).Times(1)
+
+		if err := store.Lock("user:1", until); err != nil {
+			t.Errorf("Lock() error = %v", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		mkdb.EXPECT().Delete(gomock.Any(), "key = ?", "user:1").Return(&gorm.DB{Error: fmt.Errorf("delete error")}).Times(1)
+
+		if err := store.Delete("user:1"); err == nil {
+			t.Errorf("Delete() error = nil, want error")
+		}
+	})
+}
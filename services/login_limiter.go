@@ -0,0 +1,129 @@
+package services
+
+import (
+	"go-rest-api/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockoutPolicy controls how failed logins are throttled. After MaxFailures
+// failed attempts for a user, or MaxIPFailures from one client address,
+// further attempts are refused for BaseDelay. Every additional failure doubles
+// the delay, up to MaxDelay. Counters are forgotten ResetAfter the last failure.
+type LockoutPolicy struct {
+	MaxFailures   int
+	MaxIPFailures int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	ResetAfter    time.Duration
+}
+
+// DefaultLockoutPolicy is used when Config.Lockout is left empty
+var DefaultLockoutPolicy = LockoutPolicy{
+	MaxFailures:   5,
+	MaxIPFailures: 20,
+	BaseDelay:     30 * time.Second,
+	MaxDelay:      15 * time.Minute,
+	ResetAfter:    time.Hour,
+}
+
+// loginLimiter applies a LockoutPolicy on top of a LockoutStore
+type loginLimiter struct {
+	store  LockoutStore
+	policy LockoutPolicy
+	now    func() time.Time
+}
+
+func newLoginLimiter(store LockoutStore, policy LockoutPolicy) *loginLimiter {
+	return &loginLimiter{store: store, policy: policy, now: time.Now}
+}
+
+// userLockoutKey returns the key that counts the failed logins of user. Once
+// the user is known their ID is used, so that attempts with their username and
+// their email address share one counter; unknown names are counted by name.
+func userLockoutKey(user models.User, login string) string {
+	if user.ID != 0 {
+		return "user:" + strconv.FormatUint(uint64(user.ID), 10)
+	}
+	return "login:" + strings.ToLower(strings.TrimSpace(login))
+}
+
+func ipLockoutKey(ip string) string {
+	return "ip:" + ip
+}
+
+// retryAfter returns how long the longest lockout of the given keys lasts
+func (l *loginLimiter) retryAfter(keys ...string) (time.Duration, error) {
+	var wait time.Duration
+	now := l.now()
+	for _, key := range keys {
+		record, err := l.store.Get(key)
+		if err != nil {
+			return 0, err
+		}
+
+		if remaining := record.LockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+
+	return wait, nil
+}
+
+// check returns a LockedOutError when the user key or client address is
+// locked out
+func (l *loginLimiter) check(userKey, ip string) error {
+	wait, err := l.retryAfter(userKey, ipLockoutKey(ip))
+	if err != nil {
+		return err
+	}
+
+	if wait > 0 {
+		return &LockedOutError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// fail records a failed attempt for the user key and the client address
+func (l *loginLimiter) fail(userKey, ip string) error {
+	if err := l.record(userKey, l.policy.MaxFailures); err != nil {
+		return err
+	}
+	return l.record(ipLockoutKey(ip), l.policy.MaxIPFailures)
+}
+
+// succeed clears the failures of a user key. Failures of the client address
+// are kept, otherwise one valid account would let an attacker reset them.
+func (l *loginLimiter) succeed(userKey string) error {
+	return l.store.Delete(userKey)
+}
+
+// record counts a failure of key in one step of the store, so concurrent
+// attempts cannot overwrite each other's count, and locks the key once the
+// count reaches maxFailures
+func (l *loginLimiter) record(key string, maxFailures int) error {
+	now := l.now()
+	record, err := l.store.Increment(key, now, l.policy.ResetAfter)
+	if err != nil {
+		return err
+	}
+
+	if excess := record.Failures - maxFailures; excess >= 0 {
+		return l.store.Lock(key, now.Add(l.backoff(excess)))
+	}
+	return nil
+}
+
+// backoff doubles the base delay for every failure past the limit
+func (l *loginLimiter) backoff(excess int) time.Duration {
+	delay := l.policy.BaseDelay
+	for i := 0; i < excess && delay < l.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > l.policy.MaxDelay {
+		return l.policy.MaxDelay
+	}
+	return delay
+}
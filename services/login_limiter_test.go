@@ -0,0 +1,252 @@
+package services
+
+import (
+	"errors"
+	mockDB "go-rest-api/database/mocks"
+	"go-rest-api/models"
+	"go-rest-api/utils"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+)
+
+var testLockoutPolicy = LockoutPolicy{
+	MaxFailures:   3,
+	MaxIPFailures: 10,
+	BaseDelay:     time.Minute,
+	MaxDelay:      10 * time.Minute,
+	ResetAfter:    time.Hour,
+}
+
+func newTestLimiter() *loginLimiter {
+	return newLoginLimiter(NewMemoryLockoutStore(time.Hour), testLockoutPolicy)
+}
+
+func Test_loginLimiter_backoff(t *testing.T) {
+	l := newTestLimiter()
+
+	tests := []struct {
+		excess int
+		want   time.Duration
+	}{
+		{excess: 0, want: time.Minute},
+		{excess: 1, want: 2 * time.Minute},
+		{excess: 3, want: 8 * time.Minute},
+		{excess: 4, want: 10 * time.Minute},
+		{excess: 60, want: 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := l.backoff(tt.excess); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.excess, got, tt.want)
+		}
+	}
+}
+
+func Test_loginLimiter(t *testing.T) {
+	l := newTestLimiter()
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	key := userLockoutKey(models.User{}, "rrm")
+
+	for i := 0; i < testLockoutPolicy.MaxFailures-1; i++ {
+		if err := l.fail(key, "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.check(key, "192.0.2.1"); err != nil {
+		t.Fatalf("check() before the limit error = %v", err)
+	}
+
+	if err := l.fail(userLockoutKey(models.User{}, " RRM"), "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	var lockedErr *LockedOutError
+	if err := l.check(key, "198.51.100.7"); !errors.As(err, &lockedErr) || lockedErr.RetryAfter != time.Minute {
+		t.Fatalf("check() after the limit error = %v, want lockout for 1m", err)
+	}
+
+	// Every further failure doubles the lockout
+	if err := l.fail(key, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.check(key, "192.0.2.1"); !errors.As(err, &lockedErr) || lockedErr.RetryAfter != 2*time.Minute {
+		t.Fatalf("check() error = %v, want lockout for 2m", err)
+	}
+
+	// Other names from the same address are still allowed
+	if err := l.check(userLockoutKey(models.User{}, "other"), "192.0.2.1"); err != nil {
+		t.Errorf("check() for another user error = %v", err)
+	}
+
+	now = now.Add(3 * time.Minute)
+	if err := l.check(key, "192.0.2.1"); err != nil {
+		t.Errorf("check() after the lockout error = %v", err)
+	}
+
+	// After ResetAfter the counter starts over
+	now = now.Add(2 * time.Hour)
+	if err := l.fail(key, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.check(key, "192.0.2.1"); err != nil {
+		t.Errorf("check() after reset error = %v", err)
+	}
+
+	if err := l.succeed(key); err != nil {
+		t.Fatal(err)
+	}
+	if record, _ := l.store.Get(key); record.Failures != 0 {
+		t.Errorf("Failures after succeed() = %d, want 0", record.Failures)
+	}
+}
+
+func Test_loginLimiter_IPLimit(t *testing.T) {
+	l := newTestLimiter()
+
+	// Spraying one password over many names locks the address
+	for i := 0; i < testLockoutPolicy.MaxIPFailures; i++ {
+		if err := l.fail(userLockoutKey(models.User{}, string(rune('a'+i))), "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var lockedErr *LockedOutError
+	fresh := userLockoutKey(models.User{}, "fresh")
+	if err := l.check(fresh, "192.0.2.1"); !errors.As(err, &lockedErr) {
+		t.Errorf("check() error = %v, want LockedOutError", err)
+	}
+	if err := l.check(fresh, "198.51.100.7"); err != nil {
+		t.Errorf("check() from another address error = %v", err)
+	}
+}
+
+func Test_userService_Login_Lockout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	hashed, _ := utils.HashPassword("secret")
+	s := &userService{db: mkdb, keys: testKeys, lockout: newTestLimiter(), hasher: testHasher}
+
+	// Unknown and existing users are locked out alike
+	for _, name := range []string{"nobody", "rrm"} {
+		if name == "nobody" {
+			mkdb.EXPECT().First(gomock.Any(), "username = ?", name).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(testLockoutPolicy.MaxFailures + 1)
+		} else {
+			mkdb.EXPECT().First(gomock.Any(), "username = ?", name).SetArg(0, models.User{Model: gorm.Model{ID: 1}, Username: name, Password: hashed}).Return(&gorm.DB{}).Times(testLockoutPolicy.MaxFailures + 1)
+		}
+
+		for i := 0; i < testLockoutPolicy.MaxFailures; i++ {
+			if _, err := s.Login(name, "wrong", "192.0.2.1"); err == nil {
+				t.Fatalf("userService.Login(%s) with a wrong password succeeded", name)
+			}
+		}
+
+		// Locked out before the password is checked, even with the right one
+		var lockedErr *LockedOutError
+		if _, err := s.Login(name, "secret", "192.0.2.1"); !errors.As(err, &lockedErr) {
+			t.Errorf("userService.Login(%s) error = %v, want LockedOutError", name, err)
+		}
+	}
+}
+
+func Test_userService_Login_SuccessClearsFailures(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	hashed, _ := utils.HashPassword("secret")
+	s := &userService{db: mkdb, keys: testKeys, lockout: newTestLimiter(), hasher: testHasher}
+	user := models.User{Username: "rrm", Password: hashed}
+
+	mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, user).Return(&gorm.DB{}).AnyTimes()
+	mkdb.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).AnyTimes()
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < testLockoutPolicy.MaxFailures-1; i++ {
+			s.Login("rrm", "wrong", "192.0.2.1")
+		}
+		if _, err := s.Login("rrm", "secret", "192.0.2.1"); err != nil {
+			t.Fatalf("userService.Login() round %d error = %v", round, err)
+		}
+	}
+}
+
+func Test_userService_Login_UsernameAndEmailShareFailures(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	hashed, _ := utils.HashPassword("secret")
+	s := &userService{db: mkdb, keys: testKeys, lockout: newTestLimiter(), hasher: testHasher}
+	user := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Email: "rrm@example.com", Password: hashed}
+
+	mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, user).Return(&gorm.DB{}).AnyTimes()
+	mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm@example.com").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).AnyTimes()
+	mkdb.EXPECT().First(gomock.Any(), "email = ?", "rrm@example.com").SetArg(0, user).Return(&gorm.DB{}).AnyTimes()
+
+	for i := 0; i < testLockoutPolicy.MaxFailures; i++ {
+		login := "rrm"
+		if i%2 == 1 {
+			login = "rrm@example.com"
+		}
+		s.Login(login, "wrong", "192.0.2.1")
+	}
+
+	var lockedErr *LockedOutError
+	for _, login := range []string{"rrm", "rrm@example.com"} {
+		if _, err := s.Login(login, "secret", "198.51.100.7"); !errors.As(err, &lockedErr) {
+			t.Errorf("userService.Login(%s) error = %v, want LockedOutError", login, err)
+		}
+	}
+}
+
+func Test_userService_Login_PasswordAloneKeepsFailures(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	hashed, _ := utils.HashPassword("secret")
+	s := &userService{db: mkdb, keys: testKeys, lockout: newTestLimiter(), hasher: testHasher, requireVerifiedEmail: true}
+	user := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Password: hashed}
+
+	mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, user).Return(&gorm.DB{}).AnyTimes()
+
+	for i := 0; i < testLockoutPolicy.MaxFailures-1; i++ {
+		s.Login("rrm", "wrong", "192.0.2.1")
+	}
+	if _, err := s.Login("rrm", "secret", "192.0.2.1"); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("userService.Login() error = %v, want %v", err, ErrEmailNotVerified)
+	}
+
+	// The right password without a verified email did not reset the counter
+	s.Login("rrm", "wrong", "192.0.2.1")
+	var lockedErr *LockedOutError
+	if _, err := s.Login("rrm", "secret", "192.0.2.1"); !errors.As(err, &lockedErr) {
+		t.Errorf("userService.Login() error = %v, want LockedOutError", err)
+	}
+}
+
+func Test_userService_UnlockUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	s := &userService{db: mkdb, lockout: newTestLimiter(), hasher: testHasher}
+	user := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Email: "rrm@example.com"}
+	for i := 0; i < testLockoutPolicy.MaxFailures; i++ {
+		s.lockout.fail(userLockoutKey(user, "rrm"), "192.0.2.1")
+	}
+
+	mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, user).Return(&gorm.DB{}).Times(1)
+	if err := s.UnlockUser("1"); err != nil {
+		t.Fatalf("userService.UnlockUser() error = %v", err)
+	}
+
+	if err := s.lockout.check(userLockoutKey(user, "rrm"), "198.51.100.7"); err != nil {
+		t.Errorf("check() after unlock error = %v", err)
+	}
+
+	mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(2)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+	if err := s.UnlockUser("2"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("userService.UnlockUser() error = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
@@ -5,8 +5,11 @@
 package services
 
 import (
+	context "context"
+	auth "go-rest-api/auth"
 	models "go-rest-api/models"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -34,18 +37,120 @@ func (m *MockUserService) EXPECT() *MockUserServiceMockRecorder {
 	return m.recorder
 }
 
+// ChangePassword mocks base method.
+func (m *MockUserService) ChangePassword(ctx context.Context, id, currentPassword, newPassword string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChangePassword", ctx, id, currentPassword, newPassword)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ChangePassword indicates an expected call of ChangePassword.
+func (mr *MockUserServiceMockRecorder) ChangePassword(ctx, id, currentPassword, newPassword interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangePassword", reflect.TypeOf((*MockUserService)(nil).ChangePassword), ctx, id, currentPassword, newPassword)
+}
+
+// CompleteOIDCLogin mocks base method.
+func (m *MockUserService) CompleteOIDCLogin(ctx context.Context, provider, stateToken, state, code string) (models.TokenPair, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompleteOIDCLogin", ctx, provider, stateToken, state, code)
+	ret0, _ := ret[0].(models.TokenPair)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CompleteOIDCLogin indicates an expected call of CompleteOIDCLogin.
+func (mr *MockUserServiceMockRecorder) CompleteOIDCLogin(ctx, provider, stateToken, state, code interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteOIDCLogin", reflect.TypeOf((*MockUserService)(nil).CompleteOIDCLogin), ctx, provider, stateToken, state, code)
+}
+
+// ConfirmTOTP mocks base method.
+func (m *MockUserService) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConfirmTOTP", ctx, code)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConfirmTOTP indicates an expected call of ConfirmTOTP.
+func (mr *MockUserServiceMockRecorder) ConfirmTOTP(ctx, code interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConfirmTOTP", reflect.TypeOf((*MockUserService)(nil).ConfirmTOTP), ctx, code)
+}
+
+// CreateAPIKey mocks base method.
+func (m *MockUserService) CreateAPIKey(ctx context.Context, name string, scopes []string, expiresAt *time.Time) (models.NewAPIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAPIKey", ctx, name, scopes, expiresAt)
+	ret0, _ := ret[0].(models.NewAPIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateAPIKey indicates an expected call of CreateAPIKey.
+func (mr *MockUserServiceMockRecorder) CreateAPIKey(ctx, name, scopes, expiresAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAPIKey", reflect.TypeOf((*MockUserService)(nil).CreateAPIKey), ctx, name, scopes, expiresAt)
+}
+
 // DeleteUser mocks base method.
-func (m *MockUserService) DeleteUser(id string) error {
+func (m *MockUserService) DeleteUser(ctx context.Context, id string, version uint) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteUser", id)
+	ret := m.ctrl.Call(m, "DeleteUser", ctx, id, version)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteUser indicates an expected call of DeleteUser.
-func (mr *MockUserServiceMockRecorder) DeleteUser(id interface{}) *gomock.Call {
+func (mr *MockUserServiceMockRecorder) DeleteUser(ctx, id, version interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockUserService)(nil).DeleteUser), ctx, id, version)
+}
+
+// DisableTOTP mocks base method.
+func (m *MockUserService) DisableTOTP(ctx context.Context, code, clientIP string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DisableTOTP", ctx, code, clientIP)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DisableTOTP indicates an expected call of DisableTOTP.
+func (mr *MockUserServiceMockRecorder) DisableTOTP(ctx, code, clientIP interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisableTOTP", reflect.TypeOf((*MockUserService)(nil).DisableTOTP), ctx, code, clientIP)
+}
+
+// EnrollTOTP mocks base method.
+func (m *MockUserService) EnrollTOTP(ctx context.Context) (models.TOTPEnrollment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnrollTOTP", ctx)
+	ret0, _ := ret[0].(models.TOTPEnrollment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EnrollTOTP indicates an expected call of EnrollTOTP.
+func (mr *MockUserServiceMockRecorder) EnrollTOTP(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnrollTOTP", reflect.TypeOf((*MockUserService)(nil).EnrollTOTP), ctx)
+}
+
+// ForgotPassword mocks base method.
+func (m *MockUserService) ForgotPassword(login string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForgotPassword", login)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForgotPassword indicates an expected call of ForgotPassword.
+func (mr *MockUserServiceMockRecorder) ForgotPassword(login interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockUserService)(nil).DeleteUser), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForgotPassword", reflect.TypeOf((*MockUserService)(nil).ForgotPassword), login)
 }
 
 // GetCountires mocks base method.
@@ -63,75 +168,368 @@ func (mr *MockUserServiceMockRecorder) GetCountires() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCountires", reflect.TypeOf((*MockUserService)(nil).GetCountires))
 }
 
+// GetCurrentUser mocks base method.
+func (m *MockUserService) GetCurrentUser(ctx context.Context) (models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCurrentUser", ctx)
+	ret0, _ := ret[0].(models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCurrentUser indicates an expected call of GetCurrentUser.
+func (mr *MockUserServiceMockRecorder) GetCurrentUser(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCurrentUser", reflect.TypeOf((*MockUserService)(nil).GetCurrentUser), ctx)
+}
+
 // GetUser mocks base method.
-func (m *MockUserService) GetUser(id string) (models.User, error) {
+func (m *MockUserService) GetUser(ctx context.Context, id string) (models.User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUser", id)
+	ret := m.ctrl.Call(m, "GetUser", ctx, id)
 	ret0, _ := ret[0].(models.User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetUser indicates an expected call of GetUser.
-func (mr *MockUserServiceMockRecorder) GetUser(id interface{}) *gomock.Call {
+func (mr *MockUserServiceMockRecorder) GetUser(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockUserService)(nil).GetUser), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockUserService)(nil).GetUser), ctx, id)
 }
 
 // GetUsers mocks base method.
-func (m *MockUserService) GetUsers() ([]models.User, error) {
+func (m *MockUserService) GetUsers(query models.UserQuery) (models.UserPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUsers")
-	ret0, _ := ret[0].([]models.User)
+	ret := m.ctrl.Call(m, "GetUsers", query)
+	ret0, _ := ret[0].(models.UserPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetUsers indicates an expected call of GetUsers.
-func (mr *MockUserServiceMockRecorder) GetUsers() *gomock.Call {
+func (mr *MockUserServiceMockRecorder) GetUsers(query interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsers", reflect.TypeOf((*MockUserService)(nil).GetUsers))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsers", reflect.TypeOf((*MockUserService)(nil).GetUsers), query)
+}
+
+// IntrospectToken mocks base method.
+func (m *MockUserService) IntrospectToken(token string) (models.TokenIntrospection, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IntrospectToken", token)
+	ret0, _ := ret[0].(models.TokenIntrospection)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IntrospectToken indicates an expected call of IntrospectToken.
+func (mr *MockUserServiceMockRecorder) IntrospectToken(token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IntrospectToken", reflect.TypeOf((*MockUserService)(nil).IntrospectToken), token)
+}
+
+// ListAPIKeys mocks base method.
+func (m *MockUserService) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAPIKeys", ctx)
+	ret0, _ := ret[0].([]models.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAPIKeys indicates an expected call of ListAPIKeys.
+func (mr *MockUserServiceMockRecorder) ListAPIKeys(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAPIKeys", reflect.TypeOf((*MockUserService)(nil).ListAPIKeys), ctx)
+}
+
+// ListIdentities mocks base method.
+func (m *MockUserService) ListIdentities(ctx context.Context) ([]models.UserIdentity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListIdentities", ctx)
+	ret0, _ := ret[0].([]models.UserIdentity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListIdentities indicates an expected call of ListIdentities.
+func (mr *MockUserServiceMockRecorder) ListIdentities(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListIdentities", reflect.TypeOf((*MockUserService)(nil).ListIdentities), ctx)
 }
 
 // Login mocks base method.
-func (m *MockUserService) Login(username, password string) (string, error) {
+func (m *MockUserService) Login(login, password, clientIP string) (models.TokenPair, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Login", username, password)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Login", login, password, clientIP)
+	ret0, _ := ret[0].(models.TokenPair)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Login indicates an expected call of Login.
-func (mr *MockUserServiceMockRecorder) Login(username, password interface{}) *gomock.Call {
+func (mr *MockUserServiceMockRecorder) Login(login, password, clientIP interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockUserService)(nil).Login), username, password)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockUserService)(nil).Login), login, password, clientIP)
 }
 
-// SignUp mocks base method.
-func (m *MockUserService) SignUp(user models.User) error {
+// LoginMFA mocks base method.
+func (m *MockUserService) LoginMFA(mfaToken, code, clientIP string) (models.TokenPair, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SignUp", user)
+	ret := m.ctrl.Call(m, "LoginMFA", mfaToken, code, clientIP)
+	ret0, _ := ret[0].(models.TokenPair)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoginMFA indicates an expected call of LoginMFA.
+func (mr *MockUserServiceMockRecorder) LoginMFA(mfaToken, code, clientIP interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoginMFA", reflect.TypeOf((*MockUserService)(nil).LoginMFA), mfaToken, code, clientIP)
+}
+
+// Logout mocks base method.
+func (m *MockUserService) Logout(claims *auth.Claims, refreshToken string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Logout", claims, refreshToken)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
+// Logout indicates an expected call of Logout.
+func (mr *MockUserServiceMockRecorder) Logout(claims, refreshToken interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Logout", reflect.TypeOf((*MockUserService)(nil).Logout), claims, refreshToken)
+}
+
+// PurgeDeletedUsers mocks base method.
+func (m *MockUserService) PurgeDeletedUsers(before time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeDeletedUsers", before)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeDeletedUsers indicates an expected call of PurgeDeletedUsers.
+func (mr *MockUserServiceMockRecorder) PurgeDeletedUsers(before interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeDeletedUsers", reflect.TypeOf((*MockUserService)(nil).PurgeDeletedUsers), before)
+}
+
+// PurgeUser mocks base method.
+func (m *MockUserService) PurgeUser(id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeUser", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PurgeUser indicates an expected call of PurgeUser.
+func (mr *MockUserServiceMockRecorder) PurgeUser(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeUser", reflect.TypeOf((*MockUserService)(nil).PurgeUser), id)
+}
+
+// RefreshToken mocks base method.
+func (m *MockUserService) RefreshToken(refreshToken string) (models.TokenPair, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshToken", refreshToken)
+	ret0, _ := ret[0].(models.TokenPair)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefreshToken indicates an expected call of RefreshToken.
+func (mr *MockUserServiceMockRecorder) RefreshToken(refreshToken interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshToken", reflect.TypeOf((*MockUserService)(nil).RefreshToken), refreshToken)
+}
+
+// ResendVerification mocks base method.
+func (m *MockUserService) ResendVerification(email string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResendVerification", email)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResendVerification indicates an expected call of ResendVerification.
+func (mr *MockUserServiceMockRecorder) ResendVerification(email interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResendVerification", reflect.TypeOf((*MockUserService)(nil).ResendVerification), email)
+}
+
+// ResetPassword mocks base method.
+func (m *MockUserService) ResetPassword(token, newPassword string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetPassword", token, newPassword)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetPassword indicates an expected call of ResetPassword.
+func (mr *MockUserServiceMockRecorder) ResetPassword(token, newPassword interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetPassword", reflect.TypeOf((*MockUserService)(nil).ResetPassword), token, newPassword)
+}
+
+// RestoreUser mocks base method.
+func (m *MockUserService) RestoreUser(id string) (models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreUser", id)
+	ret0, _ := ret[0].(models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreUser indicates an expected call of RestoreUser.
+func (mr *MockUserServiceMockRecorder) RestoreUser(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreUser", reflect.TypeOf((*MockUserService)(nil).RestoreUser), id)
+}
+
+// RevokeAPIKey mocks base method.
+func (m *MockUserService) RevokeAPIKey(ctx context.Context, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAPIKey", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeAPIKey indicates an expected call of RevokeAPIKey.
+func (mr *MockUserServiceMockRecorder) RevokeAPIKey(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAPIKey", reflect.TypeOf((*MockUserService)(nil).RevokeAPIKey), ctx, id)
+}
+
+// RevokeAllSessions mocks base method.
+func (m *MockUserService) RevokeAllSessions(id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAllSessions", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeAllSessions indicates an expected call of RevokeAllSessions.
+func (mr *MockUserServiceMockRecorder) RevokeAllSessions(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAllSessions", reflect.TypeOf((*MockUserService)(nil).RevokeAllSessions), id)
+}
+
+// SignUp mocks base method.
+func (m *MockUserService) SignUp(user models.User) (models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SignUp", user)
+	ret0, _ := ret[0].(models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
 // SignUp indicates an expected call of SignUp.
 func (mr *MockUserServiceMockRecorder) SignUp(user interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SignUp", reflect.TypeOf((*MockUserService)(nil).SignUp), user)
 }
 
-// UpdateUser mocks base method.
-func (m *MockUserService) UpdateUser(id string, user models.User) error {
+// StartOIDCLogin mocks base method.
+func (m *MockUserService) StartOIDCLogin(ctx context.Context, provider string, link bool) (models.OIDCRedirect, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StartOIDCLogin", ctx, provider, link)
+	ret0, _ := ret[0].(models.OIDCRedirect)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StartOIDCLogin indicates an expected call of StartOIDCLogin.
+func (mr *MockUserServiceMockRecorder) StartOIDCLogin(ctx, provider, link interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartOIDCLogin", reflect.TypeOf((*MockUserService)(nil).StartOIDCLogin), ctx, provider, link)
+}
+
+// UnlinkIdentity mocks base method.
+func (m *MockUserService) UnlinkIdentity(ctx context.Context, id string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateUser", id, user)
+	ret := m.ctrl.Call(m, "UnlinkIdentity", ctx, id)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
+// UnlinkIdentity indicates an expected call of UnlinkIdentity.
+func (mr *MockUserServiceMockRecorder) UnlinkIdentity(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnlinkIdentity", reflect.TypeOf((*MockUserService)(nil).UnlinkIdentity), ctx, id)
+}
+
+// UnlockUser mocks base method.
+func (m *MockUserService) UnlockUser(id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnlockUser", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnlockUser indicates an expected call of UnlockUser.
+func (mr *MockUserServiceMockRecorder) UnlockUser(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnlockUser", reflect.TypeOf((*MockUserService)(nil).UnlockUser), id)
+}
+
+// UpdateUser mocks base method.
+func (m *MockUserService) UpdateUser(ctx context.Context, id string, user models.User) (models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUser", ctx, id, user)
+	ret0, _ := ret[0].(models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
 // UpdateUser indicates an expected call of UpdateUser.
-func (mr *MockUserServiceMockRecorder) UpdateUser(id, user interface{}) *gomock.Call {
+func (mr *MockUserServiceMockRecorder) UpdateUser(ctx, id, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUser", reflect.TypeOf((*MockUserService)(nil).UpdateUser), ctx, id, user)
+}
+
+// ValidateAPIKey mocks base method.
+func (m *MockUserService) ValidateAPIKey(key string) (auth.Principal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ValidateAPIKey", key)
+	ret0, _ := ret[0].(auth.Principal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ValidateAPIKey indicates an expected call of ValidateAPIKey.
+func (mr *MockUserServiceMockRecorder) ValidateAPIKey(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateAPIKey", reflect.TypeOf((*MockUserService)(nil).ValidateAPIKey), key)
+}
+
+// ValidateToken mocks base method.
+func (m *MockUserService) ValidateToken(tokenStr string) (*auth.Claims, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ValidateToken", tokenStr)
+	ret0, _ := ret[0].(*auth.Claims)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ValidateToken indicates an expected call of ValidateToken.
+func (mr *MockUserServiceMockRecorder) ValidateToken(tokenStr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateToken", reflect.TypeOf((*MockUserService)(nil).ValidateToken), tokenStr)
+}
+
+// VerifyEmail mocks base method.
+func (m *MockUserService) VerifyEmail(token string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyEmail", token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// VerifyEmail indicates an expected call of VerifyEmail.
+func (mr *MockUserServiceMockRecorder) VerifyEmail(token interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUser", reflect.TypeOf((*MockUserService)(nil).UpdateUser), id, user)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyEmail", reflect.TypeOf((*MockUserService)(nil).VerifyEmail), token)
 }
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"go-rest-api/database"
+	"go-rest-api/models"
+	"time"
+)
+
+// RevocationStore keeps track of access tokens that were revoked before their expiry
+type RevocationStore interface {
+	Revoke(jti string, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+}
+
+type revocationStore struct {
+	db database.Database
+}
+
+func NewRevocationStore(db database.Database) RevocationStore {
+	return &revocationStore{db: db}
+}
+
+func (r *revocationStore) Revoke(jti string, expiresAt time.Time) error {
+	// Entries for tokens that have expired on their own are no longer needed
+	if err := r.db.Delete(&models.RevokedToken{}, "expires_at < ?", time.Now()).Error; err != nil {
+		return err
+	}
+
+	return r.db.Create(&models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (r *revocationStore) IsRevoked(jti string) (bool, error) {
+	var revoked []models.RevokedToken
+	if err := r.db.Find(&revoked, "jti = ?", jti).Error; err != nil {
+		return false, err
+	}
+
+	return len(revoked) > 0, nil
+}
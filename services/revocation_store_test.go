@@ -0,0 +1,100 @@
+package services
+
+import (
+	"fmt"
+	mockDB "go-rest-api/database/mocks"
+	"go-rest-api/models"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+)
+
+func Test_revocationStore_Revoke(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	tests := []struct {
+		name    string
+		setup   func(*mockDB.MockDatabase)
+		wantErr bool
+	}{
+		{
+			name: "success",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Delete(gomock.Any(), "expires_at < ?", gomock.Any()).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: false,
+		},
+		{
+			name: "failure pruning expired entries",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Delete(gomock.Any(), "expires_at < ?", gomock.Any()).Return(&gorm.DB{Error: fmt.Errorf("delete error")}).Times(1)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRevocationStore(mkdb)
+
+			tt.setup(mkdb)
+
+			if err := r.Revoke("jti-1", time.Now().Add(time.Minute)); (err != nil) != tt.wantErr {
+				t.Errorf("revocationStore.Revoke() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_revocationStore_IsRevoked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	tests := []struct {
+		name    string
+		setup   func(*mockDB.MockDatabase)
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "revoked",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Find(gomock.Any(), "jti = ?", "jti-1").SetArg(0, []models.RevokedToken{{JTI: "jti-1"}}).Return(&gorm.DB{}).Times(1)
+			},
+			want: true,
+		},
+		{
+			name: "not revoked",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Find(gomock.Any(), "jti = ?", "jti-1").Return(&gorm.DB{}).Times(1)
+			},
+			want: false,
+		},
+		{
+			name: "query error",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Find(gomock.Any(), "jti = ?", "jti-1").Return(&gorm.DB{Error: fmt.Errorf("query error")}).Times(1)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRevocationStore(mkdb)
+
+			tt.setup(mkdb)
+
+			got, err := r.IsRevoked("jti-1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("revocationStore.IsRevoked() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("revocationStore.IsRevoked() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,13 +1,45 @@
 package services
 
-import "go-rest-api/models"
+import (
+	"context"
+	"go-rest-api/auth"
+	"go-rest-api/models"
+	"time"
+)
 
 type UserService interface {
-	SignUp(user models.User) error
-	Login(username, password string) (string, error)
-	GetUsers() ([]models.User, error)
-	GetUser(id string) (models.User, error)
-	UpdateUser(id string, user models.User) error
-	DeleteUser(id string) error
+	SignUp(user models.User) (models.User, error)
+	Login(login, password, clientIP string) (models.TokenPair, error)
+	RefreshToken(refreshToken string) (models.TokenPair, error)
+	ValidateToken(tokenStr string) (*auth.Claims, error)
+	Logout(claims *auth.Claims, refreshToken string) error
+	RevokeAllSessions(id string) error
+	GetUsers(query models.UserQuery) (models.UserPage, error)
+	GetUser(ctx context.Context, id string) (models.User, error)
+	GetCurrentUser(ctx context.Context) (models.User, error)
+	UpdateUser(ctx context.Context, id string, user models.User) (models.User, error)
+	DeleteUser(ctx context.Context, id string, version uint) error
+	RestoreUser(id string) (models.User, error)
+	PurgeUser(id string) error
+	PurgeDeletedUsers(before time.Time) (int64, error)
+	ChangePassword(ctx context.Context, id string, currentPassword, newPassword string) error
+	ForgotPassword(login string) error
+	ResetPassword(token, newPassword string) error
+	VerifyEmail(token string) error
+	ResendVerification(email string) error
+	EnrollTOTP(ctx context.Context) (models.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, code string) ([]string, error)
+	DisableTOTP(ctx context.Context, code, clientIP string) error
+	LoginMFA(mfaToken, code, clientIP string) (models.TokenPair, error)
+	UnlockUser(id string) error
+	CreateAPIKey(ctx context.Context, name string, scopes []string, expiresAt *time.Time) (models.NewAPIKey, error)
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	ValidateAPIKey(key string) (auth.Principal, error)
+	IntrospectToken(token string) (models.TokenIntrospection, error)
+	StartOIDCLogin(ctx context.Context, provider string, link bool) (models.OIDCRedirect, error)
+	CompleteOIDCLogin(ctx context.Context, provider, stateToken, state, code string) (models.TokenPair, error)
+	ListIdentities(ctx context.Context) ([]models.UserIdentity, error)
+	UnlinkIdentity(ctx context.Context, id string) error
 	GetCountires() ([]string, error)
 }
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"go-rest-api/auth"
+	"go-rest-api/models"
+	"go-rest-api/utils"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyPrefix = "gra_"
+	// apiKeyTouchInterval limits how often LastUsedAt is written
+	apiKeyTouchInterval = time.Minute
+)
+
+// CreateAPIKey creates a named API key for the caller in ctx. Scopes must be
+// permissions the caller's role grants; a key without scopes can do nothing
+// but identify its owner, even reading their own record takes profile:read.
+// The key itself is only returned here. API keys cannot be used to create
+// further keys.
+func (s *userService) CreateAPIKey(ctx context.Context, name string, scopes []string, expiresAt *time.Time) (models.NewAPIKey, error) {
+	if err := requireLogin(ctx, "create API key"); err != nil {
+		return models.NewAPIKey{}, err
+	}
+
+	user, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return models.NewAPIKey{}, err
+	}
+
+	for _, scope := range scopes {
+		if !auth.IsPermission(scope) {
+			return models.NewAPIKey{}, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+		if !auth.HasPermission(user.Role, scope) {
+			return models.NewAPIKey{}, &AuthorizationError{Action: "create API key", Reason: "role does not grant " + scope}
+		}
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return models.NewAPIKey{}, ErrInvalidExpiry
+	}
+
+	prefix, err := utils.GenerateRandomToken(6)
+	if err != nil {
+		return models.NewAPIKey{}, err
+	}
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return models.NewAPIKey{}, err
+	}
+	prefix = apiKeyPrefix + prefix
+	key := prefix + "." + secret
+
+	stored := models.APIKey{
+		UserID:    user.ID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   utils.HashToken(key),
+		Scopes:    models.Scopes(append([]string{}, scopes...)),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(&stored).Error; err != nil {
+		return models.NewAPIKey{}, err
+	}
+
+	return models.NewAPIKey{APIKey: stored, Key: key}, nil
+}
+
+// ListAPIKeys returns the caller's API keys that have not been revoked
+func (s *userService) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	user, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []models.APIKey
+	if err := s.db.Find(&keys, "user_id = ? AND revoked_at IS NULL", user.ID).Error; err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes one of the caller's API keys. Keys of other users are
+// reported as not found.
+func (s *userService) RevokeAPIKey(ctx context.Context, id string) error {
+	user, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+	principal, _ := auth.PrincipalFromContext(ctx)
+	if err := authorizeProfile(principal, "revoke API key", auth.PermWriteProfile); err != nil {
+		return err
+	}
+
+	keyID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	var key models.APIKey
+	if err := s.db.First(&key, "id = ? AND user_id = ? AND revoked_at IS NULL", keyID, user.ID).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	key.RevokedAt = &now
+	return s.db.Save(&key).Error
+}
+
+// revokeAPIKeys revokes every API key of a user
+func (s *userService) revokeAPIKeys(userID uint) error {
+	return s.db.UpdateWhere(&models.APIKey{}, map[string]interface{}{"revoked_at": time.Now()}, "user_id = ? AND revoked_at IS NULL", userID).Error
+}
+
+// ValidateAPIKey resolves an API key to the principal of its owner, limited
+// to the key's scopes. Unknown, revoked and expired keys, and keys of deleted
+// users, all return ErrInvalidAPIKey.
+func (s *userService) ValidateAPIKey(key string) (auth.Principal, error) {
+	stored, user, err := s.validateAPIKey(key)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	return apiKeyPrincipal(stored, user), nil
+}
+
+// validateAPIKey loads an API key that is still usable and its owner, and
+// records that it was used
+func (s *userService) validateAPIKey(key string) (models.APIKey, models.User, error) {
+	var stored models.APIKey
+	var user models.User
+	if err := s.db.First(&stored, "key_hash = ?", utils.HashToken(key)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return stored, user, ErrInvalidAPIKey
+		}
+
+		return stored, user, err
+	}
+
+	now := time.Now()
+	if stored.RevokedAt != nil || (stored.ExpiresAt != nil && now.After(*stored.ExpiresAt)) {
+		return stored, user, ErrInvalidAPIKey
+	}
+
+	if err := s.db.First(&user, stored.UserID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return stored, user, ErrInvalidAPIKey
+		}
+
+		return stored, user, err
+	}
+
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= apiKeyTouchInterval {
+		stored.LastUsedAt = &now
+		if err := s.db.Save(&stored).Error; err != nil {
+			log.Printf("Failed to record use of API key %d: %v", stored.ID, err)
+		}
+	}
+
+	return stored, user, nil
+}
+
+func apiKeyPrincipal(key models.APIKey, user models.User) auth.Principal {
+	return auth.Principal{
+		Username: user.Username,
+		Role:     user.Role,
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+	}
+}
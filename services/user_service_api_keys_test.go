@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go-rest-api/auth"
+	mockDB "go-rest-api/database/mocks"
+	"go-rest-api/models"
+	"go-rest-api/utils"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+)
+
+func Test_userService_CreateAPIKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	owner := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Role: auth.RoleUser}
+	admin := models.User{Model: gorm.Model{ID: 2}, Username: "admin", Role: auth.RoleAdmin}
+	future := time.Now().Add(24 * time.Hour)
+	past := time.Now().Add(-time.Minute)
+	keyCtx := auth.WithPrincipal(context.Background(), auth.Principal{Username: "rrm", Role: auth.RoleUser, APIKeyID: 5})
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		scopes    []string
+		expiresAt *time.Time
+		setup     func(*mockDB.MockDatabase)
+		wantErr   bool
+		errCheck  func(error) bool
+	}{
+		{
+			name:      "success",
+			ctx:       adminCtx,
+			scopes:    []string{auth.PermListUsers},
+			expiresAt: &future,
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "admin").SetArg(0, admin).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Create(gomock.Any()).DoAndReturn(func(value interface{}) *gorm.DB {
+					stored := value.(*models.APIKey)
+					if stored.UserID != 2 || stored.Name != "batch" || !reflect.DeepEqual([]string(stored.Scopes), []string{auth.PermListUsers}) {
+						t.Errorf("unexpected stored key %+v", stored)
+					}
+					return &gorm.DB{}
+				}).Times(1)
+			},
+		},
+		{
+			name:   "scope not granted by role",
+			ctx:    ownerCtx,
+			scopes: []string{auth.PermListUsers},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, owner).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr:  true,
+			errCheck: func(err error) bool { var authErr *AuthorizationError; return errors.As(err, &authErr) },
+		},
+		{
+			name:   "unknown scope",
+			ctx:    adminCtx,
+			scopes: []string{"users:everything"},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "admin").SetArg(0, admin).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr:  true,
+			errCheck: func(err error) bool { return errors.Is(err, ErrInvalidScope) },
+		},
+		{
+			name:      "expiry in the past",
+			ctx:       ownerCtx,
+			expiresAt: &past,
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, owner).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr:  true,
+			errCheck: func(err error) bool { return errors.Is(err, ErrInvalidExpiry) },
+		},
+		{
+			name:     "called with an API key",
+			ctx:      keyCtx,
+			setup:    func(md *mockDB.MockDatabase) {},
+			wantErr:  true,
+			errCheck: func(err error) bool { var authErr *AuthorizationError; return errors.As(err, &authErr) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{db: mkdb}
+
+			tt.setup(mkdb)
+
+			got, err := s.CreateAPIKey(tt.ctx, "batch", tt.scopes, tt.expiresAt)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("userService.CreateAPIKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !tt.errCheck(err) {
+					t.Errorf("userService.CreateAPIKey() error = %v of unexpected type", err)
+				}
+				return
+			}
+
+			if !strings.HasPrefix(got.Key, got.Prefix+".") || !strings.HasPrefix(got.Prefix, apiKeyPrefix) {
+				t.Errorf("key %q does not start with its prefix %q", got.Key, got.Prefix)
+			}
+			if got.KeyHash != utils.HashToken(got.Key) {
+				t.Errorf("stored hash does not match the key")
+			}
+		})
+	}
+}
+
+func Test_userService_ListAPIKeys(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	keys := []models.APIKey{{ID: 3, UserID: 1, Name: "batch", Prefix: "gra_abcdefgh"}}
+	mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Model: gorm.Model{ID: 1}, Username: "rrm"}).Return(&gorm.DB{}).Times(1)
+	mkdb.EXPECT().Find(gomock.Any(), "user_id = ? AND revoked_at IS NULL", uint(1)).SetArg(0, keys).Return(&gorm.DB{}).Times(1)
+
+	s := &userService{db: mkdb}
+	got, err := s.ListAPIKeys(ownerCtx)
+	if err != nil {
+		t.Fatalf("userService.ListAPIKeys() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, keys) {
+		t.Errorf("userService.ListAPIKeys() = %v, want %v", got, keys)
+	}
+}
+
+func Test_userService_RevokeAPIKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	s := &userService{db: mkdb}
+	owner := models.User{Model: gorm.Model{ID: 1}, Username: "rrm"}
+
+	t.Run("success", func(t *testing.T) {
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, owner).Return(&gorm.DB{}).Times(1)
+		mkdb.EXPECT().First(gomock.Any(), "id = ? AND user_id = ? AND revoked_at IS NULL", uint(3), uint(1)).SetArg(0, models.APIKey{ID: 3, UserID: 1}).Return(&gorm.DB{}).Times(1)
+		mkdb.EXPECT().Save(gomock.Any()).DoAndReturn(func(value interface{}) *gorm.DB {
+			if value.(*models.APIKey).RevokedAt == nil {
+				t.Errorf("API key was not marked as revoked")
+			}
+			return &gorm.DB{}
+		}).Times(1)
+
+		if err := s.RevokeAPIKey(ownerCtx, "3"); err != nil {
+			t.Errorf("userService.RevokeAPIKey() error = %v", err)
+		}
+	})
+
+	t.Run("key of another user", func(t *testing.T) {
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, owner).Return(&gorm.DB{}).Times(1)
+		mkdb.EXPECT().First(gomock.Any(), "id = ? AND user_id = ? AND revoked_at IS NULL", uint(4), uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+
+		if err := s.RevokeAPIKey(ownerCtx, "4"); err != gorm.ErrRecordNotFound {
+			t.Errorf("userService.RevokeAPIKey() error = %v, want %v", err, gorm.ErrRecordNotFound)
+		}
+	})
+
+	t.Run("id that is not a number", func(t *testing.T) {
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, owner).Return(&gorm.DB{}).Times(1)
+
+		if err := s.RevokeAPIKey(ownerCtx, "3 OR 1=1"); err != gorm.ErrRecordNotFound {
+			t.Errorf("userService.RevokeAPIKey() error = %v, want %v", err, gorm.ErrRecordNotFound)
+		}
+	})
+}
+
+func Test_userService_ValidateAPIKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	keyHash := utils.HashToken("gra_abcdefgh.secret")
+	owner := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Role: auth.RoleAdmin}
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	recent := time.Now().Add(-time.Second)
+
+	tests := []struct {
+		name    string
+		setup   func(*mockDB.MockDatabase)
+		want    auth.Principal
+		wantErr error
+	}{
+		{
+			name: "success",
+			setup: func(md *mockDB.MockDatabase) {
+				stored := models.APIKey{ID: 3, UserID: 1, KeyHash: keyHash, Scopes: models.Scopes{auth.PermListUsers}, ExpiresAt: &future}
+				md.EXPECT().First(gomock.Any(), "key_hash = ?", keyHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), uint(1)).SetArg(0, owner).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Save(gomock.Any()).DoAndReturn(func(value interface{}) *gorm.DB {
+					if value.(*models.APIKey).LastUsedAt == nil {
+						t.Errorf("LastUsedAt was not recorded")
+					}
+					return &gorm.DB{}
+				}).Times(1)
+			},
+			want: auth.Principal{Username: "rrm", Role: auth.RoleAdmin, APIKeyID: 3, Scopes: []string{auth.PermListUsers}},
+		},
+		{
+			name: "recently used key is not written again",
+			setup: func(md *mockDB.MockDatabase) {
+				stored := models.APIKey{ID: 3, UserID: 1, KeyHash: keyHash, Scopes: models.Scopes{}, LastUsedAt: &recent}
+				md.EXPECT().First(gomock.Any(), "key_hash = ?", keyHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), uint(1)).SetArg(0, owner).Return(&gorm.DB{}).Times(1)
+			},
+			want: auth.Principal{Username: "rrm", Role: auth.RoleAdmin, APIKeyID: 3, Scopes: []string{}},
+		},
+		{
+			name: "unknown key",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "key_hash = ?", keyHash).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "revoked key",
+			setup: func(md *mockDB.MockDatabase) {
+				stored := models.APIKey{ID: 3, UserID: 1, KeyHash: keyHash, RevokedAt: &past}
+				md.EXPECT().First(gomock.Any(), "key_hash = ?", keyHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "expired key",
+			setup: func(md *mockDB.MockDatabase) {
+				stored := models.APIKey{ID: 3, UserID: 1, KeyHash: keyHash, ExpiresAt: &past}
+				md.EXPECT().First(gomock.Any(), "key_hash = ?", keyHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "owner deleted",
+			setup: func(md *mockDB.MockDatabase) {
+				stored := models.APIKey{ID: 3, UserID: 1, KeyHash: keyHash}
+				md.EXPECT().First(gomock.Any(), "key_hash = ?", keyHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{db: mkdb}
+
+			tt.setup(mkdb)
+
+			got, err := s.ValidateAPIKey("gra_abcdefgh.secret")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("userService.ValidateAPIKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("userService.ValidateAPIKey() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_apiKeyPrincipalScopes(t *testing.T) {
+	// An admin's key only carries the permissions it was scoped to
+	principal := auth.Principal{Username: "admin", Role: auth.RoleAdmin, APIKeyID: 3, Scopes: []string{auth.PermListUsers}}
+
+	if !principal.Can(auth.PermListUsers) {
+		t.Errorf("Can(%s) = false, want true", auth.PermListUsers)
+	}
+	if principal.Can(auth.PermDeleteUsers) {
+		t.Errorf("Can(%s) = true, want false", auth.PermDeleteUsers)
+	}
+
+	target := models.User{Username: "other"}
+	var authErr *AuthorizationError
+	if err := authorizeUserAccess(auth.WithPrincipal(context.Background(), principal), target, "delete user", auth.PermWriteProfile, auth.PermDeleteUsers); !errors.As(err, &authErr) {
+		t.Errorf("authorizeUserAccess() error = %v, want AuthorizationError", err)
+	}
+}
+
+func Test_apiKeyProfileScopes(t *testing.T) {
+	own := models.User{Username: "rrm"}
+	readOnly := auth.Principal{Username: "rrm", Role: auth.RoleUser, APIKeyID: 3, Scopes: []string{auth.PermReadProfile}}
+
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		ownPerm   string
+		wantErr   bool
+	}{
+		{name: "login reads own record", principal: auth.Principal{Username: "rrm", Role: auth.RoleUser}, ownPerm: auth.PermReadProfile},
+		{name: "login writes own record", principal: auth.Principal{Username: "rrm", Role: auth.RoleUser}, ownPerm: auth.PermWriteProfile},
+		{name: "scoped key reads own record", principal: readOnly, ownPerm: auth.PermReadProfile},
+		{name: "scoped key cannot write own record", principal: readOnly, ownPerm: auth.PermWriteProfile, wantErr: true},
+		{name: "key without scopes cannot read own record", principal: auth.Principal{Username: "rrm", Role: auth.RoleUser, APIKeyID: 3}, ownPerm: auth.PermReadProfile, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorizeUserAccess(auth.WithPrincipal(context.Background(), tt.principal), own, "update user", tt.ownPerm, auth.PermManageUsers)
+			var authErr *AuthorizationError
+			if tt.wantErr != errors.As(err, &authErr) || (!tt.wantErr && err != nil) {
+				t.Errorf("authorizeUserAccess() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_apiKeysNeedLogin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	// Even a key with every scope; nothing reaches the database
+	keyCtx := auth.WithPrincipal(context.Background(), auth.Principal{
+		Username: "rrm",
+		Role:     auth.RoleUser,
+		APIKeyID: 5,
+		Scopes:   []string{auth.PermReadProfile, auth.PermWriteProfile},
+	})
+	s, _ := newOIDCTestService(t, mkdb, false, false)
+	mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Model: gorm.Model{ID: 1}, Username: "rrm"}).Return(&gorm.DB{}).AnyTimes()
+
+	calls := map[string]func() error{
+		"EnrollTOTP":  func() error { _, err := s.EnrollTOTP(keyCtx); return err },
+		"ConfirmTOTP": func() error { _, err := s.ConfirmTOTP(keyCtx, "123456"); return err },
+		"DisableTOTP": func() error { return s.DisableTOTP(keyCtx, "123456", "192.0.2.1") },
+		"StartOIDCLogin link": func() error {
+			_, err := s.StartOIDCLogin(keyCtx, "test", true)
+			return err
+		},
+		"UnlinkIdentity": func() error { return s.UnlinkIdentity(keyCtx, "1") },
+		"ChangePassword": func() error { return s.ChangePassword(keyCtx, "1", "old", "new-password") },
+		"DeleteUser":     func() error { return s.DeleteUser(keyCtx, "1", 0) },
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			var authErr *AuthorizationError
+			if err := call(); !errors.As(err, &authErr) {
+				t.Errorf("%s() with an API key error = %v, want AuthorizationError", name, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"go-rest-api/models"
+	"log"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeletedUserPolicy decides what happens to users after they are deleted.
+// Deleted users keep their row until they are purged, so that they can be
+// restored.
+type DeletedUserPolicy struct {
+	// Retention is how long deleted users are kept before RunUserPurge
+	// purges them. They are kept until purged by an admin when it is 0.
+	Retention time.Duration
+	// FreeUsernames lets others sign up with the username of a deleted
+	// user, which then cannot be restored until the name is free again. By
+	// default usernames stay reserved until the user is purged, so that
+	// nobody can pose as a deleted user.
+	FreeUsernames bool
+}
+
+// LoadDeletedUserPolicy reads the policy from DELETED_USER_RETENTION, a
+// duration such as "720h", and DELETED_USERNAMES, "reserve" (the default) or
+// "free"
+func LoadDeletedUserPolicy() (DeletedUserPolicy, error) {
+	var policy DeletedUserPolicy
+
+	if v := os.Getenv("DELETED_USER_RETENTION"); v != "" {
+		retention, err := time.ParseDuration(v)
+		if err != nil || retention < 0 {
+			return policy, fmt.Errorf("invalid DELETED_USER_RETENTION %q", v)
+		}
+		policy.Retention = retention
+	}
+
+	switch v := os.Getenv("DELETED_USERNAMES"); v {
+	case "", "reserve":
+	case "free":
+		policy.FreeUsernames = true
+	default:
+		return policy, fmt.Errorf("invalid DELETED_USERNAMES %q, want reserve or free", v)
+	}
+
+	return policy, nil
+}
+
+// usernameTaken reports whether username belongs to a user, or to a deleted
+// one while the policy reserves their usernames
+func (s *userService) usernameTaken(username string) (bool, error) {
+	db := s.db
+	if !s.deletedUsers.FreeUsernames {
+		db = s.db.Unscoped()
+	}
+
+	var existing models.User
+	err := db.First(&existing, "username = ?", username).Error
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// deletedUser loads a user that has been deleted but not purged
+func (s *userService) deletedUser(id string) (models.User, error) {
+	var user models.User
+	userID, err := parseID(id)
+	if err != nil {
+		return user, err
+	}
+
+	err = s.db.Unscoped().First(&user, "id = ? AND deleted_at IS NOT NULL", userID).Error
+	return user, err
+}
+
+// RestoreUser undoes the deletion of a user and returns the restored record.
+// ErrUsernameTaken is returned when the policy freed the username and
+// someone else has taken it since.
+func (s *userService) RestoreUser(id string) (models.User, error) {
+	user, err := s.deletedUser(id)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if s.deletedUsers.FreeUsernames {
+		taken, err := s.usernameTaken(user.Username)
+		if err != nil {
+			return models.User{}, err
+		}
+		if taken {
+			return models.User{}, ErrUsernameTaken
+		}
+	}
+
+	// Only restore the row while it is still deleted; Save would insert it
+	// again if it was purged in the meantime
+	values := map[string]interface{}{"deleted_at": nil, "version": gorm.Expr("version + 1")}
+	result := s.db.Unscoped().UpdateWhere(&user, values, "deleted_at IS NOT NULL")
+	if result.Error != nil {
+		return models.User{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.User{}, gorm.ErrRecordNotFound
+	}
+
+	// Read it back for the version it is at now
+	return s.userByID(id)
+}
+
+// PurgeUser removes a deleted user for good, together with their sessions,
+// API keys and linked identities. Users have to be deleted first;
+// gorm.ErrRecordNotFound is returned for anyone else.
+func (s *userService) PurgeUser(id string) error {
+	user, err := s.deletedUser(id)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Unscoped().Delete(&user).Error
+}
+
+// PurgeDeletedUsers removes the users deleted before the given time for good
+// and returns how many there were
+func (s *userService) PurgeDeletedUsers(before time.Time) (int64, error) {
+	result := s.db.Unscoped().Delete(&models.User{}, "deleted_at < ?", before)
+	return result.RowsAffected, result.Error
+}
+
+// RunUserPurge purges the users deleted longer than retention ago, right away
+// and then every interval, until ctx is done
+func RunUserPurge(ctx context.Context, users UserService, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := users.PurgeDeletedUsers(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Failed to purge deleted users: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d users deleted more than %s ago", purged, retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"errors"
+	mockDB "go-rest-api/database/mocks"
+	"go-rest-api/models"
+	"go-rest-api/utils"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+)
+
+func TestLoadDeletedUserPolicy(t *testing.T) {
+	t.Setenv("DELETED_USER_RETENTION", "720h")
+	t.Setenv("DELETED_USERNAMES", "free")
+
+	policy, err := LoadDeletedUserPolicy()
+	if err != nil {
+		t.Fatalf("LoadDeletedUserPolicy() error = %v", err)
+	}
+	if policy.Retention != 720*time.Hour || !policy.FreeUsernames {
+		t.Errorf("LoadDeletedUserPolicy() = %+v, want 720h and free usernames", policy)
+	}
+
+	for name, value := range map[string]string{"DELETED_USER_RETENTION": "30d", "DELETED_USERNAMES": "keep"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := LoadDeletedUserPolicy(); err == nil {
+				t.Errorf("LoadDeletedUserPolicy() with %s=%s error = nil", name, value)
+			}
+		})
+	}
+}
+
+func Test_userService_usernameTaken(t *testing.T) {
+	t.Run("reserved for deleted users", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mkdb := mockDB.NewMockDatabase(ctrl)
+		s := &userService{db: mkdb}
+
+		mkdb.EXPECT().Unscoped().Return(mkdb).Times(1)
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").Return(&gorm.DB{}).Times(1)
+
+		if taken, err := s.usernameTaken("rrm"); err != nil || !taken {
+			t.Errorf("userService.usernameTaken() = %v, %v, want true", taken, err)
+		}
+	})
+
+	t.Run("freed by the policy", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mkdb := mockDB.NewMockDatabase(ctrl)
+		s := &userService{db: mkdb, deletedUsers: DeletedUserPolicy{FreeUsernames: true}}
+
+		// Only users that have not been deleted are looked at
+		mkdb.EXPECT().Unscoped().Times(0)
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+
+		if taken, err := s.usernameTaken("rrm"); err != nil || taken {
+			t.Errorf("userService.usernameTaken() = %v, %v, want false", taken, err)
+		}
+	})
+}
+
+func Test_userService_RestoreUser(t *testing.T) {
+	deleted := models.User{Model: gorm.Model{ID: 1, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}, Username: "rrm"}
+
+	tests := []struct {
+		name          string
+		freeUsernames bool
+		setup         func(*mockDB.MockDatabase)
+		wantErr       error
+	}{
+		{
+			name: "restored",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Unscoped().Return(md).Times(2)
+				md.EXPECT().First(gomock.Any(), "id = ? AND deleted_at IS NOT NULL", uint(1)).SetArg(0, deleted).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "deleted_at IS NOT NULL").DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+					if v, ok := values["deleted_at"]; !ok || v != nil {
+						t.Errorf("deleted_at = %v, want it cleared", v)
+					}
+					return &gorm.DB{RowsAffected: 1}
+				}).Times(1)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Version: 3}).Return(&gorm.DB{}).Times(1)
+			},
+		},
+		{
+			name: "purged meanwhile",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Unscoped().Return(md).Times(2)
+				md.EXPECT().First(gomock.Any(), "id = ? AND deleted_at IS NOT NULL", uint(1)).SetArg(0, deleted).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "deleted_at IS NOT NULL").Return(&gorm.DB{RowsAffected: 0}).Times(1)
+			},
+			wantErr: gorm.ErrRecordNotFound,
+		},
+		{
+			name: "not deleted",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Unscoped().Return(md).Times(1)
+				md.EXPECT().First(gomock.Any(), "id = ? AND deleted_at IS NOT NULL", uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: gorm.ErrRecordNotFound,
+		},
+		{
+			name:          "freed username is still free",
+			freeUsernames: true,
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Unscoped().Return(md).Times(2)
+				md.EXPECT().First(gomock.Any(), "id = ? AND deleted_at IS NOT NULL", uint(1)).SetArg(0, deleted).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "deleted_at IS NOT NULL").Return(&gorm.DB{RowsAffected: 1}).Times(1)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Model: gorm.Model{ID: 1}, Username: "rrm"}).Return(&gorm.DB{}).Times(1)
+			},
+		},
+		{
+			name:          "freed username was taken",
+			freeUsernames: true,
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Unscoped().Return(md).Times(1)
+				md.EXPECT().First(gomock.Any(), "id = ? AND deleted_at IS NOT NULL", uint(1)).SetArg(0, deleted).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Model: gorm.Model{ID: 2}, Username: "rrm"}).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+			},
+			wantErr: ErrUsernameTaken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mkdb := mockDB.NewMockDatabase(ctrl)
+			s := &userService{db: mkdb, deletedUsers: DeletedUserPolicy{FreeUsernames: tt.freeUsernames}}
+
+			tt.setup(mkdb)
+
+			got, err := s.RestoreUser("1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("userService.RestoreUser() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (got.ID != 1 || got.DeletedAt.Valid) {
+				t.Errorf("userService.RestoreUser() = %+v, want the restored user", got)
+			}
+		})
+	}
+}
+
+func Test_userService_RestoreUser_SessionsStayEnded(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+	s := &userService{db: mkdb, keys: testKeys}
+
+	user := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Version: 3}
+	token := models.RefreshToken{UserID: 1, FamilyID: "family", TokenHash: utils.HashToken("refresh"), ExpiresAt: time.Now().Add(time.Hour)}
+
+	// Deleting revokes the refresh token
+	var stored models.RefreshToken
+	mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, user).Return(&gorm.DB{}).Times(1)
+	mkdb.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "version = ?", uint(3)).Return(&gorm.DB{RowsAffected: 1}).Times(1)
+	mkdb.EXPECT().Find(gomock.Any(), "user_id = ? AND revoked_at IS NULL", uint(1)).SetArg(0, []models.RefreshToken{token}).Return(&gorm.DB{}).Times(1)
+	mkdb.EXPECT().Save(gomock.Any()).DoAndReturn(func(value interface{}) *gorm.DB {
+		stored = *value.(*models.RefreshToken)
+		return &gorm.DB{}
+	}).Times(1)
+	mkdb.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "user_id = ? AND revoked_at IS NULL", uint(1)).Return(&gorm.DB{}).Times(1)
+
+	if err := s.DeleteUser(ownerCtx, "1", 0); err != nil {
+		t.Fatalf("userService.DeleteUser() error = %v", err)
+	}
+
+	deleted := user
+	deleted.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	mkdb.EXPECT().Unscoped().Return(mkdb).Times(2)
+	mkdb.EXPECT().First(gomock.Any(), "id = ? AND deleted_at IS NOT NULL", uint(1)).SetArg(0, deleted).Return(&gorm.DB{}).Times(1)
+	mkdb.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "deleted_at IS NOT NULL").Return(&gorm.DB{RowsAffected: 1}).Times(1)
+	mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, user).Return(&gorm.DB{}).Times(1)
+
+	if _, err := s.RestoreUser("1"); err != nil {
+		t.Fatalf("userService.RestoreUser() error = %v", err)
+	}
+
+	// The token from before the deletion does not work again
+	mkdb.EXPECT().First(gomock.Any(), "token_hash = ?", utils.HashToken("refresh")).DoAndReturn(func(dest interface{}, conds ...interface{}) *gorm.DB {
+		*dest.(*models.RefreshToken) = stored
+		return &gorm.DB{}
+	}).Times(1)
+	mkdb.EXPECT().Find(gomock.Any(), "family_id = ? AND revoked_at IS NULL", "family").Return(&gorm.DB{}).Times(1)
+	mkdb.EXPECT().Create(gomock.Any()).Times(0)
+
+	if _, err := s.RefreshToken("refresh"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("userService.RefreshToken() after restore error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+}
+
+func Test_userService_PurgeUser(t *testing.T) {
+	t.Run("deleted user", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mkdb := mockDB.NewMockDatabase(ctrl)
+		s := &userService{db: mkdb}
+
+		deleted := models.User{Model: gorm.Model{ID: 1, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}}
+		mkdb.EXPECT().Unscoped().Return(mkdb).Times(2)
+		mkdb.EXPECT().First(gomock.Any(), "id = ? AND deleted_at IS NOT NULL", uint(1)).SetArg(0, deleted).Return(&gorm.DB{}).Times(1)
+		mkdb.EXPECT().Delete(gomock.Any()).Return(&gorm.DB{}).Times(1)
+
+		if err := s.PurgeUser("1"); err != nil {
+			t.Errorf("userService.PurgeUser() error = %v", err)
+		}
+	})
+
+	t.Run("user that was not deleted", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mkdb := mockDB.NewMockDatabase(ctrl)
+		s := &userService{db: mkdb}
+
+		mkdb.EXPECT().Unscoped().Return(mkdb).Times(1)
+		mkdb.EXPECT().First(gomock.Any(), "id = ? AND deleted_at IS NOT NULL", uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+		mkdb.EXPECT().Delete(gomock.Any()).Times(0)
+
+		if err := s.PurgeUser("1"); err != gorm.ErrRecordNotFound {
+			t.Errorf("userService.PurgeUser() error = %v, want ErrRecordNotFound", err)
+		}
+	})
+
+	t.Run("id that is not a number", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mkdb := mockDB.NewMockDatabase(ctrl)
+		s := &userService{db: mkdb}
+
+		if err := s.PurgeUser("1 OR 1=1"); err != gorm.ErrRecordNotFound {
+			t.Errorf("userService.PurgeUser() error = %v, want ErrRecordNotFound", err)
+		}
+	})
+}
+
+func Test_userService_PurgeDeletedUsers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+	s := &userService{db: mkdb}
+
+	before := time.Now().Add(-30 * 24 * time.Hour)
+	mkdb.EXPECT().Unscoped().Return(mkdb).Times(1)
+	mkdb.EXPECT().Delete(gomock.Any(), "deleted_at < ?", before).Return(&gorm.DB{RowsAffected: 2}).Times(1)
+
+	purged, err := s.PurgeDeletedUsers(before)
+	if err != nil || purged != 2 {
+		t.Errorf("userService.PurgeDeletedUsers() = %d, %v, want 2", purged, err)
+	}
+}
+
+// purgeRecorder is a UserService that only records the purges RunUserPurge
+// asks for
+type purgeRecorder struct {
+	UserService
+	before chan time.Time
+}
+
+func (p *purgeRecorder) PurgeDeletedUsers(before time.Time) (int64, error) {
+	p.before <- before
+	return 0, nil
+}
+
+func TestRunUserPurge(t *testing.T) {
+	recorder := &purgeRecorder{before: make(chan time.Time)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunUserPurge(ctx, recorder, time.Hour, time.Millisecond)
+		close(done)
+	}()
+
+	// Right away and then on every tick
+	for i := 0; i < 2; i++ {
+		select {
+		case before := <-recorder.before:
+			if age := time.Since(before); age < time.Hour || age > time.Hour+time.Minute {
+				t.Errorf("purged users deleted before %s ago, want 1h", age)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("purge %d did not run", i+1)
+		}
+	}
+
+	cancel()
+	// Ticks may race with the cancellation
+	for {
+		select {
+		case <-recorder.before:
+		case <-done:
+			return
+		}
+	}
+}
@@ -1,118 +1,1040 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"go-rest-api/auth"
 	"go-rest-api/database"
+	"go-rest-api/mail"
 	"go-rest-api/models"
+	"go-rest-api/oidc"
 	"go-rest-api/utils"
+	"log"
+	netmail "net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"gorm.io/gorm"
 )
 
-var jwtKey = []byte("secret_key")
+const (
+	accessTokenTTL  = 5 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+	resetTokenTTL   = time.Hour
+	verifyTokenTTL  = 24 * time.Hour
+)
 
-type userService struct {
-	db database.Database
+// Config holds the optional settings of the user service
+type Config struct {
+	// Mailer delivers password reset links. Messages are logged when nil.
+	Mailer mail.Mailer
+	// PasswordResetURL is the page that completes a reset; the token is
+	// appended as the "token" query parameter. Only the token is mailed when
+	// it is empty.
+	PasswordResetURL string
+	// EmailVerificationURL is the page that confirms an email address; the
+	// token is appended as the "token" query parameter.
+	EmailVerificationURL string
+	// RequireVerifiedEmail rejects logins until the user has verified their
+	// email address.
+	RequireVerifiedEmail bool
+	// TOTPIssuer names the service in authenticator apps. Defaults to
+	// "go-rest-api".
+	TOTPIssuer string
+	// LockoutStore keeps failed login counters. Defaults to an in-memory
+	// store.
+	LockoutStore LockoutStore
+	// Lockout throttles failed logins. DefaultLockoutPolicy is used when it
+	// is empty.
+	Lockout LockoutPolicy
+	// PasswordPolicy is enforced on sign up, password change and reset.
+	// auth.DefaultPasswordPolicy is used when it is nil.
+	PasswordPolicy *auth.PasswordPolicy
+	// PasswordHasher hashes new passwords. Older hashes are upgraded to it on
+	// the next successful login. Defaults to bcrypt with the default cost.
+	PasswordHasher utils.PasswordHasher
+	// Claims sets the issuer and audience of access tokens and the clock
+	// skew tolerated when validating them
+	Claims auth.ClaimsConfig
+	// OIDCProviders are the external providers users can sign in with
+	OIDCProviders []oidc.ProviderConfig
+	// DeletedUsers decides how long deleted users are kept and whether
+	// their usernames can be taken meanwhile
+	DeletedUsers DeletedUserPolicy
 }
 
-type Claims struct {
-	Username string `json:"username"`
-	jwt.StandardClaims
+type userService struct {
+	db          database.Database
+	keys        *auth.KeySet
+	revocations RevocationStore
+	mailer      mail.Mailer
+	resetURL    string
+	verifyURL   string
+	// requireVerifiedEmail blocks Login for users without a verified email
+	requireVerifiedEmail bool
+	totpIssuer           string
+	lockout              *loginLimiter
+	passwordPolicy       auth.PasswordPolicy
+	hasher               utils.PasswordHasher
+	claims               auth.ClaimsConfig
+	oidcProviders        map[string]*oidc.Provider
+	deletedUsers         DeletedUserPolicy
+
+	dummyHashOnce sync.Once
+	dummyHash     string
+	// mails counts the mails still being sent in the background
+	mails sync.WaitGroup
 }
 
-func NewUserService(db database.Database) UserService {
-	return &userService{db: db}
+func NewUserService(db database.Database, keys *auth.KeySet, cfg Config) UserService {
+	mailer := cfg.Mailer
+	if mailer == nil {
+		mailer = mail.NewLogMailer(nil)
+	}
+	totpIssuer := cfg.TOTPIssuer
+	if totpIssuer == "" {
+		totpIssuer = "go-rest-api"
+	}
+	policy := cfg.Lockout
+	if policy == (LockoutPolicy{}) {
+		policy = DefaultLockoutPolicy
+	}
+	lockoutStore := cfg.LockoutStore
+	if lockoutStore == nil {
+		lockoutStore = NewMemoryLockoutStore(policy.ResetAfter)
+	}
+	passwordPolicy := auth.DefaultPasswordPolicy
+	if cfg.PasswordPolicy != nil {
+		passwordPolicy = *cfg.PasswordPolicy
+	}
+	hasher := cfg.PasswordHasher
+	if hasher == nil {
+		hasher = utils.DefaultBcryptHasher
+	}
+	if _, ok := hasher.(utils.BcryptHasher); ok {
+		passwordPolicy = passwordPolicy.ForBcrypt()
+	}
+	oidcProviders := make(map[string]*oidc.Provider, len(cfg.OIDCProviders))
+	for _, provider := range cfg.OIDCProviders {
+		oidcProviders[provider.Name] = oidc.NewProvider(provider, nil)
+	}
+
+	return &userService{
+		db:          db,
+		keys:        keys,
+		revocations: NewRevocationStore(db),
+		mailer:      mailer,
+		resetURL:    cfg.PasswordResetURL,
+		verifyURL:   cfg.EmailVerificationURL,
+
+		requireVerifiedEmail: cfg.RequireVerifiedEmail,
+		totpIssuer:           totpIssuer,
+		lockout:              newLoginLimiter(lockoutStore, policy),
+		passwordPolicy:       passwordPolicy,
+		hasher:               hasher,
+		claims:               cfg.Claims,
+		oidcProviders:        oidcProviders,
+		deletedUsers:         cfg.DeletedUsers,
+	}
 }
 
-func (s *userService) SignUp(user models.User) error {
+// SignUp creates a user and returns the stored record
+func (s *userService) SignUp(user models.User) (models.User, error) {
+	// Roles are never taken from the client; admins are promoted explicitly
+	user.Role = auth.RoleUser
+	// Addresses are only verified through VerifyEmail
+	user.EmailVerifiedAt = nil
+
+	if err := s.passwordPolicy.Validate(user.Password, user.Username); err != nil {
+		return models.User{}, err
+	}
+
 	var err error
-	user.Password, err = utils.HashPassword(user.Password)
+	user.Email, err = normalizeEmail(user.Email)
 	if err != nil {
-		return errors.New("failed to encrypt")
+		return models.User{}, err
+	}
+	if err := s.ensureEmailAvailable(user.Email, 0); err != nil {
+		return models.User{}, err
+	}
+	taken, err := s.usernameTaken(user.Username)
+	if err != nil {
+		return models.User{}, err
+	}
+	if taken {
+		return models.User{}, ErrUsernameTaken
+	}
+
+	user.Password, err = s.hasher.Hash(user.Password)
+	if err != nil {
+		return models.User{}, errors.New("failed to encrypt")
+	}
+
+	if err := s.db.Create(&user).Error; err != nil {
+		return models.User{}, err
+	}
+
+	if user.Email != "" {
+		s.sendVerification(user)
+	}
+
+	return user, nil
+}
+
+// Login authenticates a user by username or email address and password. Users
+// with two-factor authentication get an MFARequiredError to finish with
+// LoginMFA instead of tokens. Failed attempts are counted per login name and
+// per client address; while either is locked out a LockedOutError is returned.
+func (s *userService) Login(login, password, clientIP string) (models.TokenPair, error) {
+	user, err := s.findByLogin(login)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return models.TokenPair{}, err
+	}
+
+	lockoutKey := userLockoutKey(user, login)
+	if err := s.lockout.check(lockoutKey, clientIP); err != nil {
+		return models.TokenPair{}, err
+	}
+
+	if err == gorm.ErrRecordNotFound {
+		// Compare against a dummy hash so that unknown names take as long
+		// as wrong passwords, and count them the same way so that lockouts
+		// do not reveal which users exist either
+		utils.CheckPasswordHash(password, s.dummyPasswordHash())
+		return models.TokenPair{}, s.loginFailed(lockoutKey, login, clientIP, "unknown user")
+	}
+
+	if !utils.CheckPasswordHash(password, user.Password) {
+		return models.TokenPair{}, s.loginFailed(lockoutKey, login, clientIP, "wrong password")
+	}
+	s.upgradePasswordHash(&user, password)
+
+	if s.requireVerifiedEmail && user.EmailVerifiedAt == nil {
+		return models.TokenPair{}, ErrEmailNotVerified
+	}
+
+	// The failures are cleared by LoginMFA once the second factor is
+	// verified too
+	if user.TOTPEnabled {
+		return models.TokenPair{}, s.mfaChallenge(user)
+	}
+
+	if err := s.lockout.succeed(lockoutKey); err != nil {
+		return models.TokenPair{}, err
+	}
+
+	familyID, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	return s.issueTokens(user, familyID)
+}
+
+// loginFailed logs why a login failed, counts the failure under lockoutKey
+// and returns the uniform error the caller sees
+func (s *userService) loginFailed(lockoutKey, login, clientIP, reason string) error {
+	log.Printf("Login failed for %q from %s: %s", login, clientIP, reason)
+
+	if err := s.lockout.fail(lockoutKey, clientIP); err != nil {
+		return err
+	}
+	return ErrInvalidCredentials
+}
+
+// dummyPasswordHash returns a hash with the same algorithm and cost as new
+// password hashes, to compare against when a user does not exist
+func (s *userService) dummyPasswordHash() string {
+	s.dummyHashOnce.Do(func() {
+		s.dummyHash, _ = s.hasher.Hash("dummy password for timing")
+	})
+	return s.dummyHash
+}
+
+// upgradePasswordHash re-hashes a verified password when its stored hash was
+// made with another algorithm or other parameters than the current hasher.
+// Failures are only logged; the old hash keeps working.
+func (s *userService) upgradePasswordHash(user *models.User, password string) {
+	if !s.hasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashed, err := s.hasher.Hash(password)
+	if err != nil {
+		log.Printf("Failed to re-hash password of user %d: %v", user.ID, err)
+		return
+	}
+
+	if err := s.updateUser(user, map[string]interface{}{"password": hashed}); err != nil {
+		log.Printf("Failed to store re-hashed password of user %d: %v", user.ID, err)
+	}
+}
+
+// updateUser writes the columns in values of user and moves it to the next
+// version. Unlike Save it leaves the other columns alone, so that it does not
+// undo concurrent changes to them.
+func (s *userService) updateUser(user *models.User, values map[string]interface{}) error {
+	return s.updateUserWhere(user, values, "id = ?", user.ID).Error
+}
+
+// updateUserWhere is updateUser that only writes while the condition holds;
+// RowsAffected is 0 otherwise
+func (s *userService) updateUserWhere(user *models.User, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+	values["version"] = gorm.Expr("version + 1")
+	return s.db.UpdateWhere(user, values, query, args...)
+}
+
+// findByLogin looks a user up by username and falls back to the email
+// address, so a username never loses to someone else's email.
+func (s *userService) findByLogin(login string) (models.User, error) {
+	var user models.User
+	err := s.db.First(&user, "username = ?", login).Error
+	if err != gorm.ErrRecordNotFound || !strings.Contains(login, "@") {
+		return user, err
 	}
 
-	return s.db.Create(&user).Error
+	user = models.User{}
+	err = s.db.First(&user, "email = ?", strings.ToLower(strings.TrimSpace(login))).Error
+	return user, err
 }
 
-func (s *userService) Login(username, password string) (string, error) {
+// RefreshToken rotates a refresh token: the presented token is revoked and a
+// new access/refresh pair from the same family is returned. Presenting a token
+// that was already rotated revokes every token in its family.
+func (s *userService) RefreshToken(refreshToken string) (models.TokenPair, error) {
+	stored, user, err := s.validateRefreshToken(refreshToken)
+	if err == ErrRefreshTokenReused {
+		if err := s.revokeTokenFamily(stored.FamilyID); err != nil {
+			return models.TokenPair{}, err
+		}
+
+		return models.TokenPair{}, ErrRefreshTokenReused
+	}
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	// Only one of concurrent requests with the same token gets to rotate it;
+	// the others are reuse
+	now := time.Now()
+	result := s.db.UpdateWhere(&stored, map[string]interface{}{"revoked_at": now}, "revoked_at IS NULL")
+	if result.Error != nil {
+		return models.TokenPair{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		if err := s.revokeTokenFamily(stored.FamilyID); err != nil {
+			return models.TokenPair{}, err
+		}
+
+		return models.TokenPair{}, ErrRefreshTokenReused
+	}
+
+	return s.issueTokens(user, stored.FamilyID)
+}
+
+// validateRefreshToken loads a refresh token that can still be exchanged and
+// its owner. A token that was already rotated returns ErrRefreshTokenReused.
+func (s *userService) validateRefreshToken(refreshToken string) (models.RefreshToken, models.User, error) {
+	var stored models.RefreshToken
 	var user models.User
-	if err := s.db.Where("username = ?", username).First(&user).Error; err != nil {
+	if err := s.db.First(&stored, "token_hash = ?", utils.HashToken(refreshToken)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return stored, user, ErrInvalidRefreshToken
+		}
+
+		return stored, user, err
+	}
+
+	if stored.RevokedAt != nil {
+		return stored, user, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return stored, user, ErrInvalidRefreshToken
+	}
+
+	if err := s.db.First(&user, stored.UserID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return "", errors.New("invalid credentials: username")
+			return stored, user, ErrInvalidRefreshToken
 		}
 
-		return "", err
+		return stored, user, err
 	}
 
-	if utils.CheckPasswordHash(user.Password, password) {
-		return "", errors.New("invalid credentials: password")
+	return stored, user, nil
+}
+
+// issueTokens mints an access JWT and stores a new refresh token in the given family
+func (s *userService) issueTokens(user models.User, familyID string) (models.TokenPair, error) {
+	jti, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return models.TokenPair{}, err
 	}
 
-	// Create JWT token
-	expirationTime := time.Now().Add(5 * time.Minute)
-	claims := &Claims{
-		Username: username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
+	now := time.Now()
+	claims := &auth.Claims{
+		Username:     user.Username,
+		Role:         user.Role,
+		TokenVersion: user.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    s.claims.Issuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  s.claims.AudienceFor(user.Role),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := s.keys.Sign(claims)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	refreshToken, err := utils.GenerateRandomToken(32)
 	if err != nil {
-		return "", err
+		return models.TokenPair{}, err
+	}
+
+	record := models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(refreshToken),
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+	if err := s.db.Create(&record).Error; err != nil {
+		return models.TokenPair{}, err
+	}
+
+	return models.TokenPair{
+		AccessToken:  tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// revokeTokenFamily revokes every refresh token that is still active in the family
+func (s *userService) revokeTokenFamily(familyID string) error {
+	return s.revokeRefreshTokens("family_id = ? AND revoked_at IS NULL", familyID)
+}
+
+// revokeRefreshTokens revokes every refresh token matching the given condition
+func (s *userService) revokeRefreshTokens(query string, args ...interface{}) error {
+	var tokens []models.RefreshToken
+	if err := s.db.Find(&tokens, append([]interface{}{query}, args...)...).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range tokens {
+		tokens[i].RevokedAt = &now
+		if err := s.db.Save(&tokens[i]).Error; err != nil {
+			return err
+		}
 	}
 
-	return tokenString, nil
+	return nil
 }
 
-func (s *userService) GetUsers() ([]models.User, error) {
-	var users []models.User
-	if err := s.db.Find(&users).Error; err != nil {
+// ValidateToken verifies the signature and the registered claims of an access
+// token and checks that it was neither revoked individually nor by a session
+// reset.
+func (s *userService) ValidateToken(tokenStr string) (*auth.Claims, error) {
+	claims := &auth.Claims{}
+	token, err := s.keys.ParseSignature(tokenStr, claims)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	if err := s.claims.Validate(&claims.RegisteredClaims, time.Now()); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// Verification and mfa pending tokens are signed with the same keys but
+	// carry no username
+	if claims.Username == "" {
+		return nil, ErrInvalidToken
+	}
+
+	revoked, err := s.revocations.IsRevoked(claims.ID)
+	if err != nil {
 		return nil, err
 	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	user, err := s.userByID(claims.Subject)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrInvalidToken
+		}
+
+		return nil, err
+	}
+
+	if user.TokenVersion != claims.TokenVersion {
+		return nil, ErrTokenRevoked
+	}
+
+	// Role changes apply immediately instead of when the token expires
+	claims.Username = user.Username
+	claims.Role = user.Role
+
+	return claims, nil
+}
+
+// Logout revokes the access token described by claims and, when given, the
+// refresh token family it was issued with.
+func (s *userService) Logout(claims *auth.Claims, refreshToken string) error {
+	expiresAt := time.Now().Add(accessTokenTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	if err := s.revocations.Revoke(claims.ID, expiresAt); err != nil {
+		return err
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	var stored models.RefreshToken
+	if err := s.db.First(&stored, "token_hash = ?", utils.HashToken(refreshToken)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+
+		return err
+	}
+
+	return s.revokeTokenFamily(stored.FamilyID)
+}
+
+// RevokeAllSessions invalidates every access and refresh token of a user
+func (s *userService) RevokeAllSessions(id string) error {
+	user, err := s.userByID(id)
+	if err != nil {
+		return err
+	}
+
+	return s.invalidateSessions(&user, map[string]interface{}{})
+}
+
+// invalidateSessions writes the columns in values of user with a bumped token
+// version, which rejects every access token issued so far, and revokes all of
+// its refresh tokens.
+func (s *userService) invalidateSessions(user *models.User, values map[string]interface{}) error {
+	values["token_version"] = gorm.Expr("token_version + 1")
+	if err := s.updateUser(user, values); err != nil {
+		return err
+	}
+
+	return s.revokeRefreshTokens("user_id = ? AND revoked_at IS NULL", user.ID)
+}
 
-	return users, nil
+// GetUser returns a user to the user themselves or to callers allowed to list
+// users
+func (s *userService) GetUser(ctx context.Context, id string) (models.User, error) {
+	user, err := s.userByID(id)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if err := authorizeUserAccess(ctx, user, "view user", auth.PermReadProfile, auth.PermListUsers); err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
 }
 
-func (s *userService) GetUser(id string) (models.User, error) {
+// GetCurrentUser returns the record of the authenticated caller in ctx
+func (s *userService) GetCurrentUser(ctx context.Context) (models.User, error) {
 	var user models.User
-	if err := s.db.First(&user, id).Error; err != nil {
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return user, &AuthorizationError{Action: "read current user", Reason: "no authenticated caller"}
+	}
+
+	if err := authorizeProfile(principal, "read current user", auth.PermReadProfile); err != nil {
+		return user, err
+	}
+
+	if err := s.db.First(&user, "username = ?", principal.Username).Error; err != nil {
 		return user, err
 	}
 
 	return user, nil
 }
 
-func (s *userService) UpdateUser(id string, user models.User) error {
-	existing := models.User{}
-	if err := s.db.First(&existing, id).Error; err != nil {
+// UpdateUser replaces the country and email address of a user and returns the
+// stored record. Both are taken as given: an empty email address removes it,
+// while a country cannot be removed once set (users from social login start
+// without one). The username cannot change; it may be left empty. When
+// user.Version is set, the user must still be at that version. A
+// ConflictError is returned otherwise, or when someone else saves the user
+// first.
+func (s *userService) UpdateUser(ctx context.Context, id string, user models.User) (models.User, error) {
+	existing, err := s.userByID(id)
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return fmt.Errorf("user with ID %s not found", id)
+			return models.User{}, fmt.Errorf("user with ID %s not found", id)
 		}
 
-		return err
+		return models.User{}, err
+	}
+
+	if err := authorizeUserAccess(ctx, existing, "update user", auth.PermWriteProfile, auth.PermManageUsers); err != nil {
+		return models.User{}, err
+	}
+	if user.Version != 0 && user.Version != existing.Version {
+		return models.User{}, &ConflictError{Version: existing.Version}
+	}
+	if user.Username != "" && user.Username != existing.Username {
+		return models.User{}, &ReadOnlyFieldError{Field: "username"}
+	}
+	if strings.TrimSpace(user.Country) == "" && existing.Country != "" {
+		return models.User{}, ErrCountryRequired
+	}
+
+	email, err := normalizeEmail(user.Email)
+	if err != nil {
+		return models.User{}, err
+	}
+	emailChanged := email != existing.Email
+	if emailChanged {
+		if err := s.ensureEmailAvailable(email, existing.ID); err != nil {
+			return models.User{}, err
+		}
+		existing.Email = email
+		existing.EmailVerifiedAt = nil
 	}
 
+	// Passwords only change through ChangePassword, which hashes them and
+	// ends existing sessions
 	existing.Country = user.Country
-	existing.Password = user.Password
 
-	if err := s.db.Save(&existing).Error; err != nil {
+	// Saving moves the user to the next version, unless it moved already
+	values := map[string]interface{}{
+		"email":             existing.Email,
+		"email_verified_at": existing.EmailVerifiedAt,
+		"country":           existing.Country,
+	}
+	result := s.updateUserWhere(&existing, values, "version = ?", existing.Version)
+	if result.Error != nil {
+		return models.User{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.User{}, s.conflict(existing.ID)
+	}
+	existing.Version++
+
+	if emailChanged && existing.Email != "" {
+		s.sendVerification(existing)
+	}
+
+	return existing, nil
+}
+
+// DeleteUser deletes a user and ends their sessions and API keys, which stay
+// ended if the user is restored. When version is not 0, the user must still be
+// at that version; a ConflictError is returned otherwise.
+func (s *userService) DeleteUser(ctx context.Context, id string, version uint) error {
+	user, err := s.userByID(id)
+	if err != nil {
+		return err
+	}
+
+	if err := authorizeUserAccess(ctx, user, "delete user", auth.PermWriteProfile, auth.PermDeleteUsers); err != nil {
+		return err
+	}
+	if principal, _ := auth.PrincipalFromContext(ctx); principal.Username == user.Username {
+		if err := requireLogin(ctx, "delete own account"); err != nil {
+			return err
+		}
+	}
+	if version != 0 && version != user.Version {
+		return &ConflictError{Version: user.Version}
+	}
+
+	// Soft delete and invalidate the access tokens in one write, so that
+	// neither happens without the other
+	values := map[string]interface{}{"deleted_at": time.Now(), "token_version": gorm.Expr("token_version + 1")}
+	result := s.updateUserWhere(&user, values, "version = ?", user.Version)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return s.conflict(user.ID)
+	}
+
+	if err := s.revokeRefreshTokens("user_id = ? AND revoked_at IS NULL", user.ID); err != nil {
+		return err
+	}
+	return s.revokeAPIKeys(user.ID)
+}
+
+// conflict reads the current version of a user that a conditional write
+// missed into a ConflictError
+func (s *userService) conflict(id uint) error {
+	var current models.User
+	if err := s.db.First(&current, "id = ?", id).Error; err != nil {
+		return err
+	}
+
+	return &ConflictError{Version: current.Version}
+}
+
+// ChangePassword replaces the password of a user after checking the current
+// one. Every session of the user, including the caller's, is invalidated.
+func (s *userService) ChangePassword(ctx context.Context, id string, currentPassword, newPassword string) error {
+	if err := requireLogin(ctx, "change password"); err != nil {
+		return err
+	}
+
+	user, err := s.userByID(id)
+	if err != nil {
+		return err
+	}
+
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok || principal.Username != user.Username {
+		return &AuthorizationError{Action: "change password", Reason: "only the owner can change a password"}
+	}
+
+	if !utils.CheckPasswordHash(currentPassword, user.Password) {
+		return ErrIncorrectPassword
+	}
+
+	if err := s.passwordPolicy.Validate(newPassword, user.Username); err != nil {
+		return err
+	}
+
+	hashed, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return errors.New("failed to encrypt")
+	}
+	return s.invalidateSessions(&user, map[string]interface{}{"password": hashed})
+}
+
+// ForgotPassword mails a single-use reset token to the user identified by
+// username or email address. Unknown users and users without an email address
+// are ignored so that callers cannot probe which accounts exist.
+func (s *userService) ForgotPassword(login string) error {
+	user, err := s.findByLogin(login)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+
 		return err
 	}
 
+	if user.Email == "" {
+		log.Printf("Password reset requested for user %d without an email address", user.ID)
+		return nil
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	record := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(resetTokenTTL),
+	}
+	if err := s.db.Create(&record).Error; err != nil {
+		return err
+	}
+
+	s.sendMail(user.ID, "password reset", s.resetMessage(user, token))
 	return nil
 }
 
-func (s *userService) DeleteUser(id string) error {
+// sendMail delivers msg to a user in the background. Waiting for the mail
+// server would make requests for existing accounts measurably slower, and a
+// delivery failure is only logged, as reporting it would reveal that the
+// account exists.
+func (s *userService) sendMail(userID uint, kind string, msg mail.Message) {
+	s.mails.Add(1)
+	go func() {
+		defer s.mails.Done()
+		if err := s.mailer.Send(msg); err != nil {
+			log.Printf("Failed to send %s mail to user %d: %v", kind, userID, err)
+		}
+	}()
+}
+
+func (s *userService) resetMessage(user models.User, token string) mail.Message {
+	body := fmt.Sprintf("Hello %s,\n\nUse this token to reset your password within %d minutes:\n\n%s\n",
+		user.Username, int(resetTokenTTL.Minutes()), token)
+	if s.resetURL != "" {
+		body = fmt.Sprintf("Hello %s,\n\nOpen this link within %d minutes to reset your password:\n\n%s?token=%s\n",
+			user.Username, int(resetTokenTTL.Minutes()), s.resetURL, url.QueryEscape(token))
+	}
+	body += "\nIf you did not ask for a password reset you can ignore this message.\n"
+
+	return mail.Message{To: user.Email, Subject: "Reset your password", Body: body}
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword. The
+// token is consumed and every session of the user is invalidated.
+func (s *userService) ResetPassword(token, newPassword string) error {
+	var stored models.PasswordResetToken
+	if err := s.db.First(&stored, "token_hash = ?", utils.HashToken(token)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrInvalidResetToken
+		}
+
+		return err
+	}
+
+	if stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
 	var user models.User
-	if err := s.db.First(&user, id).Error; err != nil {
+	if err := s.db.First(&user, stored.UserID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrInvalidResetToken
+		}
+
 		return err
 	}
-	return s.db.Delete(&user).Error
+
+	// A rejected password leaves the token usable for another attempt
+	if err := s.passwordPolicy.Validate(newPassword, user.Username); err != nil {
+		return err
+	}
+
+	// Only one of concurrent requests with the same token gets to mark it
+	// used and set the password
+	result := s.db.UpdateWhere(&stored, map[string]interface{}{"used_at": time.Now()}, "used_at IS NULL")
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidResetToken
+	}
+
+	hashed, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return errors.New("failed to encrypt")
+	}
+	return s.invalidateSessions(&user, map[string]interface{}{"password": hashed})
+}
+
+// VerifyEmail marks the address in a verification token as verified
+func (s *userService) VerifyEmail(token string) error {
+	claims := &auth.EmailVerificationClaims{}
+	parsed, err := s.keys.Parse(token, claims)
+	if err != nil || !parsed.Valid || !claims.VerifyAudience(auth.PurposeVerifyEmail, true) {
+		return ErrInvalidVerificationToken
+	}
+
+	user, err := s.userByID(claims.Subject)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrInvalidVerificationToken
+		}
+
+		return err
+	}
+
+	// The token was issued for an address the user no longer has
+	if user.Email == "" || user.Email != claims.Email {
+		return ErrInvalidVerificationToken
+	}
+
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	// The address must not have changed since it was read
+	result := s.updateUserWhere(&user, map[string]interface{}{"email_verified_at": time.Now()}, "email = ?", user.Email)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidVerificationToken
+	}
+
+	return nil
+}
+
+// ResendVerification mails a new verification token to an unverified address.
+// Unknown and already verified addresses are ignored so that callers cannot
+// probe which accounts exist.
+func (s *userService) ResendVerification(email string) error {
+	email, err := normalizeEmail(email)
+	if err != nil || email == "" {
+		return nil
+	}
+
+	var user models.User
+	if err := s.db.First(&user, "email = ?", email).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+
+		return err
+	}
+
+	if user.EmailVerifiedAt == nil {
+		s.sendVerification(user)
+	}
+
+	return nil
+}
+
+// sendVerification mails a signed verification token for the user's current
+// email address. Failures are logged; the user can ask for another token.
+func (s *userService) sendVerification(user models.User) {
+	now := time.Now()
+	token, err := s.keys.Sign(&auth.EmailVerificationClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  jwt.ClaimStrings{auth.PurposeVerifyEmail},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(verifyTokenTTL)),
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to sign email verification token for user %d: %v", user.ID, err)
+		return
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\nUse this token to verify your email address within %d hours:\n\n%s\n",
+		user.Username, int(verifyTokenTTL.Hours()), token)
+	if s.verifyURL != "" {
+		body = fmt.Sprintf("Hello %s,\n\nOpen this link within %d hours to verify your email address:\n\n%s?token=%s\n",
+			user.Username, int(verifyTokenTTL.Hours()), s.verifyURL, url.QueryEscape(token))
+	}
+
+	s.sendMail(user.ID, "verification", mail.Message{To: user.Email, Subject: "Verify your email address", Body: body})
+}
+
+// parseID parses the decimal ID of a record from a path parameter or a token
+// subject. Anything else matches no record, so gorm.ErrRecordNotFound is
+// returned for it; passed on as is, GORM would take a string that is not a
+// number as SQL.
+func parseID(id string) (uint, error) {
+	parsed, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, gorm.ErrRecordNotFound
+	}
+	return uint(parsed), nil
+}
+
+// userByID loads a user by the decimal ID from a path parameter or a token
+// subject
+func (s *userService) userByID(id string) (models.User, error) {
+	var user models.User
+	userID, err := parseID(id)
+	if err != nil {
+		return user, err
+	}
+
+	err = s.db.First(&user, "id = ?", userID).Error
+	return user, err
+}
+
+// ensureEmailAvailable fails with ErrEmailTaken when a user other than
+// exceptID already uses email. Deleted users keep their address until they are
+// purged, as the unique index on it covers them too.
+func (s *userService) ensureEmailAvailable(email string, exceptID uint) error {
+	if email == "" {
+		return nil
+	}
+
+	var other models.User
+	if err := s.db.Unscoped().First(&other, "email = ?", email).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+
+		return err
+	}
+
+	if other.ID != exceptID {
+		return ErrEmailTaken
+	}
+
+	return nil
+}
+
+// normalizeEmail validates an optional email address and returns it in the
+// lower case form it is stored in
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", nil
+	}
+
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+
+	return strings.ToLower(email), nil
+}
+
+// UnlockUser clears the failed login counter of a user. Lockouts of client
+// addresses expire on their own.
+func (s *userService) UnlockUser(id string) error {
+	user, err := s.userByID(id)
+	if err != nil {
+		return err
+	}
+
+	return s.lockout.succeed(userLockoutKey(user, ""))
+}
+
+// authorizeUserAccess allows the caller in ctx to act on target when they can
+// use permission, or when target is their own record and they can use
+// ownPermission, one of the profile permissions every role grants. API keys
+// need the permission in their scopes either way.
+func authorizeUserAccess(ctx context.Context, target models.User, action, ownPermission, permission string) error {
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return &AuthorizationError{Action: action, Reason: "no authenticated caller"}
+	}
+
+	if principal.Can(permission) {
+		return nil
+	}
+	if principal.Username != target.Username {
+		return &AuthorizationError{Action: action, Reason: "user belongs to someone else"}
+	}
+
+	return authorizeProfile(principal, action, ownPermission)
+}
+
+// authorizeProfile allows principal to use permission, one of the profile
+// permissions, on their own record. Only API keys can lack it.
+func authorizeProfile(principal auth.Principal, action, permission string) error {
+	if !principal.Can(permission) {
+		return &AuthorizationError{Action: action, Reason: "API key is not scoped to " + permission}
+	}
+	return nil
+}
+
+// requireLogin rejects callers that authenticated with an API key. Managing
+// credentials, second factors and linked identities, or deleting the account,
+// takes a login whatever the key's scopes.
+func requireLogin(ctx context.Context, action string) error {
+	if principal, ok := auth.PrincipalFromContext(ctx); ok && principal.APIKeyID != 0 {
+		return &AuthorizationError{Action: action, Reason: "needs a login, not an API key"}
+	}
+	return nil
 }
 
 func (s *userService) GetCountires() ([]string, error) {
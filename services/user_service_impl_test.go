@@ -1,21 +1,71 @@
 package services
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"go-rest-api/auth"
 	"go-rest-api/database"
 	mockDB "go-rest-api/database/mocks"
+	"go-rest-api/mail"
 	"go-rest-api/models"
+	"go-rest-api/utils"
+	"io"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/golang/mock/gomock"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+var (
+	testSecret  = []byte("secret_key")
+	testKeys, _ = auth.NewKeySet("test", auth.NewHMACKey("test", testSecret))
+
+	ownerCtx = auth.WithPrincipal(context.Background(), auth.Principal{Username: "rrm", Role: auth.RoleUser})
+	otherCtx = auth.WithPrincipal(context.Background(), auth.Principal{Username: "other", Role: auth.RoleUser})
+	adminCtx = auth.WithPrincipal(context.Background(), auth.Principal{Username: "admin", Role: auth.RoleAdmin})
+
+	testHasher = utils.DefaultBcryptHasher
+	// Cheap enough parameters to keep the tests fast
+	fastArgon2id = utils.Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+)
+
+func TestNewUserService_PasswordMaxBytes(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher utils.PasswordHasher
+		want   int
+	}{
+		{name: "bcrypt by default", want: auth.BcryptMaxBytes},
+		{name: "bcrypt", hasher: utils.BcryptHasher{Cost: bcrypt.MinCost}, want: auth.BcryptMaxBytes},
+		{name: "argon2id", hasher: fastArgon2id, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewUserService(nil, testKeys, Config{PasswordHasher: tt.hasher}).(*userService)
+			if got := s.passwordPolicy.MaxBytes; got != tt.want {
+				t.Errorf("passwordPolicy.MaxBytes = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_userService_SignUp(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	mkdb := mockDB.NewMockDatabase(ctrl)
 
+	// Usernames of deleted users are reserved by default
+	expectUsernameFree := func(md *mockDB.MockDatabase) {
+		md.EXPECT().Unscoped().Return(md).Times(1)
+		md.EXPECT().First(gomock.Any(), "username = ?", "rrm").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+	}
+
 	type fields struct {
 		db database.Database
 	}
@@ -42,10 +92,35 @@ func Test_userService_SignUp(t *testing.T) {
 				},
 			},
 			setup: func(mockDB *mockDB.MockDatabase) {
+				expectUsernameFree(mockDB)
 				mockDB.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
 			},
 			wantErr: false,
 		},
+		{
+			name: "Role from client is ignored",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				user: models.User{
+					Username: "rrm",
+					Password: "roeeo",
+					Country:  "india",
+					Role:     auth.RoleAdmin,
+				},
+			},
+			setup: func(mockDB *mockDB.MockDatabase) {
+				expectUsernameFree(mockDB)
+				mockDB.EXPECT().Create(gomock.Any()).DoAndReturn(func(value interface{}) *gorm.DB {
+					if got := value.(*models.User).Role; got != auth.RoleUser {
+						t.Errorf("Role = %s, want %s", got, auth.RoleUser)
+					}
+					return &gorm.DB{}
+				}).Times(1)
+			},
+			wantErr: false,
+		},
 		{
 			name: "Fail signup country empty",
 			fields: fields{
@@ -59,6 +134,7 @@ func Test_userService_SignUp(t *testing.T) {
 				},
 			},
 			setup: func(mockDB *mockDB.MockDatabase) {
+				expectUsernameFree(mockDB)
 				mockDB.EXPECT().Create(gomock.Any()).Return(&gorm.DB{Error: fmt.Errorf("country cannot be empty")}).Times(1)
 			},
 			wantErr: true,
@@ -80,80 +156,159 @@ func Test_userService_SignUp(t *testing.T) {
 			},
 			wantErr: true,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			s := &userService{
-				db: tt.fields.db,
-			}
-
-			if tt.setup != nil {
-				tt.setup(mkdb)
-			}
-
-			if err := s.SignUp(tt.args.user); (err != nil) != tt.wantErr {
-				t.Errorf("userService.SignUp() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
-func Test_userService_GetUsers(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	mkdb := mockDB.NewMockDatabase(ctrl)
-
-	type fields struct {
-		db database.Database
-	}
-
-	tests := []struct {
-		name    string
-		fields  fields
-		setup   func(*mockDB.MockDatabase)
-		want    []models.User
-		wantErr bool
-	}{
 		{
-			name: "success",
+			name: "Signup with email",
 			fields: fields{
 				db: mkdb,
 			},
-			setup: func(md *mockDB.MockDatabase) {
-				mkdb.EXPECT().Find(gomock.Any()).SetArg(0, []models.User{{Username: "user1", Password: "123", Country: "usa"}, {Username: "user2", Password: "12321", Country: "uae"}}).Return(&gorm.DB{}).Times(1)
+			args: args{
+				user: models.User{
+					Username: "rrm",
+					Password: "roeeo",
+					Country:  "india",
+					Email:    " RRM@Example.com ",
+				},
+			},
+			setup: func(mockDB *mockDB.MockDatabase) {
+				mockDB.EXPECT().Unscoped().Return(mockDB).Times(1)
+				mockDB.EXPECT().First(gomock.Any(), "email = ?", "rrm@example.com").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+				expectUsernameFree(mockDB)
+				mockDB.EXPECT().Create(gomock.Any()).DoAndReturn(func(value interface{}) *gorm.DB {
+					if got := value.(*models.User).Email; got != "rrm@example.com" {
+						t.Errorf("Email = %s, want rrm@example.com", got)
+					}
+					return &gorm.DB{}
+				}).Times(1)
 			},
-			want:    []models.User{{Username: "user1", Password: "123", Country: "usa"}, {Username: "user2", Password: "12321", Country: "uae"}},
 			wantErr: false,
 		},
 		{
-			name: "failure: invalid query",
+			name: "Verification state from client is ignored",
 			fields: fields{
 				db: mkdb,
 			},
-			setup: func(md *mockDB.MockDatabase) {
-				mkdb.EXPECT().Find(gomock.Any()).SetArg(0, []models.User{{Username: "user1", Password: "123", Country: "usa"}, {Username: "user2", Password: "12321", Country: "uae"}}).Return(&gorm.DB{Error: fmt.Errorf("invalid query syntax")}).Times(1)
+			args: args{
+				user: models.User{
+					Username:        "rrm",
+					Password:        "roeeo",
+					Country:         "india",
+					Email:           "rrm@example.com",
+					EmailVerifiedAt: &time.Time{},
+				},
+			},
+			setup: func(mockDB *mockDB.MockDatabase) {
+				mockDB.EXPECT().Unscoped().Return(mockDB).Times(1)
+				mockDB.EXPECT().First(gomock.Any(), "email = ?", "rrm@example.com").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+				expectUsernameFree(mockDB)
+				mockDB.EXPECT().Create(gomock.Any()).DoAndReturn(func(value interface{}) *gorm.DB {
+					if value.(*models.User).EmailVerifiedAt != nil {
+						t.Errorf("EmailVerifiedAt was taken from the client")
+					}
+					return &gorm.DB{}
+				}).Times(1)
+			},
+			wantErr: false,
+		},
+		{
+			name: "Fail email taken",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				user: models.User{
+					Username: "rrm",
+					Password: "roeeo",
+					Country:  "india",
+					Email:    "taken@example.com",
+				},
+			},
+			setup: func(mockDB *mockDB.MockDatabase) {
+				other := models.User{Model: gorm.Model{ID: 2}, Email: "taken@example.com"}
+				mockDB.EXPECT().Unscoped().Return(mockDB).Times(1)
+				mockDB.EXPECT().First(gomock.Any(), "email = ?", "taken@example.com").SetArg(0, other).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "Fail username taken",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				user: models.User{
+					Username: "rrm",
+					Password: "roeeo",
+					Country:  "india",
+				},
+			},
+			setup: func(mockDB *mockDB.MockDatabase) {
+				deleted := models.User{Model: gorm.Model{ID: 2, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}, Username: "rrm"}
+				mockDB.EXPECT().Unscoped().Return(mockDB).Times(1)
+				mockDB.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, deleted).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "Fail invalid email",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				user: models.User{
+					Username: "rrm",
+					Password: "roeeo",
+					Country:  "india",
+					Email:    "not-an-email",
+				},
 			},
-
 			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &userService{
-				db: tt.fields.db,
+				db:             tt.fields.db,
+				hasher:         testHasher,
+				passwordPolicy: auth.PasswordPolicy{}.ForBcrypt(),
+				keys:           testKeys,
+				mailer:         mail.NewLogMailer(io.Discard),
 			}
 
-			tt.setup(mkdb)
+			if tt.setup != nil {
+				tt.setup(mkdb)
+			}
 
-			got, err := s.GetUsers()
+			got, err := s.SignUp(tt.args.user)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("userService.GetUsers() error = %v, wantErr %v", err, tt.wantErr)
-				return
+				t.Errorf("userService.SignUp() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("userService.GetUsers() = %v, want %v", got, tt.want)
+			if err == nil && (got.Role != auth.RoleUser || got.Password == tt.args.user.Password) {
+				t.Errorf("userService.SignUp() = %+v, want a user with a hashed password", got)
 			}
 		})
 	}
+
+	t.Run("password policy", func(t *testing.T) {
+		s := &userService{
+			db:             mkdb,
+			hasher:         testHasher,
+			keys:           testKeys,
+			mailer:         mail.NewLogMailer(io.Discard),
+			passwordPolicy: auth.PasswordPolicy{MinLength: 8, DisallowUsername: true},
+		}
+
+		// Rejected before anything is written
+		mkdb.EXPECT().Create(gomock.Any()).Times(0)
+
+		var policyErr *auth.PasswordPolicyError
+		_, err := s.SignUp(models.User{Username: "rrm", Password: "rrm12", Country: "india"})
+		if !errors.As(err, &policyErr) {
+			t.Fatalf("userService.SignUp() error = %v, want PasswordPolicyError", err)
+		}
+		if len(policyErr.Violations) != 2 {
+			t.Errorf("Violations = %v, want too short and contains username", policyErr.Violations)
+		}
+	})
 }
 
 func Test_userService_GetUser(t *testing.T) {
@@ -164,7 +319,8 @@ func Test_userService_GetUser(t *testing.T) {
 		db database.Database
 	}
 	type args struct {
-		id string
+		ctx context.Context
+		id  string
 	}
 	tests := []struct {
 		name    string
@@ -180,10 +336,11 @@ func Test_userService_GetUser(t *testing.T) {
 				db: mkdb,
 			},
 			args: args{
-				id: "1",
+				ctx: ownerCtx,
+				id:  "1",
 			},
 			setup: func(md *mockDB.MockDatabase) {
-				mkdb.EXPECT().First(gomock.Any(), "1").SetArg(0, models.User{Username: "rrm", Password: "encrypted", Country: "usa"}).Return(&gorm.DB{}).Times(1)
+				mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Password: "encrypted", Country: "usa"}).Return(&gorm.DB{}).Times(1)
 			},
 			want:    models.User{Username: "rrm", Country: "usa", Password: "encrypted"},
 			wantErr: false,
@@ -194,10 +351,53 @@ func Test_userService_GetUser(t *testing.T) {
 				db: mkdb,
 			},
 			args: args{
-				id: "1",
+				ctx: ownerCtx,
+				id:  "1",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{}).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "admin gets anyone",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: adminCtx,
+				id:  "1",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Country: "usa"}).Return(&gorm.DB{}).Times(1)
+			},
+			want: models.User{Username: "rrm", Country: "usa"},
+		},
+		{
+			name: "other users are forbidden",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: otherCtx,
+				id:  "1",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Country: "usa"}).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "ID that is not a number is not passed on as SQL",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: ownerCtx,
+				id:  "id=1 AND password LIKE '$2a%'",
 			},
 			setup: func(md *mockDB.MockDatabase) {
-				mkdb.EXPECT().First(gomock.Any(), "1").SetArg(0, models.User{}).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+				mkdb.EXPECT().First(gomock.Any(), gomock.Any()).Times(0)
 			},
 			wantErr: true,
 		},
@@ -210,7 +410,7 @@ func Test_userService_GetUser(t *testing.T) {
 
 			tt.setup(mkdb)
 
-			got, err := s.GetUser(tt.args.id)
+			got, err := s.GetUser(tt.args.ctx, tt.args.id)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("userService.GetUser() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -230,6 +430,7 @@ func Test_userService_UpdateUser(t *testing.T) {
 		db database.Database
 	}
 	type args struct {
+		ctx  context.Context
 		id   string
 		user models.User
 	}
@@ -240,14 +441,14 @@ func Test_userService_UpdateUser(t *testing.T) {
 		setup   func(*mockDB.MockDatabase)
 		wantErr bool
 	}{
-
 		{
 			name: "User not found",
 			fields: fields{
 				db: mkdb,
 			},
 			args: args{
-				id: "1",
+				ctx: ownerCtx,
+				id:  "1",
 				user: models.User{
 					Username: "rrm",
 					Country:  "usa",
@@ -255,7 +456,7 @@ func Test_userService_UpdateUser(t *testing.T) {
 				},
 			},
 			setup: func(md *mockDB.MockDatabase) {
-				md.EXPECT().First(gomock.Any(), "1").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
 			},
 			wantErr: true,
 		},
@@ -265,7 +466,8 @@ func Test_userService_UpdateUser(t *testing.T) {
 				db: mkdb,
 			},
 			args: args{
-				id: "1",
+				ctx: ownerCtx,
+				id:  "1",
 				user: models.User{
 					Username: "rrm",
 					Country:  "usa",
@@ -273,7 +475,7 @@ func Test_userService_UpdateUser(t *testing.T) {
 				},
 			},
 			setup: func(md *mockDB.MockDatabase) {
-				md.EXPECT().First(gomock.Any(), "1").Return(&gorm.DB{Error: fmt.Errorf("database error")}).Times(1)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).Return(&gorm.DB{Error: fmt.Errorf("database error")}).Times(1)
 			},
 			wantErr: true,
 		},
@@ -283,7 +485,8 @@ func Test_userService_UpdateUser(t *testing.T) {
 				db: mkdb,
 			},
 			args: args{
-				id: "1",
+				ctx: ownerCtx,
+				id:  "1",
 				user: models.User{
 					Username: "rrm",
 					Country:  "usa",
@@ -292,8 +495,16 @@ func Test_userService_UpdateUser(t *testing.T) {
 			},
 			setup: func(md *mockDB.MockDatabase) {
 				existingUser := models.User{Username: "rrm", Country: "india", Password: "oldpassword"}
-				md.EXPECT().First(gomock.Any(), "1").SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
-				md.EXPECT().Save(gomock.Any()).Return(&gorm.DB{Error: nil}).Times(1)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "version = ?", uint(0)).DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+					if values["country"] != "usa" {
+						t.Errorf("country = %v, want usa", values["country"])
+					}
+					if _, ok := values["password"]; ok {
+						t.Errorf("password written, generic update must not change it")
+					}
+					return &gorm.DB{RowsAffected: 1}
+				}).Times(1)
 			},
 			wantErr: false,
 		},
@@ -303,7 +514,8 @@ func Test_userService_UpdateUser(t *testing.T) {
 				db: mkdb,
 			},
 			args: args{
-				id: "1",
+				ctx: ownerCtx,
+				id:  "1",
 				user: models.User{
 					Username: "rrm",
 					Country:  "usa",
@@ -312,96 +524,1801 @@ func Test_userService_UpdateUser(t *testing.T) {
 			},
 			setup: func(md *mockDB.MockDatabase) {
 				existingUser := models.User{Username: "rrm", Country: "india", Password: "oldpassword"}
-				md.EXPECT().First(gomock.Any(), "1").SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
-				md.EXPECT().Save(gomock.Any()).Return(&gorm.DB{Error: fmt.Errorf("save error")}).Times(1)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "version = ?", uint(0)).Return(&gorm.DB{Error: fmt.Errorf("save error")}).Times(1)
 			},
 			wantErr: true,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			s := &userService{
-				db: tt.fields.db,
-			}
-
-			tt.setup(mkdb)
-
-			if err := s.UpdateUser(tt.args.id, tt.args.user); (err != nil) != tt.wantErr {
-				t.Errorf("userService.UpdateUser() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
-func Test_userService_DeleteUser(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	mkdb := mockDB.NewMockDatabase(ctrl)
-
-	type fields struct {
-		db database.Database
-	}
-	type args struct {
-		id string
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		setup   func(*mockDB.MockDatabase)
-		wantErr bool
-	}{
 		{
-			name: "success in deletion",
+			name: "Country required",
 			fields: fields{
 				db: mkdb,
 			},
 			args: args{
-				id: "1",
+				ctx:  ownerCtx,
+				id:   "1",
+				user: models.User{Username: "rrm", Email: "rrm@example.com"},
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				existingUser := models.User{Username: "rrm", Country: "india", Password: "oldpassword"}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "Username cannot change",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx:  ownerCtx,
+				id:   "1",
+				user: models.User{Username: "someone-else", Country: "usa"},
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				existingUser := models.User{Username: "rrm", Country: "india", Password: "oldpassword"}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "Expected version",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx:  ownerCtx,
+				id:   "1",
+				user: models.User{Country: "usa", Version: 4},
 			},
 			setup: func(md *mockDB.MockDatabase) {
-				md.EXPECT().First(gomock.Any(), "1").Return(&gorm.DB{})
-				md.EXPECT().Delete(gomock.Any()).Return(&gorm.DB{})
+				existingUser := models.User{Username: "rrm", Country: "india", Version: 4}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "version = ?", uint(4)).Return(&gorm.DB{RowsAffected: 1}).Times(1)
 			},
 			wantErr: false,
 		},
 		{
-			name: "fail to find",
+			name: "Stale version",
 			fields: fields{
 				db: mkdb,
 			},
 			args: args{
-				id: "1",
+				ctx:  ownerCtx,
+				id:   "1",
+				user: models.User{Country: "usa", Version: 3},
 			},
 			setup: func(md *mockDB.MockDatabase) {
-				md.EXPECT().First(gomock.Any(), "1").Return(&gorm.DB{Error: gorm.ErrRecordNotFound})
+				existingUser := models.User{Username: "rrm", Country: "india", Version: 4}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
 			},
 			wantErr: true,
 		},
 		{
-			name: "failure in deletion",
+			name: "Saved concurrently",
 			fields: fields{
 				db: mkdb,
 			},
 			args: args{
-				id: "1",
+				ctx:  ownerCtx,
+				id:   "1",
+				user: models.User{Country: "usa"},
 			},
 			setup: func(md *mockDB.MockDatabase) {
-				md.EXPECT().First(gomock.Any(), "1").Return(&gorm.DB{})
-				md.EXPECT().Delete(gomock.Any()).Return(&gorm.DB{Error: gorm.ErrCheckConstraintViolated})
+				existingUser := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Country: "india", Version: 4}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "version = ?", uint(4)).Return(&gorm.DB{RowsAffected: 0}).Times(1)
+				existingUser.Version = 5
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
 			},
 			wantErr: true,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			s := &userService{
-				db: tt.fields.db,
-			}
-
-			tt.setup(mkdb)
-
-			if err := s.DeleteUser(tt.args.id); (err != nil) != tt.wantErr {
-				t.Errorf("userService.DeleteUser() error = %v, wantErr %v", err, tt.wantErr)
+		{
+			name: "Forbidden for another user",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: otherCtx,
+				id:  "1",
+				user: models.User{
+					Country: "usa",
+				},
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				existingUser := models.User{Username: "rrm", Country: "india", Password: "oldpassword"}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "Forbidden without caller",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: context.Background(),
+				id:  "1",
+				user: models.User{
+					Country: "usa",
+				},
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				existingUser := models.User{Username: "rrm", Country: "india", Password: "oldpassword"}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "Admin updates another user",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: adminCtx,
+				id:  "1",
+				user: models.User{
+					Country: "usa",
+				},
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				existingUser := models.User{Username: "rrm", Country: "india", Password: "oldpassword"}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "version = ?", uint(0)).Return(&gorm.DB{RowsAffected: 1}).Times(1)
+			},
+			wantErr: false,
+		},
+		{
+			name: "Changing email resets verification",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: ownerCtx,
+				id:  "1",
+				user: models.User{
+					Country: "india",
+					Email:   "New@Example.com",
+				},
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				verifiedAt := time.Now()
+				existingUser := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Country: "india", Email: "old@example.com", EmailVerifiedAt: &verifiedAt}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
+				md.EXPECT().Unscoped().Return(md).Times(1)
+				md.EXPECT().First(gomock.Any(), "email = ?", "new@example.com").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "version = ?", uint(0)).DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+					if values["email"] != "new@example.com" || values["email_verified_at"].(*time.Time) != nil {
+						t.Errorf("email = %v, email_verified_at = %v, want new unverified address", values["email"], values["email_verified_at"])
+					}
+					return &gorm.DB{RowsAffected: 1}
+				}).Times(1)
+			},
+			wantErr: false,
+		},
+		{
+			name: "Unchanged email keeps verification",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: ownerCtx,
+				id:  "1",
+				user: models.User{
+					Country: "india",
+					Email:   "rrm@example.com",
+				},
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				verifiedAt := time.Now()
+				existingUser := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Country: "india", Email: "rrm@example.com", EmailVerifiedAt: &verifiedAt}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "version = ?", uint(0)).DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+					if values["email_verified_at"].(*time.Time) == nil {
+						t.Errorf("email_verified_at was cleared")
+					}
+					return &gorm.DB{RowsAffected: 1}
+				}).Times(1)
+			},
+			wantErr: false,
+		},
+		{
+			name: "Email taken by another user",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: ownerCtx,
+				id:  "1",
+				user: models.User{
+					Country: "india",
+					Email:   "taken@example.com",
+				},
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				existingUser := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Country: "india"}
+				other := models.User{Model: gorm.Model{ID: 2}, Email: "taken@example.com"}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existingUser).Return(&gorm.DB{Error: nil}).Times(1)
+				md.EXPECT().Unscoped().Return(md).Times(1)
+				md.EXPECT().First(gomock.Any(), "email = ?", "taken@example.com").SetArg(0, other).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{
+				db:     tt.fields.db,
+				keys:   testKeys,
+				mailer: mail.NewLogMailer(io.Discard),
+			}
+
+			tt.setup(mkdb)
+
+			got, err := s.UpdateUser(tt.args.ctx, tt.args.id, tt.args.user)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("userService.UpdateUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Country != tt.args.user.Country {
+				t.Errorf("userService.UpdateUser() country = %q, want %q", got.Country, tt.args.user.Country)
+			}
+		})
+	}
+}
+
+func Test_userService_EmailOfDeletedUser(t *testing.T) {
+	deleted := models.User{Model: gorm.Model{ID: 2, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}, Username: "gone", Email: "gone@example.com"}
+
+	t.Run("sign up", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mkdb := mockDB.NewMockDatabase(ctrl)
+		s := &userService{db: mkdb, hasher: testHasher}
+
+		mkdb.EXPECT().Unscoped().Return(mkdb).Times(1)
+		mkdb.EXPECT().First(gomock.Any(), "email = ?", "gone@example.com").SetArg(0, deleted).Return(&gorm.DB{}).Times(1)
+		mkdb.EXPECT().Create(gomock.Any()).Times(0)
+
+		_, err := s.SignUp(models.User{Username: "rrm", Password: "roeeo", Country: "india", Email: "gone@example.com"})
+		if !errors.Is(err, ErrEmailTaken) {
+			t.Errorf("userService.SignUp() error = %v, want %v", err, ErrEmailTaken)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mkdb := mockDB.NewMockDatabase(ctrl)
+		s := &userService{db: mkdb}
+
+		existing := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Country: "india"}
+		mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existing).Return(&gorm.DB{}).Times(1)
+		mkdb.EXPECT().Unscoped().Return(mkdb).Times(1)
+		mkdb.EXPECT().First(gomock.Any(), "email = ?", "gone@example.com").SetArg(0, deleted).Return(&gorm.DB{}).Times(1)
+		mkdb.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		_, err := s.UpdateUser(ownerCtx, "1", models.User{Country: "india", Email: "gone@example.com"})
+		if !errors.Is(err, ErrEmailTaken) {
+			t.Errorf("userService.UpdateUser() error = %v, want %v", err, ErrEmailTaken)
+		}
+	})
+}
+
+func Test_userService_UpdateUser_ConflictVersion(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+	s := &userService{db: mkdb}
+
+	existing := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Country: "india", Version: 4}
+	mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existing).Return(&gorm.DB{}).Times(1)
+	mkdb.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "version = ?", uint(4)).Return(&gorm.DB{RowsAffected: 0}).Times(1)
+	existing.Version = 5
+	mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, existing).Return(&gorm.DB{}).Times(1)
+
+	_, err := s.UpdateUser(ownerCtx, "1", models.User{Country: "usa"})
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) || conflictErr.Version != 5 {
+		t.Errorf("userService.UpdateUser() error = %v, want a ConflictError at the stored version 5", err)
+	}
+}
+
+func Test_userService_DeleteUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	deleted := func(md *mockDB.MockDatabase) {
+		md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "version = ?", uint(3)).DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+			if values["deleted_at"] == nil || !reflect.DeepEqual(values["token_version"], gorm.Expr("token_version + 1")) {
+				t.Errorf("deleted with %v, want deleted_at set and the token version bumped", values)
+			}
+			return &gorm.DB{RowsAffected: 1}
+		})
+		md.EXPECT().Find(gomock.Any(), "user_id = ? AND revoked_at IS NULL", gomock.Any()).Return(&gorm.DB{})
+		md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "user_id = ? AND revoked_at IS NULL", gomock.Any()).Return(&gorm.DB{})
+	}
+
+	type fields struct {
+		db database.Database
+	}
+	type args struct {
+		ctx     context.Context
+		id      string
+		version uint
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		setup   func(*mockDB.MockDatabase)
+		wantErr bool
+	}{
+		{
+			name: "success in deletion",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: ownerCtx,
+				id:  "1",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Version: 3}).Return(&gorm.DB{})
+				deleted(md)
+			},
+			wantErr: false,
+		},
+		{
+			name: "fail to find",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: ownerCtx,
+				id:  "1",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound})
+			},
+			wantErr: true,
+		},
+		{
+			name: "failure in deletion",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: ownerCtx,
+				id:  "1",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Version: 3}).Return(&gorm.DB{})
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "version = ?", uint(3)).Return(&gorm.DB{Error: gorm.ErrCheckConstraintViolated})
+			},
+			wantErr: true,
+		},
+		{
+			name: "forbidden for another user",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: otherCtx,
+				id:  "1",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Version: 3}).Return(&gorm.DB{})
+			},
+			wantErr: true,
+		},
+		{
+			name: "admin deletes another user",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: adminCtx,
+				id:  "1",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Version: 3}).Return(&gorm.DB{})
+				deleted(md)
+			},
+			wantErr: false,
+		},
+		{
+			name: "expected version",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx:     ownerCtx,
+				id:      "1",
+				version: 3,
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Version: 3}).Return(&gorm.DB{})
+				deleted(md)
+			},
+			wantErr: false,
+		},
+		{
+			name: "stale version",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx:     ownerCtx,
+				id:      "1",
+				version: 2,
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Version: 3}).Return(&gorm.DB{})
+			},
+			wantErr: true,
+		},
+		{
+			name: "changed concurrently",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				ctx: ownerCtx,
+				id:  "1",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Version: 3}).Return(&gorm.DB{})
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "version = ?", uint(3)).Return(&gorm.DB{RowsAffected: 0})
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Version: 4}).Return(&gorm.DB{})
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{
+				db: tt.fields.db,
+			}
+
+			tt.setup(mkdb)
+
+			if err := s.DeleteUser(tt.args.ctx, tt.args.id, tt.args.version); (err != nil) != tt.wantErr {
+				t.Errorf("userService.DeleteUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_userService_Login(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	hashed, _ := utils.HashPassword("secret")
+
+	type fields struct {
+		db database.Database
+	}
+	type args struct {
+		username string
+		password string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		setup   func(*mockDB.MockDatabase)
+		wantErr bool
+	}{
+		{
+			name: "success",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				username: "rrm",
+				password: "secret",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Username: "rrm", Password: hashed}).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown username",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				username: "nobody",
+				password: "secret",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "nobody").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "success by email",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				username: "RRM@example.com",
+				password: "secret",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "RRM@example.com").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+				md.EXPECT().First(gomock.Any(), "email = ?", "rrm@example.com").SetArg(0, models.User{Username: "rrm", Password: hashed}).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown email",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				username: "nobody@example.com",
+				password: "secret",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "nobody@example.com").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+				md.EXPECT().First(gomock.Any(), "email = ?", "nobody@example.com").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong password",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				username: "rrm",
+				password: "wrong",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Username: "rrm", Password: hashed}).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "failure storing refresh token",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				username: "rrm",
+				password: "secret",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Username: "rrm", Password: hashed}).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Create(gomock.Any()).Return(&gorm.DB{Error: fmt.Errorf("insert error")}).Times(1)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{
+				db:      tt.fields.db,
+				keys:    testKeys,
+				lockout: newTestLimiter(),
+				hasher:  testHasher,
+			}
+
+			tt.setup(mkdb)
+
+			got, err := s.Login(tt.args.username, tt.args.password, "192.0.2.1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("userService.Login() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && (got.AccessToken == "" || got.RefreshToken == "") {
+				t.Errorf("userService.Login() = %v, want access and refresh token", got)
+			}
+		})
+	}
+}
+
+func Test_userService_Login_RequireVerifiedEmail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	hashed, _ := utils.HashPassword("secret")
+	verifiedAt := time.Now()
+
+	s := &userService{
+		db:                   mkdb,
+		keys:                 testKeys,
+		requireVerifiedEmail: true,
+		lockout:              newTestLimiter(),
+		hasher:               testHasher,
+	}
+
+	t.Run("unverified", func(t *testing.T) {
+		user := models.User{Username: "rrm", Password: hashed, Email: "rrm@example.com"}
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, user).Return(&gorm.DB{}).Times(1)
+
+		if _, err := s.Login("rrm", "secret", "192.0.2.1"); !errors.Is(err, ErrEmailNotVerified) {
+			t.Errorf("userService.Login() error = %v, want %v", err, ErrEmailNotVerified)
+		}
+	})
+
+	t.Run("unverified with wrong password", func(t *testing.T) {
+		user := models.User{Username: "rrm", Password: hashed, Email: "rrm@example.com"}
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, user).Return(&gorm.DB{}).Times(1)
+
+		if _, err := s.Login("rrm", "wrong", "192.0.2.1"); err == nil || errors.Is(err, ErrEmailNotVerified) {
+			t.Errorf("userService.Login() error = %v, want invalid credentials", err)
+		}
+	})
+
+	t.Run("verified", func(t *testing.T) {
+		user := models.User{Username: "rrm", Password: hashed, Email: "rrm@example.com", EmailVerifiedAt: &verifiedAt}
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, user).Return(&gorm.DB{}).Times(1)
+		mkdb.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+
+		if _, err := s.Login("rrm", "secret", "192.0.2.1"); err != nil {
+			t.Errorf("userService.Login() error = %v", err)
+		}
+	})
+}
+
+func Test_userService_Login_InvalidCredentials(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	hashed, _ := utils.HashPassword("secret")
+	s := &userService{db: mkdb, keys: testKeys, lockout: newTestLimiter(), hasher: testHasher}
+
+	mkdb.EXPECT().First(gomock.Any(), "username = ?", "nobody").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+	_, unknownErr := s.Login("nobody", "secret", "192.0.2.1")
+
+	mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Username: "rrm", Password: hashed}).Return(&gorm.DB{}).Times(1)
+	_, wrongErr := s.Login("rrm", "wrong", "192.0.2.1")
+
+	if !errors.Is(unknownErr, ErrInvalidCredentials) || !errors.Is(wrongErr, ErrInvalidCredentials) {
+		t.Fatalf("userService.Login() errors = %v, %v, want %v", unknownErr, wrongErr, ErrInvalidCredentials)
+	}
+	if unknownErr.Error() != wrongErr.Error() {
+		t.Errorf("unknown user and wrong password errors differ: %q vs %q", unknownErr, wrongErr)
+	}
+}
+
+func Test_dummyPasswordHash(t *testing.T) {
+	// Unknown users are only as slow as real ones if the dummy hash has the
+	// same algorithm and cost as new hashes
+	for _, hasher := range []utils.PasswordHasher{testHasher, fastArgon2id} {
+		s := &userService{hasher: hasher}
+		if hasher.NeedsRehash(s.dummyPasswordHash()) {
+			t.Errorf("dummy hash %q does not match %T", s.dummyPasswordHash(), hasher)
+		}
+	}
+}
+
+func Test_userService_Login_UpgradesPasswordHash(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	bcryptHash, _ := utils.HashPassword("secret")
+	argonHash, _ := fastArgon2id.Hash("secret")
+	lowCostHash, _ := utils.BcryptHasher{Cost: bcrypt.MinCost}.Hash("secret")
+
+	tests := []struct {
+		name       string
+		hasher     utils.PasswordHasher
+		stored     string
+		wantRehash bool
+	}{
+		{name: "bcrypt to argon2id", hasher: fastArgon2id, stored: bcryptHash, wantRehash: true},
+		{name: "bcrypt cost raised", hasher: testHasher, stored: lowCostHash, wantRehash: true},
+		{name: "argon2id parameters changed", hasher: utils.Argon2idHasher{Memory: 2048, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, stored: argonHash, wantRehash: true},
+		{name: "up to date", hasher: testHasher, stored: bcryptHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{db: mkdb, keys: testKeys, lockout: newTestLimiter(), hasher: tt.hasher}
+
+			mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Password: tt.stored}).Return(&gorm.DB{}).Times(1)
+			if tt.wantRehash {
+				mkdb.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "id = ?", gomock.Any()).DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+					saved, _ := values["password"].(string)
+					if tt.hasher.NeedsRehash(saved) || !utils.CheckPasswordHash("secret", saved) {
+						t.Errorf("stored hash %q was not upgraded", saved)
+					}
+					return &gorm.DB{}
+				}).Times(1)
+			}
+			mkdb.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+
+			if _, err := s.Login("rrm", "secret", "192.0.2.1"); err != nil {
+				t.Errorf("userService.Login() error = %v", err)
+			}
+		})
+	}
+
+	t.Run("failed upgrade does not fail the login", func(t *testing.T) {
+		s := &userService{db: mkdb, keys: testKeys, lockout: newTestLimiter(), hasher: fastArgon2id}
+
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Password: bcryptHash}).Return(&gorm.DB{}).Times(1)
+		mkdb.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "id = ?", uint(1)).Return(&gorm.DB{Error: errors.New("database unavailable")}).Times(1)
+		mkdb.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+
+		if _, err := s.Login("rrm", "secret", "192.0.2.1"); err != nil {
+			t.Errorf("userService.Login() error = %v", err)
+		}
+	})
+}
+
+func Test_userService_RefreshToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	revokedAt := time.Now().Add(-time.Minute)
+	active := models.RefreshToken{UserID: 1, FamilyID: "family", ExpiresAt: time.Now().Add(time.Hour)}
+	rotated := models.RefreshToken{UserID: 1, FamilyID: "family", ExpiresAt: time.Now().Add(time.Hour), RevokedAt: &revokedAt}
+	expired := models.RefreshToken{UserID: 1, FamilyID: "family", ExpiresAt: time.Now().Add(-time.Hour)}
+
+	type fields struct {
+		db database.Database
+	}
+	type args struct {
+		token string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		setup   func(*mockDB.MockDatabase)
+		wantErr error
+	}{
+		{
+			name: "success",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				token: "refresh",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", utils.HashToken("refresh")).SetArg(0, active).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), uint(1)).SetArg(0, models.User{Username: "rrm"}).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "revoked_at IS NULL").Return(&gorm.DB{RowsAffected: 1}).Times(1)
+				md.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+			},
+		},
+		{
+			name: "token rotated concurrently revokes family",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				token: "refresh",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", utils.HashToken("refresh")).SetArg(0, active).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), uint(1)).SetArg(0, models.User{Username: "rrm"}).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "revoked_at IS NULL").Return(&gorm.DB{RowsAffected: 0}).Times(1)
+				md.EXPECT().Find(gomock.Any(), "family_id = ? AND revoked_at IS NULL", "family").SetArg(0, []models.RefreshToken{active}).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Save(gomock.Any()).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Create(gomock.Any()).Times(0)
+			},
+			wantErr: ErrRefreshTokenReused,
+		},
+		{
+			name: "unknown token",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				token: "unknown",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", utils.HashToken("unknown")).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: ErrInvalidRefreshToken,
+		},
+		{
+			name: "expired token",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				token: "expired",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", utils.HashToken("expired")).SetArg(0, expired).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidRefreshToken,
+		},
+		{
+			name: "reused token revokes family",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				token: "rotated",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", utils.HashToken("rotated")).SetArg(0, rotated).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Find(gomock.Any(), "family_id = ? AND revoked_at IS NULL", "family").SetArg(0, []models.RefreshToken{active, active}).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Save(gomock.Any()).Return(&gorm.DB{}).Times(2)
+			},
+			wantErr: ErrRefreshTokenReused,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{
+				db:   tt.fields.db,
+				keys: testKeys,
+			}
+
+			tt.setup(mkdb)
+
+			got, err := s.RefreshToken(tt.args.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("userService.RefreshToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr == nil && (got.AccessToken == "" || got.RefreshToken == "") {
+				t.Errorf("userService.RefreshToken() = %v, want access and refresh token", got)
+			}
+		})
+	}
+}
+
+func Test_userService_ValidateToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	sign := func(method jwt.SigningMethod, kid string, key []byte, claims *auth.Claims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		tokenStr, _ := token.SignedString(key)
+		return tokenStr
+	}
+	validClaims := func() *auth.Claims {
+		return &auth.Claims{
+			Username:     "testuser",
+			TokenVersion: 2,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        "jti-1",
+				Subject:   "1",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}
+	}
+
+	type fields struct {
+		db database.Database
+	}
+	type args struct {
+		token string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		setup   func(*mockDB.MockDatabase)
+		wantErr error
+	}{
+		{
+			name: "valid token",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				token: sign(jwt.SigningMethodHS256, "test", testSecret, validClaims()),
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Find(gomock.Any(), "jti = ?", "jti-1").Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "testuser", Role: auth.RoleAdmin, TokenVersion: 2}).Return(&gorm.DB{}).Times(1)
+			},
+		},
+		{
+			name: "incorrect signing method",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				token: sign(jwt.SigningMethodHS512, "test", testSecret, validClaims()),
+			},
+			setup:   func(md *mockDB.MockDatabase) {},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "tampered token",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				token: sign(jwt.SigningMethodHS256, "test", []byte("wrong_secret_key"), validClaims()),
+			},
+			setup:   func(md *mockDB.MockDatabase) {},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "unknown key id",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				token: sign(jwt.SigningMethodHS256, "retired", testSecret, validClaims()),
+			},
+			setup:   func(md *mockDB.MockDatabase) {},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "revoked jti",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				token: sign(jwt.SigningMethodHS256, "test", testSecret, validClaims()),
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Find(gomock.Any(), "jti = ?", "jti-1").SetArg(0, []models.RevokedToken{{JTI: "jti-1"}}).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrTokenRevoked,
+		},
+		{
+			name: "sessions revoked for user",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				token: sign(jwt.SigningMethodHS256, "test", testSecret, validClaims()),
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Find(gomock.Any(), "jti = ?", "jti-1").Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "testuser", TokenVersion: 3}).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrTokenRevoked,
+		},
+		{
+			name: "user no longer exists",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				token: sign(jwt.SigningMethodHS256, "test", testSecret, validClaims()),
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Find(gomock.Any(), "jti = ?", "jti-1").Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{
+				db:          tt.fields.db,
+				keys:        testKeys,
+				revocations: NewRevocationStore(tt.fields.db),
+			}
+
+			tt.setup(mkdb)
+
+			got, err := s.ValidateToken(tt.args.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("userService.ValidateToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr == nil && (got.Username != "testuser" || got.Role != auth.RoleAdmin) {
+				t.Errorf("userService.ValidateToken() = %v, want username testuser with role from database", got)
+			}
+		})
+	}
+}
+
+func Test_userService_ValidateToken_RegisteredClaims(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	cfg := auth.ClaimsConfig{Issuer: "https://api.example.com", Audience: []string{"api"}, Leeway: 30 * time.Second}
+	s := &userService{db: mkdb, keys: testKeys, revocations: NewRevocationStore(mkdb), claims: cfg}
+	user := models.User{Model: gorm.Model{ID: 7}, Username: "testuser", Role: auth.RoleUser}
+
+	mkdb.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+	tokens, err := s.issueTokens(user, "family-1")
+	if err != nil {
+		t.Fatalf("userService.issueTokens() error = %v", err)
+	}
+
+	issued := &auth.Claims{}
+	if _, err := testKeys.Parse(tokens.AccessToken, issued); err != nil {
+		t.Fatalf("parse issued token: %v", err)
+	}
+	if issued.Subject != "7" || issued.Issuer != cfg.Issuer || !reflect.DeepEqual([]string(issued.Audience), cfg.Audience) || issued.NotBefore == nil || issued.IssuedAt == nil {
+		t.Errorf("issued claims = %+v, want sub, iss, aud, nbf and iat", issued.RegisteredClaims)
+	}
+
+	mkdb.EXPECT().Find(gomock.Any(), "jti = ?", issued.ID).Return(&gorm.DB{}).Times(1)
+	mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(7)).SetArg(0, user).Return(&gorm.DB{}).Times(1)
+	if _, err := s.ValidateToken(tokens.AccessToken); err != nil {
+		t.Errorf("userService.ValidateToken() of an issued token error = %v", err)
+	}
+
+	sign := func(modify func(*auth.Claims)) string {
+		claims := *issued
+		modify(&claims)
+		token, _ := testKeys.Sign(&claims)
+		return token
+	}
+	tests := []struct {
+		name   string
+		modify func(*auth.Claims)
+	}{
+		{name: "other issuer", modify: func(c *auth.Claims) { c.Issuer = "https://evil.example.com" }},
+		{name: "other audience", modify: func(c *auth.Claims) { c.Audience = jwt.ClaimStrings{"reports"} }},
+		{name: "not valid yet", modify: func(c *auth.Claims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour)) }},
+		{name: "expired beyond leeway", modify: func(c *auth.Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }},
+		{name: "no subject", modify: func(c *auth.Claims) { c.Subject = "" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.ValidateToken(sign(tt.modify)); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("userService.ValidateToken() error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+
+	// A subject that is not an ID must not reach the query as SQL
+	mkdb.EXPECT().Find(gomock.Any(), "jti = ?", issued.ID).Return(&gorm.DB{}).Times(1)
+	if _, err := s.ValidateToken(sign(func(c *auth.Claims) { c.Subject = "7 OR 1=1" })); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("userService.ValidateToken() with subject %q error = %v, want %v", "7 OR 1=1", err, ErrInvalidToken)
+	}
+}
+
+func Test_userService_issueTokens_AdminAudience(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	cfg := auth.ClaimsConfig{Audience: []string{"api"}, AdminAudience: "admin"}
+	s := &userService{db: mkdb, keys: testKeys, revocations: NewRevocationStore(mkdb), claims: cfg}
+
+	tests := []struct {
+		name string
+		user models.User
+		want bool
+	}{
+		{name: "admin", user: models.User{Model: gorm.Model{ID: 1}, Username: "root", Role: auth.RoleAdmin}, want: true},
+		{name: "user", user: models.User{Model: gorm.Model{ID: 7}, Username: "testuser", Role: auth.RoleUser}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mkdb.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+			tokens, err := s.issueTokens(tt.user, "family-1")
+			if err != nil {
+				t.Fatalf("userService.issueTokens() error = %v", err)
+			}
+
+			mkdb.EXPECT().Find(gomock.Any(), "jti = ?", gomock.Any()).Return(&gorm.DB{}).Times(1)
+			mkdb.EXPECT().First(gomock.Any(), "id = ?", tt.user.ID).SetArg(0, tt.user).Return(&gorm.DB{}).Times(1)
+			claims, err := s.ValidateToken(tokens.AccessToken)
+			if err != nil {
+				t.Fatalf("userService.ValidateToken() error = %v", err)
+			}
+			if got := auth.HasAudience(&claims.RegisteredClaims, cfg.AdminAudience); got != tt.want {
+				t.Errorf("token has the admin audience = %v, want %v (aud %v)", got, tt.want, claims.Audience)
+			}
+		})
+	}
+
+	if !reflect.DeepEqual(cfg.Audience, []string{"api"}) {
+		t.Errorf("ClaimsConfig.Audience = %v, changed by issuing tokens", cfg.Audience)
+	}
+}
+
+func Test_userService_Logout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	claims := &auth.Claims{
+		Username: "testuser",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti-1",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+
+	type fields struct {
+		db database.Database
+	}
+	type args struct {
+		claims       *auth.Claims
+		refreshToken string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		setup   func(*mockDB.MockDatabase)
+		wantErr bool
+	}{
+		{
+			name: "access token only",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				claims: claims,
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Delete(gomock.Any(), "expires_at < ?", gomock.Any()).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: false,
+		},
+		{
+			name: "with refresh token",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				claims:       claims,
+				refreshToken: "refresh",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Delete(gomock.Any(), "expires_at < ?", gomock.Any()).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", utils.HashToken("refresh")).SetArg(0, models.RefreshToken{FamilyID: "family"}).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Find(gomock.Any(), "family_id = ? AND revoked_at IS NULL", "family").SetArg(0, []models.RefreshToken{{FamilyID: "family"}}).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Save(gomock.Any()).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: false,
+		},
+		{
+			name: "failure revoking access token",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				claims: claims,
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Delete(gomock.Any(), "expires_at < ?", gomock.Any()).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Create(gomock.Any()).Return(&gorm.DB{Error: fmt.Errorf("insert error")}).Times(1)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{
+				db:          tt.fields.db,
+				revocations: NewRevocationStore(tt.fields.db),
+			}
+
+			tt.setup(mkdb)
+
+			if err := s.Logout(tt.args.claims, tt.args.refreshToken); (err != nil) != tt.wantErr {
+				t.Errorf("userService.Logout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_userService_RevokeAllSessions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	type fields struct {
+		db database.Database
+	}
+	type args struct {
+		id string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		setup   func(*mockDB.MockDatabase)
+		wantErr bool
+	}{
+		{
+			name: "success",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				id: "1",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				user := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", TokenVersion: 4}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, user).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "id = ?", gomock.Any()).DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+					if !reflect.DeepEqual(values["token_version"], gorm.Expr("token_version + 1")) {
+						t.Errorf("token_version = %v, want it incremented", values["token_version"])
+					}
+					return &gorm.DB{}
+				}).Times(1)
+				md.EXPECT().Find(gomock.Any(), "user_id = ? AND revoked_at IS NULL", uint(1)).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: false,
+		},
+		{
+			name: "user not found",
+			fields: fields{
+				db: mkdb,
+			},
+			args: args{
+				id: "1",
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{
+				db: tt.fields.db,
+			}
+
+			tt.setup(mkdb)
+
+			if err := s.RevokeAllSessions(tt.args.id); (err != nil) != tt.wantErr {
+				t.Errorf("userService.RevokeAllSessions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_authorizeUserAccess(t *testing.T) {
+	target := models.User{Username: "rrm"}
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		wantForbidden bool
+	}{
+		{name: "owner", ctx: ownerCtx, wantForbidden: false},
+		{name: "admin", ctx: adminCtx, wantForbidden: false},
+		{name: "another user", ctx: otherCtx, wantForbidden: true},
+		{name: "no caller", ctx: context.Background(), wantForbidden: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorizeUserAccess(tt.ctx, target, "update user", auth.PermWriteProfile, auth.PermManageUsers)
+
+			var authErr *AuthorizationError
+			if errors.As(err, &authErr) != tt.wantForbidden {
+				t.Errorf("authorizeUserAccess() error = %v, wantForbidden %v", err, tt.wantForbidden)
+			}
+		})
+	}
+}
+
+func Test_userService_GetCurrentUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		setup   func(*mockDB.MockDatabase)
+		want    models.User
+		wantErr bool
+	}{
+		{
+			name: "success",
+			ctx:  ownerCtx,
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Username: "rrm", Country: "usa"}).Return(&gorm.DB{}).Times(1)
+			},
+			want: models.User{Username: "rrm", Country: "usa"},
+		},
+		{
+			name:    "no caller",
+			ctx:     context.Background(),
+			setup:   func(md *mockDB.MockDatabase) {},
+			wantErr: true,
+		},
+		{
+			name: "caller no longer exists",
+			ctx:  ownerCtx,
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{
+				db: mkdb,
+			}
+
+			tt.setup(mkdb)
+
+			got, err := s.GetCurrentUser(tt.ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("userService.GetCurrentUser() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("userService.GetCurrentUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_userService_ChangePassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	hashed, _ := utils.HashPassword("old-password")
+
+	type args struct {
+		ctx             context.Context
+		currentPassword string
+		newPassword     string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		setup   func(*mockDB.MockDatabase)
+		wantErr error
+	}{
+		{
+			name: "success",
+			args: args{ctx: ownerCtx, currentPassword: "old-password", newPassword: "new-password"},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Password: hashed}).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "id = ?", gomock.Any()).DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+					if saved, _ := values["password"].(string); !utils.CheckPasswordHash("new-password", saved) {
+						t.Errorf("new password was not hashed into the record")
+					}
+					if !reflect.DeepEqual(values["token_version"], gorm.Expr("token_version + 1")) {
+						t.Errorf("token_version = %v, want it incremented", values["token_version"])
+					}
+					return &gorm.DB{}
+				}).Times(1)
+				md.EXPECT().Find(gomock.Any(), "user_id = ? AND revoked_at IS NULL", uint(0)).Return(&gorm.DB{}).Times(1)
+			},
+		},
+		{
+			name: "wrong current password",
+			args: args{ctx: ownerCtx, currentPassword: "wrong", newPassword: "new-password"},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Password: hashed}).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrIncorrectPassword,
+		},
+		{
+			name: "user not found",
+			args: args{ctx: ownerCtx, currentPassword: "old-password", newPassword: "new-password"},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: gorm.ErrRecordNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{
+				db:     mkdb,
+				hasher: testHasher,
+			}
+
+			tt.setup(mkdb)
+
+			if err := s.ChangePassword(tt.args.ctx, "1", tt.args.currentPassword, tt.args.newPassword); !errors.Is(err, tt.wantErr) {
+				t.Errorf("userService.ChangePassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("another user", func(t *testing.T) {
+		s := &userService{
+			db:     mkdb,
+			hasher: testHasher,
+		}
+
+		mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Password: hashed}).Return(&gorm.DB{}).Times(1)
+
+		var authErr *AuthorizationError
+		if err := s.ChangePassword(adminCtx, "1", "old-password", "new-password"); !errors.As(err, &authErr) {
+			t.Errorf("userService.ChangePassword() error = %v, want AuthorizationError", err)
+		}
+	})
+
+	t.Run("password policy", func(t *testing.T) {
+		s := &userService{
+			db:             mkdb,
+			hasher:         testHasher,
+			passwordPolicy: auth.PasswordPolicy{MinLength: 12},
+		}
+
+		mkdb.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, models.User{Username: "rrm", Password: hashed}).Return(&gorm.DB{}).Times(1)
+
+		var policyErr *auth.PasswordPolicyError
+		if err := s.ChangePassword(ownerCtx, "1", "old-password", "short"); !errors.As(err, &policyErr) {
+			t.Errorf("userService.ChangePassword() error = %v, want PasswordPolicyError", err)
+		}
+	})
+}
+
+func Test_userService_ForgotPassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	tests := []struct {
+		name     string
+		setup    func(*mockDB.MockDatabase)
+		wantMail bool
+		wantErr  error
+	}{
+		{
+			name: "success",
+			setup: func(md *mockDB.MockDatabase) {
+				user := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Email: "rrm@example.com"}
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, user).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Create(gomock.Any()).DoAndReturn(func(value interface{}) *gorm.DB {
+					record := value.(*models.PasswordResetToken)
+					if record.UserID != 1 || record.TokenHash == "" {
+						t.Errorf("unexpected reset token record %+v", record)
+					}
+					if time.Until(record.ExpiresAt) > resetTokenTTL {
+						t.Errorf("ExpiresAt = %v, want within %v", record.ExpiresAt, resetTokenTTL)
+					}
+					return &gorm.DB{}
+				}).Times(1)
+			},
+			wantMail: true,
+		},
+		{
+			name: "unknown user",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+		},
+		{
+			name: "user without email",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Username: "rrm"}).Return(&gorm.DB{}).Times(1)
+			},
+		},
+		{
+			name: "database error",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").Return(&gorm.DB{Error: gorm.ErrInvalidDB}).Times(1)
+			},
+			wantErr: gorm.ErrInvalidDB,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outbox bytes.Buffer
+			s := &userService{
+				db:       mkdb,
+				mailer:   mail.NewLogMailer(&outbox),
+				resetURL: "https://example.com/reset",
+			}
+
+			tt.setup(mkdb)
+
+			if err := s.ForgotPassword("rrm"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("userService.ForgotPassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			s.mails.Wait()
+			if sent := strings.Contains(outbox.String(), "To: rrm@example.com"); sent != tt.wantMail {
+				t.Errorf("mail sent = %v, want %v", sent, tt.wantMail)
+			}
+			if tt.wantMail && !strings.Contains(outbox.String(), "https://example.com/reset?token=") {
+				t.Errorf("mail does not contain the reset link: %s", outbox.String())
+			}
+		})
+	}
+}
+
+func Test_userService_ResetPassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	tokenHash := utils.HashToken("reset-token")
+	usedAt := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name    string
+		setup   func(*mockDB.MockDatabase)
+		wantErr error
+	}{
+		{
+			name: "success",
+			setup: func(md *mockDB.MockDatabase) {
+				stored := models.PasswordResetToken{ID: 3, UserID: 1, TokenHash: tokenHash, ExpiresAt: time.Now().Add(time.Hour)}
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", tokenHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), uint(1)).SetArg(0, models.User{Model: gorm.Model{ID: 1}, Username: "rrm"}).Return(&gorm.DB{}).Times(1)
+				gomock.InOrder(
+					md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "used_at IS NULL").DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+						if model.(*models.PasswordResetToken).ID != 3 || values["used_at"] == nil {
+							t.Errorf("reset token was not marked as used")
+						}
+						return &gorm.DB{RowsAffected: 1}
+					}),
+					md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "id = ?", gomock.Any()).DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+						if saved, _ := values["password"].(string); !utils.CheckPasswordHash("new-password", saved) {
+							t.Errorf("new password was not hashed into the record")
+						}
+						if !reflect.DeepEqual(values["token_version"], gorm.Expr("token_version + 1")) {
+							t.Errorf("token_version = %v, want it incremented", values["token_version"])
+						}
+						return &gorm.DB{}
+					}),
+				)
+				md.EXPECT().Find(gomock.Any(), "user_id = ? AND revoked_at IS NULL", uint(1)).Return(&gorm.DB{}).Times(1)
+			},
+		},
+		{
+			name: "unknown token",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", tokenHash).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: ErrInvalidResetToken,
+		},
+		{
+			name: "used token",
+			setup: func(md *mockDB.MockDatabase) {
+				stored := models.PasswordResetToken{UserID: 1, ExpiresAt: time.Now().Add(time.Hour), UsedAt: &usedAt}
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", tokenHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidResetToken,
+		},
+		{
+			name: "token used concurrently",
+			setup: func(md *mockDB.MockDatabase) {
+				stored := models.PasswordResetToken{ID: 3, UserID: 1, TokenHash: tokenHash, ExpiresAt: time.Now().Add(time.Hour)}
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", tokenHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), uint(1)).SetArg(0, models.User{Model: gorm.Model{ID: 1}, Username: "rrm"}).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "used_at IS NULL").Return(&gorm.DB{RowsAffected: 0}).Times(1)
+			},
+			wantErr: ErrInvalidResetToken,
+		},
+		{
+			name: "expired token",
+			setup: func(md *mockDB.MockDatabase) {
+				stored := models.PasswordResetToken{UserID: 1, ExpiresAt: time.Now().Add(-time.Minute)}
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", tokenHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidResetToken,
+		},
+		{
+			name: "user deleted",
+			setup: func(md *mockDB.MockDatabase) {
+				stored := models.PasswordResetToken{UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", tokenHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: ErrInvalidResetToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{
+				db:     mkdb,
+				hasher: testHasher,
+			}
+
+			tt.setup(mkdb)
+
+			if err := s.ResetPassword("reset-token", "new-password"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("userService.ResetPassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("password policy", func(t *testing.T) {
+		s := &userService{
+			db:             mkdb,
+			hasher:         testHasher,
+			passwordPolicy: auth.PasswordPolicy{MinLength: 12},
+		}
+
+		stored := models.PasswordResetToken{ID: 3, UserID: 1, TokenHash: tokenHash, ExpiresAt: time.Now().Add(time.Hour)}
+		mkdb.EXPECT().First(gomock.Any(), "token_hash = ?", tokenHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+		mkdb.EXPECT().First(gomock.Any(), uint(1)).SetArg(0, models.User{Model: gorm.Model{ID: 1}, Username: "rrm"}).Return(&gorm.DB{}).Times(1)
+		// The token stays usable for another attempt
+		mkdb.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		var policyErr *auth.PasswordPolicyError
+		if err := s.ResetPassword("reset-token", "short"); !errors.As(err, &policyErr) {
+			t.Errorf("userService.ResetPassword() error = %v, want PasswordPolicyError", err)
+		}
+	})
+}
+
+func signVerification(t *testing.T, subject, email, purpose string, expiresAt time.Time) string {
+	token, err := testKeys.Sign(&auth.EmailVerificationClaims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{purpose},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func Test_userService_VerifyEmail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	valid := signVerification(t, "1", "rrm@example.com", auth.PurposeVerifyEmail, time.Now().Add(time.Hour))
+	verifiedAt := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		token   string
+		setup   func(*mockDB.MockDatabase)
+		wantErr error
+	}{
+		{
+			name:  "success",
+			token: valid,
+			setup: func(md *mockDB.MockDatabase) {
+				user := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Email: "rrm@example.com"}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, user).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "email = ?", "rrm@example.com").DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+					if values["email_verified_at"] == nil {
+						t.Errorf("email_verified_at was not set")
+					}
+					return &gorm.DB{RowsAffected: 1}
+				}).Times(1)
+			},
+		},
+		{
+			name:  "already verified",
+			token: valid,
+			setup: func(md *mockDB.MockDatabase) {
+				user := models.User{Model: gorm.Model{ID: 1}, Email: "rrm@example.com", EmailVerifiedAt: &verifiedAt}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, user).Return(&gorm.DB{}).Times(1)
+			},
+		},
+		{
+			name:  "email changed since",
+			token: valid,
+			setup: func(md *mockDB.MockDatabase) {
+				user := models.User{Model: gorm.Model{ID: 1}, Email: "new@example.com"}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, user).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidVerificationToken,
+		},
+		{
+			name:  "user gone",
+			token: valid,
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr: ErrInvalidVerificationToken,
+		},
+		{
+			name:    "expired",
+			token:   signVerification(t, "1", "rrm@example.com", auth.PurposeVerifyEmail, time.Now().Add(-time.Minute)),
+			setup:   func(md *mockDB.MockDatabase) {},
+			wantErr: ErrInvalidVerificationToken,
+		},
+		{
+			name:    "other purpose",
+			token:   signVerification(t, "1", "rrm@example.com", "", time.Now().Add(time.Hour)),
+			setup:   func(md *mockDB.MockDatabase) {},
+			wantErr: ErrInvalidVerificationToken,
+		},
+		{
+			name:    "garbage",
+			token:   "not-a-token",
+			setup:   func(md *mockDB.MockDatabase) {},
+			wantErr: ErrInvalidVerificationToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{
+				db:   mkdb,
+				keys: testKeys,
+			}
+
+			tt.setup(mkdb)
+
+			if err := s.VerifyEmail(tt.token); !errors.Is(err, tt.wantErr) {
+				t.Errorf("userService.VerifyEmail() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_userService_ResendVerification(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	verifiedAt := time.Now()
+
+	tests := []struct {
+		name     string
+		email    string
+		setup    func(*mockDB.MockDatabase)
+		wantMail bool
+	}{
+		{
+			name:  "unverified",
+			email: "RRM@example.com",
+			setup: func(md *mockDB.MockDatabase) {
+				user := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Email: "rrm@example.com"}
+				md.EXPECT().First(gomock.Any(), "email = ?", "rrm@example.com").SetArg(0, user).Return(&gorm.DB{}).Times(1)
+			},
+			wantMail: true,
+		},
+		{
+			name:  "already verified",
+			email: "rrm@example.com",
+			setup: func(md *mockDB.MockDatabase) {
+				user := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Email: "rrm@example.com", EmailVerifiedAt: &verifiedAt}
+				md.EXPECT().First(gomock.Any(), "email = ?", "rrm@example.com").SetArg(0, user).Return(&gorm.DB{}).Times(1)
+			},
+		},
+		{
+			name:  "unknown",
+			email: "rrm@example.com",
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "email = ?", "rrm@example.com").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+		},
+		{
+			name:  "invalid address",
+			email: "nope",
+			setup: func(md *mockDB.MockDatabase) {},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outbox bytes.Buffer
+			s := &userService{
+				db:        mkdb,
+				keys:      testKeys,
+				mailer:    mail.NewLogMailer(&outbox),
+				verifyURL: "https://example.com/verify",
+			}
+
+			tt.setup(mkdb)
+
+			if err := s.ResendVerification(tt.email); err != nil {
+				t.Errorf("userService.ResendVerification() error = %v", err)
+			}
+			s.mails.Wait()
+			if sent := strings.Contains(outbox.String(), "https://example.com/verify?token="); sent != tt.wantMail {
+				t.Errorf("mail sent = %v, want %v", sent, tt.wantMail)
+			}
+		})
+	}
+}
+
+func Test_normalizeEmail(t *testing.T) {
+	tests := []struct {
+		email   string
+		want    string
+		wantErr bool
+	}{
+		{email: "", want: ""},
+		{email: "  ", want: ""},
+		{email: "RRM@Example.COM", want: "rrm@example.com"},
+		{email: " rrm@example.com ", want: "rrm@example.com"},
+		{email: "rrm", wantErr: true},
+		{email: "Robert <rrm@example.com>", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			got, err := normalizeEmail(tt.email)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("normalizeEmail() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeEmail() = %q, want %q", got, tt.want)
 			}
 		})
 	}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"go-rest-api/auth"
+	"go-rest-api/models"
+	"strconv"
+	"strings"
+)
+
+// IntrospectToken describes an access token, refresh token or API key issued
+// by this service (RFC 7662). It runs the same checks that authenticate
+// requests, so a token is active exactly when AuthMiddleware or /token/refresh
+// would accept it. Using an API key for introspection counts as using it.
+func (s *userService) IntrospectToken(token string) (models.TokenIntrospection, error) {
+	// Access tokens are JWTs, API keys are "<prefix>.<secret>" and refresh
+	// tokens are opaque base64url strings, which never contain a dot
+	switch strings.Count(token, ".") {
+	case 2:
+		return s.introspectAccessToken(token)
+	case 1:
+		return s.introspectAPIKey(token)
+	default:
+		return s.introspectRefreshToken(token)
+	}
+}
+
+func (s *userService) introspectAccessToken(token string) (models.TokenIntrospection, error) {
+	claims, err := s.ValidateToken(token)
+	if err == ErrInvalidToken || err == ErrTokenRevoked {
+		return models.TokenIntrospection{}, nil
+	}
+	if err != nil {
+		return models.TokenIntrospection{}, err
+	}
+
+	result := models.TokenIntrospection{
+		Active:    true,
+		TokenType: models.TokenTypeAccess,
+		Scope:     strings.Join(auth.Principal{Role: claims.Role}.Permissions(), " "),
+		Username:  claims.Username,
+		Subject:   claims.Subject,
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		JTI:       claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		result.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		result.IssuedAt = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		result.NotBefore = claims.NotBefore.Unix()
+	}
+
+	return result, nil
+}
+
+func (s *userService) introspectAPIKey(token string) (models.TokenIntrospection, error) {
+	key, user, err := s.validateAPIKey(token)
+	if err == ErrInvalidAPIKey {
+		return models.TokenIntrospection{}, nil
+	}
+	if err != nil {
+		return models.TokenIntrospection{}, err
+	}
+
+	result := models.TokenIntrospection{
+		Active:    true,
+		TokenType: models.TokenTypeAPIKey,
+		Scope:     strings.Join(apiKeyPrincipal(key, user).Permissions(), " "),
+		Username:  user.Username,
+		Subject:   strconv.FormatUint(uint64(user.ID), 10),
+		IssuedAt:  key.CreatedAt.Unix(),
+	}
+	if key.ExpiresAt != nil {
+		result.ExpiresAt = key.ExpiresAt.Unix()
+	}
+
+	return result, nil
+}
+
+// introspectRefreshToken reports a rotated refresh token as inactive without
+// revoking its family; only a client presenting it again is treated as reuse
+func (s *userService) introspectRefreshToken(token string) (models.TokenIntrospection, error) {
+	stored, user, err := s.validateRefreshToken(token)
+	if err == ErrInvalidRefreshToken || err == ErrRefreshTokenReused {
+		return models.TokenIntrospection{}, nil
+	}
+	if err != nil {
+		return models.TokenIntrospection{}, err
+	}
+
+	return models.TokenIntrospection{
+		Active:    true,
+		TokenType: models.TokenTypeRefresh,
+		Scope:     strings.Join(auth.Principal{Role: user.Role}.Permissions(), " "),
+		Username:  user.Username,
+		Subject:   strconv.FormatUint(uint64(user.ID), 10),
+		Issuer:    s.claims.Issuer,
+		ExpiresAt: stored.ExpiresAt.Unix(),
+		IssuedAt:  stored.CreatedAt.Unix(),
+	}, nil
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"go-rest-api/auth"
+	mockDB "go-rest-api/database/mocks"
+	"go-rest-api/models"
+	"go-rest-api/utils"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+)
+
+func Test_userService_IntrospectToken(t *testing.T) {
+	admin := models.User{Model: gorm.Model{ID: 2}, Username: "admin", Role: auth.RoleAdmin}
+	claims := auth.ClaimsConfig{Issuer: "https://api.example.com", Audience: []string{"api"}}
+
+	// accessToken issues an access token the regular way and returns its jti
+	accessToken := func(t *testing.T, md *mockDB.MockDatabase) (string, string) {
+		md.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+		issuer := &userService{db: md, keys: testKeys, claims: claims}
+		tokens, err := issuer.issueTokens(admin, "family-1")
+		if err != nil {
+			t.Fatalf("issue tokens: %v", err)
+		}
+		parsed := &auth.Claims{}
+		if _, err := testKeys.Parse(tokens.AccessToken, parsed); err != nil {
+			t.Fatalf("parse access token: %v", err)
+		}
+		return tokens.AccessToken, parsed.ID
+	}
+
+	refreshHash := utils.HashToken("opaque-refresh-token")
+	keyHash := utils.HashToken("gra_abcdefgh.secret")
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	rotated := time.Now().Add(-time.Minute)
+	created := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name  string
+		token func(*testing.T, *mockDB.MockDatabase) string
+		want  models.TokenIntrospection
+	}{
+		{
+			name: "active access token",
+			token: func(t *testing.T, md *mockDB.MockDatabase) string {
+				token, jti := accessToken(t, md)
+				md.EXPECT().Find(gomock.Any(), "jti = ?", jti).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(2)).SetArg(0, admin).Return(&gorm.DB{}).Times(1)
+				return token
+			},
+			want: models.TokenIntrospection{
+				Active:    true,
+				TokenType: models.TokenTypeAccess,
+				Scope:     "profile:read profile:write users:list users:manage users:delete sessions:revoke users:unlock",
+				Username:  "admin",
+				Subject:   "2",
+				Issuer:    "https://api.example.com",
+				Audience:  []string{"api"},
+			},
+		},
+		{
+			name: "revoked access token",
+			token: func(t *testing.T, md *mockDB.MockDatabase) string {
+				token, jti := accessToken(t, md)
+				md.EXPECT().Find(gomock.Any(), "jti = ?", jti).SetArg(0, []models.RevokedToken{{JTI: jti}}).Return(&gorm.DB{}).Times(1)
+				return token
+			},
+		},
+		{
+			name: "API key limited to its scopes",
+			token: func(t *testing.T, md *mockDB.MockDatabase) string {
+				stored := models.APIKey{ID: 3, UserID: 2, KeyHash: keyHash, Scopes: models.Scopes{auth.PermListUsers}, ExpiresAt: &expires, LastUsedAt: &rotated, CreatedAt: created}
+				md.EXPECT().First(gomock.Any(), "key_hash = ?", keyHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), uint(2)).SetArg(0, admin).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Save(gomock.Any()).Return(&gorm.DB{}).Times(1)
+				return "gra_abcdefgh.secret"
+			},
+			want: models.TokenIntrospection{
+				Active:    true,
+				TokenType: models.TokenTypeAPIKey,
+				Scope:     auth.PermListUsers,
+				Username:  "admin",
+				Subject:   "2",
+				ExpiresAt: expires.Unix(),
+				IssuedAt:  created.Unix(),
+			},
+		},
+		{
+			name: "unknown API key",
+			token: func(t *testing.T, md *mockDB.MockDatabase) string {
+				md.EXPECT().First(gomock.Any(), "key_hash = ?", keyHash).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+				return "gra_abcdefgh.secret"
+			},
+		},
+		{
+			name: "active refresh token",
+			token: func(t *testing.T, md *mockDB.MockDatabase) string {
+				stored := models.RefreshToken{Model: gorm.Model{CreatedAt: created}, UserID: 2, TokenHash: refreshHash, FamilyID: "family-1", ExpiresAt: expires}
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", refreshHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), uint(2)).SetArg(0, admin).Return(&gorm.DB{}).Times(1)
+				return "opaque-refresh-token"
+			},
+			want: models.TokenIntrospection{
+				Active:    true,
+				TokenType: models.TokenTypeRefresh,
+				Scope:     "profile:read profile:write users:list users:manage users:delete sessions:revoke users:unlock",
+				Username:  "admin",
+				Subject:   "2",
+				Issuer:    "https://api.example.com",
+				ExpiresAt: expires.Unix(),
+				IssuedAt:  created.Unix(),
+			},
+		},
+		{
+			name: "rotated refresh token does not revoke its family",
+			token: func(t *testing.T, md *mockDB.MockDatabase) string {
+				stored := models.RefreshToken{UserID: 2, TokenHash: refreshHash, FamilyID: "family-1", ExpiresAt: expires, RevokedAt: &rotated}
+				md.EXPECT().First(gomock.Any(), "token_hash = ?", refreshHash).SetArg(0, stored).Return(&gorm.DB{}).Times(1)
+				return "opaque-refresh-token"
+			},
+		},
+		{
+			name: "garbage",
+			token: func(t *testing.T, md *mockDB.MockDatabase) string {
+				return "not.a.jwt"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mkdb := mockDB.NewMockDatabase(ctrl)
+			s := &userService{db: mkdb, keys: testKeys, revocations: NewRevocationStore(mkdb), claims: claims}
+
+			got, err := s.IntrospectToken(tt.token(t, mkdb))
+			if err != nil {
+				t.Fatalf("userService.IntrospectToken() error = %v", err)
+			}
+
+			// Timestamps of access tokens depend on when they were issued
+			if got.TokenType == models.TokenTypeAccess {
+				if got.ExpiresAt == 0 || got.IssuedAt == 0 || got.NotBefore == 0 || got.JTI == "" {
+					t.Errorf("userService.IntrospectToken() = %+v, want exp, iat, nbf and jti", got)
+				}
+				got.ExpiresAt, got.IssuedAt, got.NotBefore, got.JTI = 0, 0, 0, ""
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("userService.IntrospectToken() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
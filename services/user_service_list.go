@@ -0,0 +1,198 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go-rest-api/models"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUserPageSize = 20
+	maxUserPageSize     = 100
+)
+
+// userSortColumns maps the fields users can be sorted by to their columns.
+// Anything else is rejected rather than passed on to ORDER BY.
+var userSortColumns = map[string]string{
+	"id":         "id",
+	"username":   "username",
+	"country":    "country",
+	"created_at": "created_at",
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// userCursor points just after the last user of a page: its value of the sort
+// field and its ID, which breaks ties between equal values
+type userCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    uint   `json:"id"`
+}
+
+// GetUsers returns the page of users selected by query. Users are ordered by
+// the sort field and then by ID, so that pages neither overlap nor skip users
+// while others sign up.
+func (s *userService) GetUsers(query models.UserQuery) (models.UserPage, error) {
+	sort := query.Sort
+	if sort == "" {
+		sort = "id"
+	}
+	field, desc := strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+	column, ok := userSortColumns[field]
+	if !ok {
+		return models.UserPage{}, fmt.Errorf("%w: cannot sort by %q", ErrInvalidUserQuery, field)
+	}
+
+	limit := query.Limit
+	switch {
+	case limit < 0:
+		return models.UserPage{}, fmt.Errorf("%w: limit must not be negative", ErrInvalidUserQuery)
+	case limit == 0:
+		limit = defaultUserPageSize
+	case limit > maxUserPageSize:
+		limit = maxUserPageSize
+	}
+	if query.Offset < 0 {
+		return models.UserPage{}, fmt.Errorf("%w: offset must not be negative", ErrInvalidUserQuery)
+	}
+	if query.Offset > 0 && query.Cursor != "" {
+		return models.UserPage{}, fmt.Errorf("%w: cursor and offset cannot be combined", ErrInvalidUserQuery)
+	}
+
+	conditions, args := userFilters(query)
+	db := s.db
+	if query.Deleted {
+		db = s.db.Unscoped()
+	}
+
+	var total int64
+	if err := db.Count(&models.User{}, &total, whereClause(conditions, args)...).Error; err != nil {
+		return models.UserPage{}, err
+	}
+
+	if query.Cursor != "" {
+		condition, values, err := cursorCondition(query.Cursor, sort, column, desc)
+		if err != nil {
+			return models.UserPage{}, err
+		}
+		conditions = append(conditions, condition)
+		args = append(args, values...)
+	}
+
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+	order := "id " + direction
+	if column != "id" {
+		order = fmt.Sprintf("%s %s, %s", column, direction, order)
+	}
+
+	// One user more than asked for tells whether there is a next page
+	var users []models.User
+	if err := db.FindPage(&users, order, limit+1, query.Offset, whereClause(conditions, args)...).Error; err != nil {
+		return models.UserPage{}, err
+	}
+
+	page := models.UserPage{Users: users, Total: total}
+	if len(users) > limit {
+		page.Users = users[:limit]
+		page.NextCursor = encodeUserCursor(sort, field, page.Users[limit-1])
+	}
+
+	return page, nil
+}
+
+// userFilters turns the filters of query into SQL conditions and their arguments
+func userFilters(query models.UserQuery) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if query.Deleted {
+		conditions = append(conditions, "deleted_at IS NOT NULL")
+	}
+	if query.Country != "" {
+		conditions = append(conditions, "country = ?")
+		args = append(args, query.Country)
+	}
+	if query.UsernamePrefix != "" {
+		conditions = append(conditions, "username LIKE ?")
+		args = append(args, likeEscaper.Replace(query.UsernamePrefix)+"%")
+	}
+	if query.CreatedAfter != nil {
+		conditions = append(conditions, "created_at > ?")
+		args = append(args, *query.CreatedAfter)
+	}
+	if query.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, *query.CreatedBefore)
+	}
+
+	return conditions, args
+}
+
+// whereClause joins conditions into the inline conditions of Find and Count
+func whereClause(conditions []string, args []interface{}) []interface{} {
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	return append([]interface{}{strings.Join(conditions, " AND ")}, args...)
+}
+
+func encodeUserCursor(sort, field string, last models.User) string {
+	cursor := userCursor{Sort: sort, ID: last.ID}
+	switch field {
+	case "username":
+		cursor.Value = last.Username
+	case "country":
+		cursor.Value = last.Country
+	case "created_at":
+		cursor.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// cursorCondition decodes a cursor of a previous page and returns the
+// condition selecting the users after it
+func cursorCondition(encoded, sort, column string, desc bool) (string, []interface{}, error) {
+	invalid := fmt.Errorf("%w: invalid cursor", ErrInvalidUserQuery)
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, invalid
+	}
+	var cursor userCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return "", nil, invalid
+	}
+	if cursor.Sort != sort {
+		return "", nil, fmt.Errorf("%w: cursor belongs to another sort order", ErrInvalidUserQuery)
+	}
+
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	if column == "id" {
+		return "id " + op + " ?", []interface{}{cursor.ID}, nil
+	}
+
+	var value interface{} = cursor.Value
+	if column == "created_at" {
+		value, err = time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return "", nil, invalid
+		}
+	}
+
+	condition := fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, op, column, op)
+	return condition, []interface{}{value, value, cursor.ID}, nil
+}
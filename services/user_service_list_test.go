@@ -0,0 +1,158 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	mockDB "go-rest-api/database/mocks"
+	"go-rest-api/models"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+)
+
+func Test_userService_GetUsers(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	users := []models.User{
+		{Model: gorm.Model{ID: 1, CreatedAt: created}, Username: "rrm", Country: "usa"},
+		{Model: gorm.Model{ID: 2, CreatedAt: created}, Username: "rrm2", Country: "usa"},
+		{Model: gorm.Model{ID: 3, CreatedAt: created.Add(time.Hour)}, Username: "rrm3", Country: "usa"},
+	}
+	cursorAfter := func(sort, field string, user models.User) string {
+		return encodeUserCursor(sort, field, user)
+	}
+
+	tests := []struct {
+		name    string
+		query   models.UserQuery
+		setup   func(*mockDB.MockDatabase)
+		want    models.UserPage
+		wantErr error
+	}{
+		{
+			name:  "first page with the default order",
+			query: models.UserQuery{Limit: 2},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Count(gomock.Any(), gomock.Any()).SetArg(1, int64(3)).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().FindPage(gomock.Any(), "id ASC", 3, 0).SetArg(0, users).Return(&gorm.DB{}).Times(1)
+			},
+			want: models.UserPage{Users: users[:2], NextCursor: cursorAfter("id", "id", users[1]), Total: 3},
+		},
+		{
+			name:  "last page after a cursor",
+			query: models.UserQuery{Limit: 2, Cursor: cursorAfter("id", "id", users[1])},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Count(gomock.Any(), gomock.Any()).SetArg(1, int64(3)).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().FindPage(gomock.Any(), "id ASC", 3, 0, "id > ?", uint(2)).SetArg(0, users[2:]).Return(&gorm.DB{}).Times(1)
+			},
+			want: models.UserPage{Users: users[2:], Total: 3},
+		},
+		{
+			name: "filters",
+			query: models.UserQuery{
+				Country:        "usa",
+				UsernamePrefix: "rr_",
+				CreatedAfter:   &created,
+				Sort:           "-created_at",
+				Offset:         1,
+			},
+			setup: func(md *mockDB.MockDatabase) {
+				where := "country = ? AND username LIKE ? AND created_at > ?"
+				md.EXPECT().Count(gomock.Any(), gomock.Any(), where, "usa", `rr\_%`, created).SetArg(1, int64(1)).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().FindPage(gomock.Any(), "created_at DESC, id DESC", 21, 1, where, "usa", `rr\_%`, created).SetArg(0, users[2:]).Return(&gorm.DB{}).Times(1)
+			},
+			want: models.UserPage{Users: users[2:], Total: 1},
+		},
+		{
+			name:  "deleted users",
+			query: models.UserQuery{Country: "usa", Deleted: true},
+			setup: func(md *mockDB.MockDatabase) {
+				where := "deleted_at IS NOT NULL AND country = ?"
+				md.EXPECT().Unscoped().Return(md).Times(1)
+				md.EXPECT().Count(gomock.Any(), gomock.Any(), where, "usa").SetArg(1, int64(1)).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().FindPage(gomock.Any(), "id ASC", 21, 0, where, "usa").SetArg(0, users[:1]).Return(&gorm.DB{}).Times(1)
+			},
+			want: models.UserPage{Users: users[:1], Total: 1},
+		},
+		{
+			name:  "cursor on a sort field with ties",
+			query: models.UserQuery{Sort: "created_at", Limit: 1, Cursor: cursorAfter("created_at", "created_at", users[0])},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Count(gomock.Any(), gomock.Any()).SetArg(1, int64(3)).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().FindPage(gomock.Any(), "created_at ASC, id ASC", 2, 0, "(created_at > ? OR (created_at = ? AND id > ?))", created, created, uint(1)).
+					SetArg(0, users[1:]).Return(&gorm.DB{}).Times(1)
+			},
+			want: models.UserPage{Users: users[1:2], NextCursor: cursorAfter("created_at", "created_at", users[1]), Total: 3},
+		},
+		{
+			name:  "limit is capped",
+			query: models.UserQuery{Limit: 1000},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Count(gomock.Any(), gomock.Any()).SetArg(1, int64(3)).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().FindPage(gomock.Any(), "id ASC", maxUserPageSize+1, 0).SetArg(0, users).Return(&gorm.DB{}).Times(1)
+			},
+			want: models.UserPage{Users: users, Total: 3},
+		},
+		{
+			name:    "sort field not allowed",
+			query:   models.UserQuery{Sort: "password"},
+			wantErr: ErrInvalidUserQuery,
+		},
+		{
+			name:    "cursor and offset",
+			query:   models.UserQuery{Offset: 20, Cursor: cursorAfter("id", "id", users[1])},
+			wantErr: ErrInvalidUserQuery,
+		},
+		{
+			name:  "cursor of another sort order",
+			query: models.UserQuery{Sort: "-id", Cursor: cursorAfter("id", "id", users[1])},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Count(gomock.Any(), gomock.Any()).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidUserQuery,
+		},
+		{
+			name:  "malformed cursor",
+			query: models.UserQuery{Cursor: "not a cursor"},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Count(gomock.Any(), gomock.Any()).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidUserQuery,
+		},
+		{
+			name:  "failure: invalid query",
+			query: models.UserQuery{},
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Count(gomock.Any(), gomock.Any()).Return(&gorm.DB{Error: fmt.Errorf("invalid query syntax")}).Times(1)
+			},
+			wantErr: errors.New("invalid query syntax"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mkdb := mockDB.NewMockDatabase(ctrl)
+			s := &userService{db: mkdb}
+
+			if tt.setup != nil {
+				tt.setup(mkdb)
+			}
+
+			got, err := s.GetUsers(tt.query)
+			if tt.wantErr != nil {
+				if err == nil || (!errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error()) {
+					t.Errorf("userService.GetUsers() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("userService.GetUsers() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("userService.GetUsers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go-rest-api/auth"
+	"go-rest-api/models"
+	"go-rest-api/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// EnrollTOTP starts enrolling an authenticator for the caller in ctx. The
+// returned secret only takes effect once a code is confirmed with ConfirmTOTP;
+// enrolling again before that replaces it.
+func (s *userService) EnrollTOTP(ctx context.Context) (models.TOTPEnrollment, error) {
+	if err := requireLogin(ctx, "enroll two-factor authentication"); err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+
+	user, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+
+	if user.TOTPEnabled {
+		return models.TOTPEnrollment{}, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+
+	if err := s.updateUser(&user, map[string]interface{}{"totp_secret": secret}); err != nil {
+		return models.TOTPEnrollment{}, err
+	}
+
+	return models.TOTPEnrollment{
+		Secret: secret,
+		URI:    auth.TOTPURI(s.totpIssuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication for the caller in ctx once
+// code matches the enrolled secret, and returns a fresh set of recovery codes.
+// The codes are only ever shown here.
+func (s *userService) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	if err := requireLogin(ctx, "enable two-factor authentication"); err != nil {
+		return nil, err
+	}
+
+	user, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+
+	step, ok := auth.VerifyTOTP(user.TOTPSecret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, err := s.replaceRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.updateUser(&user, map[string]interface{}{"totp_enabled": true, "totp_last_step": step}); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off for the caller in ctx. It
+// takes a current TOTP or recovery code so that a stolen access token alone
+// cannot remove the second factor. Wrong codes count towards the same lockout
+// as wrong passwords, so it cannot be used to guess them either.
+func (s *userService) DisableTOTP(ctx context.Context, code, clientIP string) error {
+	if err := requireLogin(ctx, "disable two-factor authentication"); err != nil {
+		return err
+	}
+
+	user, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !user.TOTPEnabled {
+		return ErrMFANotEnrolled
+	}
+
+	if err := s.verifyWithLockout(&user, code, clientIP); err != nil {
+		return err
+	}
+
+	if err := s.db.Delete(&models.RecoveryCode{}, "user_id = ?", user.ID).Error; err != nil {
+		return err
+	}
+
+	return s.updateUser(&user, map[string]interface{}{"totp_enabled": false, "totp_secret": "", "totp_last_step": 0})
+}
+
+// LoginMFA completes a login that Login answered with an MFARequiredError. It
+// accepts a TOTP code or one of the user's unused recovery codes. Wrong codes
+// count towards the same lockout as wrong passwords. The mfa token is used up
+// by a successful login.
+func (s *userService) LoginMFA(mfaToken, code, clientIP string) (models.TokenPair, error) {
+	claims := &auth.MFAClaims{}
+	parsed, err := s.keys.Parse(mfaToken, claims)
+	if err != nil || !parsed.Valid || !claims.VerifyAudience(auth.PurposeMFA, true) || claims.ID == "" {
+		return models.TokenPair{}, ErrInvalidMFAToken
+	}
+
+	revoked, err := s.revocations.IsRevoked(claims.ID)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	if revoked {
+		return models.TokenPair{}, ErrInvalidMFAToken
+	}
+
+	user, err := s.userByID(claims.Subject)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return models.TokenPair{}, ErrInvalidMFAToken
+		}
+
+		return models.TokenPair{}, err
+	}
+
+	// Sessions were reset or MFA was turned off since the password step
+	if user.TokenVersion != claims.TokenVersion || !user.TOTPEnabled {
+		return models.TokenPair{}, ErrInvalidMFAToken
+	}
+
+	if err := s.verifyWithLockout(&user, code, clientIP); err != nil {
+		return models.TokenPair{}, err
+	}
+
+	// The jti is unique among revoked tokens, so of concurrent logins with
+	// the same mfa token only the first gets past this
+	if err := s.revocations.Revoke(claims.ID, claims.ExpiresAt.Time); err != nil {
+		return models.TokenPair{}, err
+	}
+
+	familyID, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	return s.issueTokens(user, familyID)
+}
+
+// mfaChallenge returns the MFARequiredError that Login answers with when the
+// user has two-factor authentication enabled
+func (s *userService) mfaChallenge(user models.User) error {
+	jti, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	token, err := s.keys.Sign(&auth.MFAClaims{
+		TokenVersion: user.TokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  jwt.ClaimStrings{auth.PurposeMFA},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaTokenTTL)),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return &MFARequiredError{Challenge: models.MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(mfaTokenTTL.Seconds()),
+	}}
+}
+
+// verifyWithLockout runs verifySecondFactor unless the user or client address
+// is locked out, and counts a wrong code as a failed login
+func (s *userService) verifyWithLockout(user *models.User, code, clientIP string) error {
+	lockoutKey := userLockoutKey(*user, "")
+	if err := s.lockout.check(lockoutKey, clientIP); err != nil {
+		return err
+	}
+
+	if err := s.verifySecondFactor(user, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			if err := s.lockout.fail(lockoutKey, clientIP); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	return s.lockout.succeed(lockoutKey)
+}
+
+// verifySecondFactor accepts a TOTP code or an unused recovery code for user
+// and records its use, so that neither can be replayed
+func (s *userService) verifySecondFactor(user *models.User, code string) error {
+	code = strings.TrimSpace(code)
+
+	if step, ok := auth.VerifyTOTP(user.TOTPSecret, code, time.Now()); ok {
+		if step <= user.TOTPLastStep {
+			return ErrInvalidMFACode
+		}
+
+		// Of concurrent logins with the same code only the first gets it
+		result := s.updateUserWhere(user, map[string]interface{}{"totp_last_step": step}, "totp_last_step < ?", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidMFACode
+		}
+
+		return nil
+	}
+
+	return s.useRecoveryCode(user.ID, code)
+}
+
+// useRecoveryCode marks the matching unused recovery code of a user as used
+func (s *userService) useRecoveryCode(userID uint, code string) error {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return ErrInvalidMFACode
+	}
+
+	var codes []models.RecoveryCode
+	if err := s.db.Find(&codes, "user_id = ? AND used_at IS NULL", userID).Error; err != nil {
+		return err
+	}
+
+	for i := range codes {
+		if !utils.CheckPasswordHash(code, codes[i].CodeHash) {
+			continue
+		}
+
+		// Of concurrent logins with the same code only the first gets it
+		result := s.db.UpdateWhere(&codes[i], map[string]interface{}{"used_at": time.Now()}, "used_at IS NULL")
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidMFACode
+		}
+
+		return nil
+	}
+
+	return ErrInvalidMFACode
+}
+
+// replaceRecoveryCodes drops every recovery code of a user and stores the
+// hashes of a new set, which is returned in plain text
+func (s *userService) replaceRecoveryCodes(userID uint) ([]string, error) {
+	if err := s.db.Delete(&models.RecoveryCode{}, "user_id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := utils.HashPassword(normalizeRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.db.Create(&models.RecoveryCode{UserID: userID, CodeHash: hash}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// newRecoveryCode returns a random code formatted as xxxx-xxxx-xxxx-xxxx
+func newRecoveryCode() (string, error) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	raw := strings.ToLower(secret[:16])
+	return raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16], nil
+}
+
+// normalizeRecoveryCode accepts recovery codes with any case and separators
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
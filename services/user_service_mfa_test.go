@@ -0,0 +1,408 @@
+package services
+
+import (
+	"errors"
+	"go-rest-api/auth"
+	mockDB "go-rest-api/database/mocks"
+	"go-rest-api/models"
+	"go-rest-api/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+)
+
+func signMFAToken(t *testing.T, subject string, version uint, purpose string) string {
+	token, err := testKeys.Sign(&auth.MFAClaims{
+		TokenVersion: version,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "mfa-jti",
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{purpose},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func Test_userService_Login_MFARequired(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	hashed, _ := utils.HashPassword("secret")
+	user := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Password: hashed, TOTPEnabled: true, TokenVersion: 2}
+	mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, user).Return(&gorm.DB{}).Times(1)
+
+	s := &userService{db: mkdb, keys: testKeys, lockout: newTestLimiter(), hasher: testHasher}
+
+	_, err := s.Login("rrm", "secret", "192.0.2.1")
+	var mfaErr *MFARequiredError
+	if !errors.As(err, &mfaErr) {
+		t.Fatalf("userService.Login() error = %v, want MFARequiredError", err)
+	}
+
+	claims := &auth.MFAClaims{}
+	if _, err := testKeys.Parse(mfaErr.Challenge.MFAToken, claims); err != nil {
+		t.Fatalf("mfa token does not parse: %v", err)
+	}
+	if !claims.VerifyAudience(auth.PurposeMFA, true) || claims.ID == "" || claims.Subject != "1" || claims.TokenVersion != 2 {
+		t.Errorf("unexpected mfa claims %+v", claims)
+	}
+
+	// The mfa token must not work as an access token
+	if _, err := s.ValidateToken(mfaErr.Challenge.MFAToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("userService.ValidateToken(mfa token) error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func Test_userService_EnrollTOTP(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	s := &userService{db: mkdb, totpIssuer: "go-rest-api"}
+
+	t.Run("success", func(t *testing.T) {
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Username: "rrm"}).Return(&gorm.DB{}).Times(1)
+		mkdb.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "id = ?", uint(0)).DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+			if values["totp_secret"] == "" || values["totp_enabled"] != nil {
+				t.Errorf("totp_secret = %v, totp_enabled = %v, want pending secret", values["totp_secret"], values["totp_enabled"])
+			}
+			return &gorm.DB{RowsAffected: 1}
+		}).Times(1)
+
+		got, err := s.EnrollTOTP(ownerCtx)
+		if err != nil {
+			t.Fatalf("userService.EnrollTOTP() error = %v", err)
+		}
+		if got.Secret == "" || !strings.Contains(got.URI, "secret="+got.Secret) || !strings.Contains(got.URI, "go-rest-api:rrm") {
+			t.Errorf("userService.EnrollTOTP() = %+v", got)
+		}
+	})
+
+	t.Run("already enabled", func(t *testing.T) {
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Username: "rrm", TOTPEnabled: true}).Return(&gorm.DB{}).Times(1)
+
+		if _, err := s.EnrollTOTP(ownerCtx); !errors.Is(err, ErrMFAAlreadyEnabled) {
+			t.Errorf("userService.EnrollTOTP() error = %v, want %v", err, ErrMFAAlreadyEnabled)
+		}
+	})
+}
+
+func Test_userService_ConfirmTOTP(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	secret, _ := auth.GenerateTOTPSecret()
+	code, _ := auth.TOTPCode(secret, time.Now())
+
+	tests := []struct {
+		name    string
+		code    string
+		setup   func(*mockDB.MockDatabase)
+		wantErr error
+	}{
+		{
+			name: "success",
+			code: code,
+			setup: func(md *mockDB.MockDatabase) {
+				user := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", TOTPSecret: secret}
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, user).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Delete(gomock.Any(), "user_id = ?", uint(1)).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Create(gomock.Any()).DoAndReturn(func(value interface{}) *gorm.DB {
+					if value.(*models.RecoveryCode).CodeHash == "" {
+						t.Errorf("recovery code stored without hash")
+					}
+					return &gorm.DB{}
+				}).Times(recoveryCodeCount)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "id = ?", uint(1)).DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+					if values["totp_enabled"] != true || values["totp_last_step"] == int64(0) {
+						t.Errorf("totp_enabled = %v, totp_last_step = %v", values["totp_enabled"], values["totp_last_step"])
+					}
+					return &gorm.DB{RowsAffected: 1}
+				}).Times(1)
+			},
+		},
+		{
+			name: "wrong code",
+			code: "000000",
+			setup: func(md *mockDB.MockDatabase) {
+				user := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", TOTPSecret: secret}
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, user).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidMFACode,
+		},
+		{
+			name: "not enrolled",
+			code: code,
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Username: "rrm"}).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrMFANotEnrolled,
+		},
+		{
+			name: "already enabled",
+			code: code,
+			setup: func(md *mockDB.MockDatabase) {
+				user := models.User{Username: "rrm", TOTPSecret: secret, TOTPEnabled: true}
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, user).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrMFAAlreadyEnabled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{db: mkdb}
+
+			tt.setup(mkdb)
+
+			codes, err := s.ConfirmTOTP(ownerCtx, tt.code)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("userService.ConfirmTOTP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && len(codes) != recoveryCodeCount {
+				t.Errorf("userService.ConfirmTOTP() returned %d codes, want %d", len(codes), recoveryCodeCount)
+			}
+		})
+	}
+}
+
+func Test_userService_LoginMFA(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	now := time.Now()
+	secret, _ := auth.GenerateTOTPSecret()
+	code, _ := auth.TOTPCode(secret, now)
+	step := now.Unix() / 30
+	recoveryHash, _ := utils.HashPassword("abcdabcdabcdabcd")
+	otherHash, _ := utils.HashPassword("efghefghefghefgh")
+
+	enrolled := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", TOTPSecret: secret, TOTPEnabled: true, TokenVersion: 2}
+	mfaToken := signMFAToken(t, "1", 2, auth.PurposeMFA)
+	unused := func(md *mockDB.MockDatabase) {
+		md.EXPECT().Find(gomock.Any(), "jti = ?", "mfa-jti").Return(&gorm.DB{}).Times(1)
+	}
+	// A successful login revokes the mfa token and stores the refresh token
+	consume := func(md *mockDB.MockDatabase) {
+		md.EXPECT().Delete(gomock.Any(), "expires_at < ?", gomock.Any()).Return(&gorm.DB{}).Times(1)
+		md.EXPECT().Create(gomock.Any()).DoAndReturn(func(value interface{}) *gorm.DB {
+			if revoked := value.(*models.RevokedToken); revoked.JTI != "mfa-jti" {
+				t.Errorf("revoked jti = %q, want the mfa token's", revoked.JTI)
+			}
+			return &gorm.DB{}
+		}).Times(1)
+		md.EXPECT().Create(gomock.Any()).Return(&gorm.DB{}).Times(1)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		code    string
+		setup   func(*mockDB.MockDatabase)
+		wantErr error
+	}{
+		{
+			name:  "totp code",
+			token: mfaToken,
+			code:  code,
+			setup: func(md *mockDB.MockDatabase) {
+				unused(md)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, enrolled).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "totp_last_step < ?", gomock.Any()).DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+					if got := values["totp_last_step"].(int64); got < step-1 {
+						t.Errorf("totp_last_step = %d, want about %d", got, step)
+					}
+					return &gorm.DB{RowsAffected: 1}
+				}).Times(1)
+				consume(md)
+			},
+		},
+		{
+			name:  "totp code used concurrently",
+			token: mfaToken,
+			code:  code,
+			setup: func(md *mockDB.MockDatabase) {
+				unused(md)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, enrolled).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "totp_last_step < ?", gomock.Any()).Return(&gorm.DB{RowsAffected: 0}).Times(1)
+			},
+			wantErr: ErrInvalidMFACode,
+		},
+		{
+			name:  "replayed totp code",
+			token: mfaToken,
+			code:  code,
+			setup: func(md *mockDB.MockDatabase) {
+				unused(md)
+				used := enrolled
+				used.TOTPLastStep = step + 1
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, used).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidMFACode,
+		},
+		{
+			name:  "recovery code",
+			token: mfaToken,
+			code:  "ABCD-ABCD-ABCD-ABCD",
+			setup: func(md *mockDB.MockDatabase) {
+				unused(md)
+				codes := []models.RecoveryCode{{ID: 7, UserID: 1, CodeHash: otherHash}, {ID: 8, UserID: 1, CodeHash: recoveryHash}}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, enrolled).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Find(gomock.Any(), "user_id = ? AND used_at IS NULL", uint(1)).SetArg(0, codes).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "used_at IS NULL").DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+					if used := model.(*models.RecoveryCode); used.ID != 8 || values["used_at"] == nil {
+						t.Errorf("recovery code %d was marked used = %v, want code 8", used.ID, values["used_at"])
+					}
+					return &gorm.DB{RowsAffected: 1}
+				}).Times(1)
+				consume(md)
+			},
+		},
+		{
+			name:  "recovery code used concurrently",
+			token: mfaToken,
+			code:  "abcd-abcd-abcd-abcd",
+			setup: func(md *mockDB.MockDatabase) {
+				unused(md)
+				codes := []models.RecoveryCode{{ID: 8, UserID: 1, CodeHash: recoveryHash}}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, enrolled).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Find(gomock.Any(), "user_id = ? AND used_at IS NULL", uint(1)).SetArg(0, codes).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "used_at IS NULL").Return(&gorm.DB{RowsAffected: 0}).Times(1)
+			},
+			wantErr: ErrInvalidMFACode,
+		},
+		{
+			name:  "mfa token already used",
+			token: mfaToken,
+			code:  code,
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().Find(gomock.Any(), "jti = ?", "mfa-jti").SetArg(0, []models.RevokedToken{{JTI: "mfa-jti"}}).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidMFAToken,
+		},
+		{
+			name:  "unknown recovery code",
+			token: mfaToken,
+			code:  "zzzz-zzzz-zzzz-zzzz",
+			setup: func(md *mockDB.MockDatabase) {
+				unused(md)
+				codes := []models.RecoveryCode{{ID: 7, UserID: 1, CodeHash: otherHash}}
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, enrolled).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().Find(gomock.Any(), "user_id = ? AND used_at IS NULL", uint(1)).SetArg(0, codes).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidMFACode,
+		},
+		{
+			name:  "sessions reset since password step",
+			token: signMFAToken(t, "1", 1, auth.PurposeMFA),
+			code:  code,
+			setup: func(md *mockDB.MockDatabase) {
+				unused(md)
+				md.EXPECT().First(gomock.Any(), "id = ?", uint(1)).SetArg(0, enrolled).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr: ErrInvalidMFAToken,
+		},
+		{
+			name:    "token with other purpose",
+			token:   signMFAToken(t, "1", 2, auth.PurposeVerifyEmail),
+			code:    code,
+			setup:   func(md *mockDB.MockDatabase) {},
+			wantErr: ErrInvalidMFAToken,
+		},
+		{
+			name:    "garbage token",
+			token:   "not-a-token",
+			code:    code,
+			setup:   func(md *mockDB.MockDatabase) {},
+			wantErr: ErrInvalidMFAToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userService{db: mkdb, keys: testKeys, lockout: newTestLimiter(), hasher: testHasher, revocations: NewRevocationStore(mkdb)}
+
+			tt.setup(mkdb)
+
+			got, err := s.LoginMFA(tt.token, tt.code, "192.0.2.1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("userService.LoginMFA() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr == nil && (got.AccessToken == "" || got.RefreshToken == "") {
+				t.Errorf("userService.LoginMFA() = %v, want access and refresh token", got)
+			}
+		})
+	}
+}
+
+func Test_userService_DisableTOTP(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+
+	secret, _ := auth.GenerateTOTPSecret()
+	code, _ := auth.TOTPCode(secret, time.Now())
+	enrolled := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", TOTPSecret: secret, TOTPEnabled: true}
+
+	s := &userService{db: mkdb, lockout: newTestLimiter()}
+
+	t.Run("success", func(t *testing.T) {
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, enrolled).Return(&gorm.DB{}).Times(1)
+		gomock.InOrder(
+			mkdb.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "totp_last_step < ?", gomock.Any()).Return(&gorm.DB{RowsAffected: 1}),
+			mkdb.EXPECT().Delete(gomock.Any(), "user_id = ?", uint(1)).Return(&gorm.DB{}),
+			mkdb.EXPECT().UpdateWhere(gomock.Any(), gomock.Any(), "id = ?", uint(1)).DoAndReturn(func(model interface{}, values map[string]interface{}, query interface{}, args ...interface{}) *gorm.DB {
+				if values["totp_enabled"] != false || values["totp_secret"] != "" {
+					t.Errorf("totp_enabled = %v, totp_secret = %v, want disabled", values["totp_enabled"], values["totp_secret"])
+				}
+				return &gorm.DB{RowsAffected: 1}
+			}),
+		)
+
+		if err := s.DisableTOTP(ownerCtx, code, "192.0.2.1"); err != nil {
+			t.Errorf("userService.DisableTOTP() error = %v", err)
+		}
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, models.User{Username: "rrm"}).Return(&gorm.DB{}).Times(1)
+
+		if err := s.DisableTOTP(ownerCtx, code, "192.0.2.1"); !errors.Is(err, ErrMFANotEnrolled) {
+			t.Errorf("userService.DisableTOTP() error = %v, want %v", err, ErrMFANotEnrolled)
+		}
+	})
+
+	t.Run("wrong codes lock out", func(t *testing.T) {
+		mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, enrolled).Return(&gorm.DB{}).Times(testLockoutPolicy.MaxFailures + 1)
+		mkdb.EXPECT().Find(gomock.Any(), "user_id = ? AND used_at IS NULL", uint(1)).Return(&gorm.DB{}).Times(testLockoutPolicy.MaxFailures)
+
+		for i := 0; i < testLockoutPolicy.MaxFailures; i++ {
+			if err := s.DisableTOTP(ownerCtx, "wrong-code", "192.0.2.1"); !errors.Is(err, ErrInvalidMFACode) {
+				t.Fatalf("userService.DisableTOTP() error = %v, want %v", err, ErrInvalidMFACode)
+			}
+		}
+
+		var lockedErr *LockedOutError
+		if err := s.DisableTOTP(ownerCtx, code, "192.0.2.1"); !errors.As(err, &lockedErr) {
+			t.Errorf("userService.DisableTOTP() error = %v, want LockedOutError", err)
+		}
+	})
+}
+
+func Test_normalizeRecoveryCode(t *testing.T) {
+	code, err := newRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 19 || strings.Count(code, "-") != 3 {
+		t.Errorf("newRecoveryCode() = %q, want xxxx-xxxx-xxxx-xxxx", code)
+	}
+	if got := normalizeRecoveryCode(" ABCD-efgh ijkl-MNOP"); got != "abcdefghijklmnop" {
+		t.Errorf("normalizeRecoveryCode() = %q", got)
+	}
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"go-rest-api/auth"
+	"go-rest-api/models"
+	"go-rest-api/oidc"
+	"go-rest-api/utils"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+// usernameUnsafe matches what is dropped from provider names before they are
+// used as usernames
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// StartOIDCLogin starts a sign in at an external provider. With link set, the
+// identity is linked to the caller in ctx once the provider sends the user
+// back, instead of signing in whoever it belongs to.
+func (s *userService) StartOIDCLogin(ctx context.Context, provider string, link bool) (models.OIDCRedirect, error) {
+	p, ok := s.oidcProviders[provider]
+	if !ok {
+		return models.OIDCRedirect{}, ErrUnknownProvider
+	}
+
+	claims := &auth.OIDCStateClaims{
+		Provider: provider,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{auth.PurposeOIDCState},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcStateTTL)),
+		},
+	}
+	if link {
+		if err := requireLogin(ctx, "link identity"); err != nil {
+			return models.OIDCRedirect{}, err
+		}
+
+		user, err := s.GetCurrentUser(ctx)
+		if err != nil {
+			return models.OIDCRedirect{}, err
+		}
+		claims.LinkUserID = user.ID
+	}
+
+	var err error
+	if claims.State, err = utils.GenerateRandomToken(16); err != nil {
+		return models.OIDCRedirect{}, err
+	}
+	if claims.Nonce, err = utils.GenerateRandomToken(16); err != nil {
+		return models.OIDCRedirect{}, err
+	}
+	if claims.CodeVerifier, err = oidc.NewCodeVerifier(); err != nil {
+		return models.OIDCRedirect{}, err
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, claims.State, claims.Nonce, oidc.CodeChallenge(claims.CodeVerifier))
+	if err != nil {
+		return models.OIDCRedirect{}, err
+	}
+
+	stateToken, err := s.keys.Sign(claims)
+	if err != nil {
+		return models.OIDCRedirect{}, err
+	}
+
+	return models.OIDCRedirect{AuthorizationURL: authURL, StateToken: stateToken}, nil
+}
+
+// CompleteOIDCLogin finishes a sign in when the provider redirects back with
+// state and code. stateToken is the one StartOIDCLogin returned. The identity
+// is resolved to a user, linking or creating one as the provider config
+// allows, and tokens are issued just like Login does.
+func (s *userService) CompleteOIDCLogin(ctx context.Context, provider, stateToken, state, code string) (models.TokenPair, error) {
+	p, ok := s.oidcProviders[provider]
+	if !ok {
+		return models.TokenPair{}, ErrUnknownProvider
+	}
+
+	claims := &auth.OIDCStateClaims{}
+	token, err := s.keys.Parse(stateToken, claims)
+	if err != nil || !token.Valid || !claims.VerifyAudience(auth.PurposeOIDCState, true) || claims.Provider != provider ||
+		state == "" || subtle.ConstantTimeCompare([]byte(claims.State), []byte(state)) != 1 {
+		return models.TokenPair{}, ErrInvalidOIDCState
+	}
+
+	identity, err := p.Exchange(ctx, code, claims.CodeVerifier, claims.Nonce)
+	if err != nil {
+		log.Printf("Sign in at %s failed: %v", provider, err)
+		return models.TokenPair{}, ErrOIDCLoginFailed
+	}
+
+	user, err := s.userForIdentity(p.Config, identity, claims.LinkUserID)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	if s.requireVerifiedEmail && user.EmailVerifiedAt == nil {
+		return models.TokenPair{}, ErrEmailNotVerified
+	}
+
+	if user.TOTPEnabled {
+		return models.TokenPair{}, s.mfaChallenge(user)
+	}
+
+	familyID, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	return s.issueTokens(user, familyID)
+}
+
+// userForIdentity returns the user an identity is linked to. Unlinked
+// identities are linked to linkUserID when set, then to the user with the same
+// verified email address when the provider allows it, and finally to a new
+// user when the provider allows sign ups.
+func (s *userService) userForIdentity(cfg oidc.ProviderConfig, identity oidc.Identity, linkUserID uint) (models.User, error) {
+	var user models.User
+
+	var linked models.UserIdentity
+	err := s.db.First(&linked, "provider = ? AND subject = ?", cfg.Name, identity.Subject).Error
+	if err == nil {
+		if linkUserID != 0 && linked.UserID != linkUserID {
+			return user, ErrIdentityLinked
+		}
+		if err := s.db.First(&user, linked.UserID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				log.Printf("Identity %d at %s belongs to deleted user %d", linked.ID, cfg.Name, linked.UserID)
+				return user, ErrOIDCLoginFailed
+			}
+			return user, err
+		}
+		return user, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return user, err
+	}
+
+	switch {
+	case linkUserID != 0:
+		if err := s.db.First(&user, linkUserID).Error; err != nil {
+			return user, err
+		}
+	case s.findByVerifiedEmail(cfg, identity, &user):
+	case cfg.AllowSignUp:
+		if user, err = s.createOIDCUser(identity); err != nil {
+			return user, err
+		}
+	default:
+		return user, ErrOIDCSignUpDisabled
+	}
+
+	link := models.UserIdentity{
+		UserID:   user.ID,
+		Provider: cfg.Name,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+	if err := s.db.Create(&link).Error; err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// findByVerifiedEmail loads the user whose verified email address the provider
+// has verified as well, when the provider links by email
+func (s *userService) findByVerifiedEmail(cfg oidc.ProviderConfig, identity oidc.Identity, user *models.User) bool {
+	if !cfg.LinkByEmail || !identity.EmailVerified || identity.Email == "" {
+		return false
+	}
+
+	email, err := normalizeEmail(identity.Email)
+	if err != nil {
+		return false
+	}
+
+	var found models.User
+	if err := s.db.First(&found, "email = ?", email).Error; err != nil || found.EmailVerifiedAt == nil {
+		return false
+	}
+
+	*user = found
+	return true
+}
+
+// createOIDCUser signs up a user for an identity. The username is taken from
+// the provider and made unique; the password is random, so the user can only
+// sign in through the provider until they reset it.
+func (s *userService) createOIDCUser(identity oidc.Identity) (models.User, error) {
+	user := models.User{Role: auth.RoleUser}
+
+	if email, err := normalizeEmail(identity.Email); err == nil && email != "" && s.ensureEmailAvailable(email, 0) == nil {
+		user.Email = email
+		if identity.EmailVerified {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+	}
+
+	username, err := s.availableUsername(identity)
+	if err != nil {
+		return user, err
+	}
+	user.Username = username
+
+	password, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return user, err
+	}
+	if user.Password, err = s.hasher.Hash(password); err != nil {
+		return user, err
+	}
+
+	if err := s.db.Create(&user).Error; err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// availableUsername derives an unused username from the provider's preferred
+// username or the email address
+func (s *userService) availableUsername(identity oidc.Identity) (string, error) {
+	base := usernameUnsafe.ReplaceAllString(identity.PreferredUsername, "")
+	if base == "" {
+		local, _, _ := strings.Cut(identity.Email, "@")
+		base = usernameUnsafe.ReplaceAllString(local, "")
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		taken, err := s.usernameTaken(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+
+		suffix, err := utils.GenerateRandomToken(3)
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "-" + strings.ToLower(usernameUnsafe.ReplaceAllString(suffix, ""))
+	}
+
+	return "", ErrOIDCLoginFailed
+}
+
+// ListIdentities returns the external identities linked to the caller in ctx
+func (s *userService) ListIdentities(ctx context.Context) ([]models.UserIdentity, error) {
+	user, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var identities []models.UserIdentity
+	if err := s.db.Find(&identities, "user_id = ?", user.ID).Error; err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// UnlinkIdentity removes one of the caller's linked identities. Identities of
+// other users are reported as not found.
+func (s *userService) UnlinkIdentity(ctx context.Context, id string) error {
+	if err := requireLogin(ctx, "unlink identity"); err != nil {
+		return err
+	}
+
+	user, err := s.GetCurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	identityID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	var identity models.UserIdentity
+	if err := s.db.First(&identity, "id = ? AND user_id = ?", identityID, user.ID).Error; err != nil {
+		return err
+	}
+
+	return s.db.Delete(&models.UserIdentity{}, "id = ?", identity.ID).Error
+}
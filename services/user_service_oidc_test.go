@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go-rest-api/auth"
+	mockDB "go-rest-api/database/mocks"
+	"go-rest-api/models"
+	"go-rest-api/oidc"
+	"go-rest-api/oidc/oidctest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"gorm.io/gorm"
+)
+
+const janeSubject = "248289761001"
+
+// newOIDCTestService returns a service that signs in with the fake provider
+// registered as "test"
+func newOIDCTestService(t *testing.T, db *mockDB.MockDatabase, allowSignUp, linkByEmail bool) (*userService, *oidctest.Server) {
+	server := oidctest.NewServer("client-id", "client-secret")
+	t.Cleanup(server.Close)
+
+	provider := oidc.NewProvider(oidc.ProviderConfig{
+		Name:         "test",
+		Issuer:       server.Issuer(),
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "http://localhost:8080/auth/test/callback",
+		AllowSignUp:  allowSignUp,
+		LinkByEmail:  linkByEmail,
+	}, server.Client())
+
+	return &userService{
+		db:            db,
+		keys:          testKeys,
+		hasher:        testHasher,
+		oidcProviders: map[string]*oidc.Provider{"test": provider},
+	}, server
+}
+
+// expectRefreshToken expects the refresh token issued on a successful sign in
+func expectRefreshToken(md *mockDB.MockDatabase) {
+	md.EXPECT().Create(gomock.AssignableToTypeOf(&models.RefreshToken{})).Return(&gorm.DB{}).Times(1)
+}
+
+func Test_userService_OIDCLogin(t *testing.T) {
+	jane := models.User{Model: gorm.Model{ID: 1}, Username: "jane", Email: "jane@example.com", Role: auth.RoleUser}
+	verified := jane
+	now := time.Now()
+	verified.EmailVerifiedAt = &now
+	withTOTP := jane
+	withTOTP.TOTPEnabled = true
+	owner := models.User{Model: gorm.Model{ID: 7}, Username: "rrm", Role: auth.RoleUser}
+
+	expectIdentity := func(md *mockDB.MockDatabase, linked *models.UserIdentity) {
+		call := md.EXPECT().First(gomock.Any(), "provider = ? AND subject = ?", "test", janeSubject)
+		if linked == nil {
+			call.Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			return
+		}
+		call.SetArg(0, *linked).Return(&gorm.DB{}).Times(1)
+	}
+	expectLink := func(md *mockDB.MockDatabase, userID uint) {
+		md.EXPECT().Create(gomock.AssignableToTypeOf(&models.UserIdentity{})).DoAndReturn(func(value interface{}) *gorm.DB {
+			identity := value.(*models.UserIdentity)
+			if identity.UserID != userID || identity.Provider != "test" || identity.Subject != janeSubject {
+				t.Errorf("unexpected linked identity %+v", identity)
+			}
+			return &gorm.DB{}
+		}).Times(1)
+	}
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		link        bool
+		allowSignUp bool
+		linkByEmail bool
+		setup       func(*mockDB.MockDatabase)
+		wantErr     bool
+		errCheck    func(error) bool
+	}{
+		{
+			name: "linked identity",
+			ctx:  context.Background(),
+			setup: func(md *mockDB.MockDatabase) {
+				expectIdentity(md, &models.UserIdentity{ID: 3, UserID: 1, Provider: "test", Subject: janeSubject})
+				md.EXPECT().First(gomock.Any(), uint(1)).SetArg(0, jane).Return(&gorm.DB{}).Times(1)
+				expectRefreshToken(md)
+			},
+		},
+		{
+			name: "linked identity of a deleted user",
+			ctx:  context.Background(),
+			setup: func(md *mockDB.MockDatabase) {
+				expectIdentity(md, &models.UserIdentity{ID: 3, UserID: 1, Provider: "test", Subject: janeSubject})
+				md.EXPECT().First(gomock.Any(), uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+			},
+			wantErr:  true,
+			errCheck: func(err error) bool { return errors.Is(err, ErrOIDCLoginFailed) },
+		},
+		{
+			name:        "linked by verified email",
+			ctx:         context.Background(),
+			linkByEmail: true,
+			setup: func(md *mockDB.MockDatabase) {
+				expectIdentity(md, nil)
+				md.EXPECT().First(gomock.Any(), "email = ?", "jane@example.com").SetArg(0, verified).Return(&gorm.DB{}).Times(1)
+				expectLink(md, 1)
+				expectRefreshToken(md)
+			},
+		},
+		{
+			name:        "unverified local email is not linked",
+			ctx:         context.Background(),
+			linkByEmail: true,
+			setup: func(md *mockDB.MockDatabase) {
+				expectIdentity(md, nil)
+				md.EXPECT().First(gomock.Any(), "email = ?", "jane@example.com").SetArg(0, jane).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr:  true,
+			errCheck: func(err error) bool { return errors.Is(err, ErrOIDCSignUpDisabled) },
+		},
+		{
+			name:        "sign up with a taken username",
+			ctx:         context.Background(),
+			allowSignUp: true,
+			setup: func(md *mockDB.MockDatabase) {
+				expectIdentity(md, nil)
+				md.EXPECT().Unscoped().Return(md).Times(3)
+				md.EXPECT().First(gomock.Any(), "email = ?", "jane@example.com").Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+				md.EXPECT().First(gomock.Any(), "username = ?", "jane").SetArg(0, jane).Return(&gorm.DB{}).Times(1)
+				md.EXPECT().First(gomock.Any(), "username = ?", gomock.Any()).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+				md.EXPECT().Create(gomock.AssignableToTypeOf(&models.User{})).DoAndReturn(func(value interface{}) *gorm.DB {
+					user := value.(*models.User)
+					if !strings.HasPrefix(user.Username, "jane-") || user.Role != auth.RoleUser {
+						t.Errorf("unexpected new user %+v", user)
+					}
+					if user.Email != "jane@example.com" || user.EmailVerifiedAt == nil {
+						t.Errorf("email verified by the provider was not taken over: %+v", user)
+					}
+					user.ID = 9
+					return &gorm.DB{}
+				}).Times(1)
+				expectLink(md, 9)
+				expectRefreshToken(md)
+			},
+		},
+		{
+			name:     "sign up disabled",
+			ctx:      context.Background(),
+			setup:    func(md *mockDB.MockDatabase) { expectIdentity(md, nil) },
+			wantErr:  true,
+			errCheck: func(err error) bool { return errors.Is(err, ErrOIDCSignUpDisabled) },
+		},
+		{
+			name: "second factor required",
+			ctx:  context.Background(),
+			setup: func(md *mockDB.MockDatabase) {
+				expectIdentity(md, &models.UserIdentity{ID: 3, UserID: 1, Provider: "test", Subject: janeSubject})
+				md.EXPECT().First(gomock.Any(), uint(1)).SetArg(0, withTOTP).Return(&gorm.DB{}).Times(1)
+			},
+			wantErr:  true,
+			errCheck: func(err error) bool { var mfaErr *MFARequiredError; return errors.As(err, &mfaErr) },
+		},
+		{
+			name: "link to the caller",
+			ctx:  ownerCtx,
+			link: true,
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, owner).Return(&gorm.DB{}).Times(1)
+				expectIdentity(md, nil)
+				md.EXPECT().First(gomock.Any(), uint(7)).SetArg(0, owner).Return(&gorm.DB{}).Times(1)
+				expectLink(md, 7)
+				expectRefreshToken(md)
+			},
+		},
+		{
+			name: "link an identity of another user",
+			ctx:  ownerCtx,
+			link: true,
+			setup: func(md *mockDB.MockDatabase) {
+				md.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, owner).Return(&gorm.DB{}).Times(1)
+				expectIdentity(md, &models.UserIdentity{ID: 3, UserID: 1, Provider: "test", Subject: janeSubject})
+			},
+			wantErr:  true,
+			errCheck: func(err error) bool { return errors.Is(err, ErrIdentityLinked) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mkdb := mockDB.NewMockDatabase(ctrl)
+			s, server := newOIDCTestService(t, mkdb, tt.allowSignUp, tt.linkByEmail)
+
+			tt.setup(mkdb)
+
+			redirect, err := s.StartOIDCLogin(tt.ctx, "test", tt.link)
+			if err != nil {
+				t.Fatalf("userService.StartOIDCLogin() error = %v", err)
+			}
+			code, state, err := server.Authorize(redirect.AuthorizationURL)
+			if err != nil {
+				t.Fatalf("Authorize() error = %v", err)
+			}
+
+			got, err := s.CompleteOIDCLogin(context.Background(), "test", redirect.StateToken, state, code)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("userService.CompleteOIDCLogin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !tt.errCheck(err) {
+					t.Errorf("userService.CompleteOIDCLogin() error = %v of unexpected type", err)
+				}
+				return
+			}
+			if got.AccessToken == "" || got.RefreshToken == "" {
+				t.Errorf("userService.CompleteOIDCLogin() = %+v, want a token pair", got)
+			}
+		})
+	}
+}
+
+func Test_userService_CompleteOIDCLogin_InvalidState(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+	s, server := newOIDCTestService(t, mkdb, true, false)
+
+	redirect, err := s.StartOIDCLogin(context.Background(), "test", false)
+	if err != nil {
+		t.Fatalf("userService.StartOIDCLogin() error = %v", err)
+	}
+	code, state, err := server.Authorize(redirect.AuthorizationURL)
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+
+	other, err := s.StartOIDCLogin(context.Background(), "test", false)
+	if err != nil {
+		t.Fatalf("userService.StartOIDCLogin() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		stateToken string
+		state      string
+	}{
+		{name: "state of another login", stateToken: other.StateToken, state: state},
+		{name: "missing state", stateToken: redirect.StateToken, state: ""},
+		{name: "missing state token", stateToken: "", state: state},
+		{name: "MFA token as state token", stateToken: signMFAToken(t, "1", 0, auth.PurposeMFA), state: state},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.CompleteOIDCLogin(context.Background(), "test", tt.stateToken, tt.state, code)
+			if !errors.Is(err, ErrInvalidOIDCState) {
+				t.Errorf("userService.CompleteOIDCLogin() error = %v, want %v", err, ErrInvalidOIDCState)
+			}
+		})
+	}
+}
+
+func Test_userService_StartOIDCLogin_UnknownProvider(t *testing.T) {
+	s := &userService{keys: testKeys}
+
+	if _, err := s.StartOIDCLogin(context.Background(), "nope", false); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("userService.StartOIDCLogin() error = %v, want %v", err, ErrUnknownProvider)
+	}
+	if _, err := s.CompleteOIDCLogin(context.Background(), "nope", "", "", ""); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("userService.CompleteOIDCLogin() error = %v, want %v", err, ErrUnknownProvider)
+	}
+}
+
+func Test_userService_UnlinkIdentity(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mkdb := mockDB.NewMockDatabase(ctrl)
+	s := &userService{db: mkdb}
+
+	owner := models.User{Model: gorm.Model{ID: 1}, Username: "rrm", Role: auth.RoleUser}
+
+	mkdb.EXPECT().First(gomock.Any(), "username = ?", "rrm").SetArg(0, owner).Return(&gorm.DB{}).Times(3)
+	mkdb.EXPECT().First(gomock.Any(), "id = ? AND user_id = ?", uint(3), uint(1)).SetArg(0, models.UserIdentity{ID: 3, UserID: 1}).Return(&gorm.DB{}).Times(1)
+	mkdb.EXPECT().Delete(gomock.Any(), "id = ?", uint(3)).Return(&gorm.DB{}).Times(1)
+	mkdb.EXPECT().First(gomock.Any(), "id = ? AND user_id = ?", uint(4), uint(1)).Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Times(1)
+
+	if err := s.UnlinkIdentity(ownerCtx, "3"); err != nil {
+		t.Errorf("userService.UnlinkIdentity() error = %v", err)
+	}
+	if err := s.UnlinkIdentity(ownerCtx, "4"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("userService.UnlinkIdentity() of another user's identity error = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+	if err := s.UnlinkIdentity(ownerCtx, "3 OR 1=1"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("userService.UnlinkIdentity() with an id that is not a number error = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
@@ -2,17 +2,23 @@
 package utils
 
 import (
+	"strings"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
 // HashPassword hashes the given password using bcrypt
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+	return DefaultBcryptHasher.Hash(password)
 }
 
-// CheckPasswordHash compares a plain password with a hashed password
+// CheckPasswordHash compares a plain password with a hashed password. Both
+// bcrypt and Argon2id hashes are accepted.
 func CheckPasswordHash(password, hash string) bool {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		return checkArgon2id(password, hash)
+	}
+
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
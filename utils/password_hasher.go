@@ -0,0 +1,189 @@
+// utils/password_hasher.go
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// PasswordHasher creates encoded password hashes. The algorithm and its
+// parameters are part of the encoded hash, so CheckPasswordHash verifies hashes
+// of any hasher and NeedsRehash tells which ones are outdated.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of password
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether encoded was made with another algorithm or
+	// other parameters than this hasher uses
+	NeedsRehash(encoded string) bool
+}
+
+// BcryptHasher hashes passwords with bcrypt, encoded as "$2a$<cost>$..."
+type BcryptHasher struct {
+	Cost int
+}
+
+// DefaultBcryptHasher matches the hashes HashPassword creates
+var DefaultBcryptHasher = BcryptHasher{Cost: bcrypt.DefaultCost}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(bytes), err
+}
+
+func (h BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher hashes passwords with Argon2id, encoded in the PHC string
+// format "$argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<hash>"
+type Argon2idHasher struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idHasher uses 64 MiB of memory and three passes
+var DefaultArgon2idHasher = Argon2idHasher{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != h.Memory ||
+		params.Iterations != h.Iterations ||
+		params.Parallelism != h.Parallelism ||
+		uint32(len(salt)) != h.SaltLength ||
+		uint32(len(key)) != h.KeyLength
+}
+
+// decodeArgon2id parses a PHC encoded Argon2id hash
+func decodeArgon2id(encoded string) (Argon2idHasher, []byte, []byte, error) {
+	var params Argon2idHasher
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+func checkArgon2id(password, encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// LoadPasswordHasher builds the hasher for new password hashes from the
+// environment:
+//
+//	PASSWORD_HASHER      "argon2id", or "bcrypt" (the default)
+//	BCRYPT_COST          bcrypt cost, defaults to 10
+//	ARGON2_MEMORY        Argon2id memory in KiB, defaults to 65536
+//	ARGON2_ITERATIONS    Argon2id passes, defaults to 3
+//	ARGON2_PARALLELISM   Argon2id lanes, defaults to 2
+func LoadPasswordHasher() (PasswordHasher, error) {
+	switch algorithm := os.Getenv("PASSWORD_HASHER"); algorithm {
+	case "", "bcrypt":
+		hasher := DefaultBcryptHasher
+		if v := os.Getenv("BCRYPT_COST"); v != "" {
+			cost, err := strconv.Atoi(v)
+			if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+				return nil, fmt.Errorf("invalid BCRYPT_COST %q", v)
+			}
+			hasher.Cost = cost
+		}
+		return hasher, nil
+	case "argon2id":
+		hasher := DefaultArgon2idHasher
+		for _, setting := range []struct {
+			name  string
+			value *uint32
+		}{
+			{"ARGON2_MEMORY", &hasher.Memory},
+			{"ARGON2_ITERATIONS", &hasher.Iterations},
+		} {
+			if v := os.Getenv(setting.name); v != "" {
+				n, err := strconv.ParseUint(v, 10, 32)
+				if err != nil || n == 0 {
+					return nil, fmt.Errorf("invalid %s %q", setting.name, v)
+				}
+				*setting.value = uint32(n)
+			}
+		}
+		if v := os.Getenv("ARGON2_PARALLELISM"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 8)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("invalid ARGON2_PARALLELISM %q", v)
+			}
+			hasher.Parallelism = uint8(n)
+		}
+		return hasher, nil
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASHER %q", algorithm)
+	}
+}
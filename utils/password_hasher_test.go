@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var fastArgon2id = Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestPasswordHashers(t *testing.T) {
+	for _, hasher := range []PasswordHasher{BcryptHasher{Cost: bcrypt.MinCost}, fastArgon2id} {
+		encoded, err := hasher.Hash("secret")
+		if err != nil {
+			t.Fatalf("%T.Hash() error = %v", hasher, err)
+		}
+
+		if !CheckPasswordHash("secret", encoded) {
+			t.Errorf("CheckPasswordHash() rejected the password for %q", encoded)
+		}
+		if CheckPasswordHash("wrong", encoded) {
+			t.Errorf("CheckPasswordHash() accepted a wrong password for %q", encoded)
+		}
+		if hasher.NeedsRehash(encoded) {
+			t.Errorf("%T.NeedsRehash() = true for its own hash %q", hasher, encoded)
+		}
+	}
+}
+
+func TestArgon2idHasher_Encoding(t *testing.T) {
+	encoded, err := fastArgon2id.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Hash() = %q, want PHC encoded argon2id", encoded)
+	}
+
+	other, _ := fastArgon2id.Hash("secret")
+	if other == encoded {
+		t.Errorf("Hash() returned the same hash twice, salt is not random")
+	}
+}
+
+func TestCheckPasswordHash_Argon2idReference(t *testing.T) {
+	// Produced by the argon2 reference implementation:
+	// echo -n password | argon2 somesalt -id -t 2 -k 65536 -p 1 -l 32 -e
+	encoded := "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+
+	if !CheckPasswordHash("password", encoded) {
+		t.Errorf("CheckPasswordHash() rejected the reference hash")
+	}
+}
+
+func TestPasswordHasher_NeedsRehash(t *testing.T) {
+	bcryptHash, _ := BcryptHasher{Cost: bcrypt.MinCost}.Hash("secret")
+	argonHash, _ := fastArgon2id.Hash("secret")
+
+	tests := []struct {
+		name    string
+		hasher  PasswordHasher
+		encoded string
+		want    bool
+	}{
+		{name: "bcrypt cost raised", hasher: BcryptHasher{Cost: bcrypt.MinCost + 1}, encoded: bcryptHash, want: true},
+		{name: "bcrypt same cost", hasher: BcryptHasher{Cost: bcrypt.MinCost}, encoded: bcryptHash},
+		{name: "argon2id to bcrypt", hasher: BcryptHasher{Cost: bcrypt.MinCost}, encoded: argonHash, want: true},
+		{name: "bcrypt to argon2id", hasher: fastArgon2id, encoded: bcryptHash, want: true},
+		{name: "argon2id memory raised", hasher: Argon2idHasher{Memory: 2048, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}, encoded: argonHash, want: true},
+		{name: "argon2id same parameters", hasher: fastArgon2id, encoded: argonHash},
+		{name: "garbage", hasher: fastArgon2id, encoded: "$argon2id$v=19$m=1024", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(tt.encoded); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPasswordHasher(t *testing.T) {
+	t.Setenv("PASSWORD_HASHER", "")
+	t.Setenv("BCRYPT_COST", "12")
+	hasher, err := LoadPasswordHasher()
+	if err != nil || hasher != (BcryptHasher{Cost: 12}) {
+		t.Errorf("LoadPasswordHasher() = %v, %v, want bcrypt cost 12", hasher, err)
+	}
+
+	t.Setenv("PASSWORD_HASHER", "argon2id")
+	t.Setenv("ARGON2_MEMORY", "19456")
+	hasher, err = LoadPasswordHasher()
+	want := DefaultArgon2idHasher
+	want.Memory = 19456
+	if err != nil || hasher != want {
+		t.Errorf("LoadPasswordHasher() = %v, %v, want %v", hasher, err, want)
+	}
+
+	t.Setenv("PASSWORD_HASHER", "md5")
+	if _, err := LoadPasswordHasher(); err == nil {
+		t.Errorf("LoadPasswordHasher() with unknown algorithm error = nil")
+	}
+}